@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+var cmdActions = &Command{
+	Name:       "actions",
+	Build:      buildActions,
+	Short:      "commands to work with the async actions of a stack",
+	NeedsStack: true,
+	NeedsOrg:   false,
+}
+
+func buildActions() cli.Command {
+	base := buildBasicCommand()
+	base.Subcommands = []cli.Command{
+		cli.Command{
+			Name:   "list",
+			Usage:  "lists the recent async actions of a stack",
+			Action: runActions,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "limit",
+					Usage: "maximum number of actions to list",
+					Value: 20,
+				},
+				cli.BoolFlag{
+					Name:  "running",
+					Usage: "only list the actions which haven't finished yet",
+				},
+			},
+			Description: `Lists the recent async actions of a stack, like env-var changes, job runs, reboots or scaling, most recent first.
+
+Examples:
+$ cx actions list -s mystack
+$ cx actions list -s mystack --running
+`,
+		},
+		cli.Command{
+			Name:   "show",
+			Usage:  "shows the details of an async action",
+			Action: runShowAction,
+			Description: `Shows the details of an async action.
+
+Examples:
+$ cx actions show -s mystack 1234
+`,
+		},
+		cli.Command{
+			Name:   "wait",
+			Usage:  "waits for one or more async actions to finish",
+			Action: runWaitActions,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "timeout",
+					Usage: "how long to wait for, like 10m or 2h",
+					Value: "30m",
+				},
+				cli.StringFlag{
+					Name:  "interval",
+					Usage: "how often to check the actions",
+					Value: "5s",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-failures",
+					Usage: "exit with 0 when all actions finish, even if some of them failed",
+				},
+			},
+			Description: `Waits for one or more async actions to finish.
+
+The exit status is 0 when all actions succeed, 1 if any of them fail (unless --ignore-failures is used)
+and 2 if they don't finish before the timeout.
+
+Examples:
+$ cx actions wait -s mystack 1234
+$ cx actions wait -s mystack 1234 1235 --timeout 2h --interval 30s
+$ cx --no-wait jobs run -s mystack my_job
+$ cx actions wait -s mystack 1236
+`,
+		},
+		cli.Command{
+			Name:   "cancel",
+			Usage:  "cancels a running async action",
+			Action: runCancelAction,
+			Description: `Cancels a running async action.
+
+The API doesn't support cancelling async actions yet, so this fails for actions which are still running.
+
+Examples:
+$ cx actions cancel -s mystack 1234
+`,
+		},
+	}
+
+	return base
+}
+
+func runActions(c *cli.Context) {
+	stack := mustStack(c)
+
+	actions, err := stackAsyncActions(stack.Uid, c.Int("limit"), c.Bool("running"))
+	must(err)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	printActionList(w, actions)
+}
+
+func printActionList(w io.Writer, actions []cloud66.AsyncResult) {
+	listRec(w, "ID", "ACTION", "RESOURCE", "USER", "STATUS", "STARTED", "DURATION")
+	for _, action := range actions {
+		listRec(w,
+			action.Id,
+			action.Action,
+			action.ResourceType,
+			action.User,
+			asyncActionStatus(action),
+			prettyTime{action.StartedAt},
+			asyncActionDuration(action),
+		)
+	}
+}
+
+func runShowAction(c *cli.Context) {
+	stack := mustStack(c)
+	ids := mustActionIds(c)
+	if len(ids) != 1 {
+		printFatal("Please specify a single action id")
+	}
+
+	action, err := stackAsyncAction(stack.Uid, ids[0])
+	must(err)
+
+	fmt.Printf("Id: %d\n", action.Id)
+	fmt.Printf("Action: %s\n", action.Action)
+	fmt.Printf("Resource: %s %s\n", action.ResourceType, action.ResourceId)
+	fmt.Printf("User: %s\n", action.User)
+	fmt.Printf("Started via: %s\n", action.StartedVia)
+	fmt.Printf("Started at: %s\n", action.StartedAt.Local().Format(time.RFC1123))
+	if action.FinishedAt != nil {
+		fmt.Printf("Finished at: %s\n", action.FinishedAt.Local().Format(time.RFC1123))
+	}
+	fmt.Printf("Duration: %s\n", asyncActionDuration(*action))
+	fmt.Printf("Status: %s\n", asyncActionStatus(*action))
+	if action.FinishedMessage != "" {
+		fmt.Printf("Message: %s\n", action.FinishedMessage)
+	}
+}
+
+func runWaitActions(c *cli.Context) {
+	stack := mustStack(c)
+	ids := mustActionIds(c)
+
+	timeout, err := time.ParseDuration(c.String("timeout"))
+	if err != nil {
+		printFatal("Invalid --timeout %q", c.String("timeout"))
+	}
+	interval, err := time.ParseDuration(c.String("interval"))
+	if err != nil || interval <= 0 {
+		printFatal("Invalid --interval %q", c.String("interval"))
+	}
+
+	deadline := time.Now().Add(timeout)
+	pending := ids
+	failed := 0
+	for {
+		var stillPending []int
+		for _, id := range pending {
+			action, err := stackAsyncAction(stack.Uid, id)
+			must(err)
+
+			if action.FinishedAt == nil {
+				stillPending = append(stillPending, id)
+				continue
+			}
+
+			success := action.FinishedSuccess != nil && *action.FinishedSuccess
+			if !success {
+				failed++
+			}
+			fmt.Printf("Action %d (%s) %s after %s: %s\n", id, action.Action, asyncActionStatus(*action), asyncActionDuration(*action), action.FinishedMessage)
+			fireHooks(asyncActionHookPayload(id, stack.Uid, success, action.FinishedMessage))
+		}
+
+		pending = stillPending
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			printError("Timed out after %s waiting for %d action(s)", timeout, len(pending))
+			os.Exit(2)
+		}
+		time.Sleep(interval)
+	}
+
+	if failed > 0 && !c.Bool("ignore-failures") {
+		printFatal("%d of %d action(s) failed", failed, len(ids))
+	}
+}
+
+func runCancelAction(c *cli.Context) {
+	stack := mustStack(c)
+	ids := mustActionIds(c)
+
+	for _, id := range ids {
+		action, err := stackAsyncAction(stack.Uid, id)
+		must(err)
+		if action.FinishedAt != nil {
+			printWarning("Action %d has already finished", id)
+			continue
+		}
+		printFatal("Unable to cancel action %d (%s): cancelling async actions is not supported by the API", id, action.Action)
+	}
+}
+
+// returned by waitStackAsyncAction with --no-wait, once it has printed how to wait for the action later
+var errAsyncActionNotWaited = errors.New("the async action was not waited for")
+
+// waits for an async action to finish like client.WaitStackAsyncAction, then runs the async-action-finished hooks.
+// With --no-wait it prints the action id and returns errAsyncActionNotWaited instead, for the command to stop there
+func waitStackAsyncAction(asyncId int, stackUid string, checkFrequency time.Duration, timeout time.Duration, showWorkingIndicator bool) (*cloud66.GenericResponse, error) {
+	if flagNoWait {
+		fmt.Printf("Started async action %d. Use 'cx actions wait %d' to wait for it to finish\n", asyncId, asyncId)
+		return nil, errAsyncActionNotWaited
+	}
+	return waitForStackAsyncAction(asyncId, stackUid, checkFrequency, timeout, showWorkingIndicator)
+}
+
+// like waitStackAsyncAction, but waits even with --no-wait. For commands which can't carry on until the action is done
+func waitForStackAsyncAction(asyncId int, stackUid string, checkFrequency time.Duration, timeout time.Duration, showWorkingIndicator bool) (*cloud66.GenericResponse, error) {
+	genericRes, err := client.WaitStackAsyncAction(asyncId, stackUid, checkFrequency, timeout, showWorkingIndicator)
+	if err != nil {
+		fireHooks(asyncActionHookPayload(asyncId, stackUid, false, err.Error()))
+	} else {
+		fireHooks(asyncActionHookPayload(asyncId, stackUid, genericRes.Status, genericRes.Message))
+	}
+
+	return genericRes, err
+}
+
+func mustActionIds(c *cli.Context) []int {
+	if len(c.Args()) == 0 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+
+	var ids []int
+	for _, arg := range c.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			printFatal("Invalid action id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// fetches the most recent async actions of a stack
+func stackAsyncActions(stackUid string, limit int, runningOnly bool) ([]cloud66.AsyncResult, error) {
+	queryStrings := map[string]string{"page": "1"}
+
+	var result []cloud66.AsyncResult
+	for {
+		var page []cloud66.AsyncResult
+		var p cloud66.Pagination
+		if err := client.APIReq(&page, "GET", "/stacks/"+stackUid+"/actions.json", nil, queryStrings, &p); err != nil {
+			return nil, err
+		}
+
+		for _, action := range page {
+			if !runningOnly || action.FinishedAt == nil {
+				result = append(result, action)
+			}
+		}
+
+		if len(result) >= limit || p.Current >= p.Next {
+			break
+		}
+		queryStrings["page"] = strconv.Itoa(p.Next)
+	}
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func stackAsyncAction(stackUid string, id int) (*cloud66.AsyncResult, error) {
+	var action *cloud66.AsyncResult
+	return action, client.Get(&action, asyncActionPath(stackUid, id), nil, nil)
+}
+
+func asyncActionPath(stackUid string, id int) string {
+	return "/stacks/" + stackUid + "/actions/" + strconv.Itoa(id) + ".json"
+}
+
+func asyncActionStatus(action cloud66.AsyncResult) string {
+	if action.FinishedAt == nil {
+		return "running"
+	}
+	if action.FinishedSuccess != nil && *action.FinishedSuccess {
+		return "succeeded"
+	}
+	return "failed"
+}
+
+func asyncActionDuration(action cloud66.AsyncResult) string {
+	finishedAt := time.Now()
+	if action.FinishedAt != nil {
+		finishedAt = *action.FinishedAt
+	}
+	return prettyDuration{finishedAt.Sub(action.StartedAt)}.String()
+}
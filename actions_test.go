@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const runningActionJSON = `{"id":%d,"user":"jim@example.com","resource_type":"Stack","resource_id":"1","action":"stack_redeploy","started_via":"api","started_at":"2020-01-02T10:00:00Z","finished_at":null,"finished_success":null,"finished_message":null}`
+const finishedActionJSON = `{"id":%d,"user":"jim@example.com","resource_type":"Stack","resource_id":"1","action":"stack_redeploy","started_via":"api","started_at":"2020-01-02T10:00:00Z","finished_at":"2020-01-02T10:01:00Z","finished_success":%t,"finished_message":"done"}`
+
+func actionResponse(body string) string {
+	return `{"response":` + body + `}`
+}
+
+func actionListResponse(actions []string, page int, next int) string {
+	pagination := `{"previous":null,"next":` + strconv.Itoa(next) + `,"current":` + strconv.Itoa(page) + `,"per_page":2,"count":3,"pages":2}`
+	return `{"response":[` + strings.Join(actions, ",") + `],"count":3,"pagination":` + pagination + `}`
+}
+
+var _ = Describe("Actions", func() {
+	var restoreClient func()
+	var restoreHome func()
+
+	BeforeEach(func() {
+		// other suites can leave pending mocks behind
+		gock.Off()
+		restoreHome = useTempHome()
+		restoreClient = MockApiClient()
+		flagStack = &cloud66.Stack{Uid: "abc", Name: "mystack"}
+	})
+
+	AfterEach(func() {
+		gock.Off()
+		flagStack = nil
+		flagNoWait = false
+		restoreClient()
+		restoreHome()
+	})
+
+	Context("listing", func() {
+		It("should go through the pages up to the limit", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions.json").MatchParam("page", "1").
+				Reply(200).
+				BodyString(actionListResponse([]string{fmt.Sprintf(runningActionJSON, 3), fmt.Sprintf(finishedActionJSON, 2, true)}, 1, 2))
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions.json").MatchParam("page", "2").
+				Reply(200).
+				BodyString(actionListResponse([]string{fmt.Sprintf(finishedActionJSON, 1, false)}, 2, 2))
+
+			actions, err := stackAsyncActions("abc", 10, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actions).To(HaveLen(3))
+			Expect(asyncActionStatus(actions[0])).To(Equal("running"))
+			Expect(asyncActionStatus(actions[1])).To(Equal("succeeded"))
+			Expect(asyncActionStatus(actions[2])).To(Equal("failed"))
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+
+		It("should stop at the limit and only keep running actions with --running", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions.json").MatchParam("page", "1").
+				Reply(200).
+				BodyString(actionListResponse([]string{fmt.Sprintf(runningActionJSON, 3), fmt.Sprintf(finishedActionJSON, 2, true)}, 1, 2))
+
+			actions, err := stackAsyncActions("abc", 1, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actions).To(HaveLen(1))
+			Expect(actions[0].Id).To(Equal(3))
+		})
+
+		It("should print the actions", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions.json").
+				Reply(200).
+				BodyString(actionListResponse([]string{fmt.Sprintf(finishedActionJSON, 2, true)}, 1, 1))
+
+			flagSet := flag.NewFlagSet("test", 0)
+			flagSet.Int("limit", 10, "")
+			flagSet.Bool("running", false, "")
+
+			StartCaptureStdout()
+			runActions(cli.NewContext(nil, flagSet, nil))
+			output := StopCaptureStdout()
+
+			Expect(output[0]).To(HavePrefix("ID"))
+			Expect(output[1]).To(MatchRegexp(`^2\s+stack_redeploy\s+Stack\s+jim@example.com\s+succeeded`))
+		})
+	})
+
+	Context("waiting", func() {
+		It("should poll the actions until they finish", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions/7.json").
+				Reply(200).
+				BodyString(actionResponse(fmt.Sprintf(runningActionJSON, 7)))
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions/7.json").
+				Reply(200).
+				BodyString(actionResponse(fmt.Sprintf(finishedActionJSON, 7, true)))
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions/8.json").
+				Reply(200).
+				BodyString(actionResponse(fmt.Sprintf(finishedActionJSON, 8, true)))
+
+			flagSet := flag.NewFlagSet("test", 0)
+			flagSet.String("timeout", "1m", "")
+			flagSet.String("interval", "10ms", "")
+			flagSet.Bool("ignore-failures", false, "")
+			flagSet.Parse([]string{"7", "8"})
+
+			StartCaptureStdout()
+			runWaitActions(cli.NewContext(nil, flagSet, nil))
+			output := StopCaptureStdout()
+
+			Expect(output[0]).To(HavePrefix("Action 8 (stack_redeploy) succeeded"))
+			Expect(output[1]).To(HavePrefix("Action 7 (stack_redeploy) succeeded"))
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+
+		It("should return without waiting with --no-wait", func() {
+			flagNoWait = true
+
+			StartCaptureStdout()
+			genericRes, err := waitStackAsyncAction(7, "abc", time.Millisecond, time.Minute, false)
+			output := StopCaptureStdout()
+
+			Expect(err).To(Equal(errAsyncActionNotWaited))
+			Expect(genericRes).To(BeNil())
+			Expect(output[0]).To(Equal("Started async action 7. Use 'cx actions wait 7' to wait for it to finish"))
+		})
+
+		It("should wait even with --no-wait when the command needs the action to finish", func() {
+			flagNoWait = true
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions/7.json").
+				Reply(200).
+				BodyString(actionResponse(fmt.Sprintf(finishedActionJSON, 7, false)))
+
+			genericRes, err := waitForStackAsyncAction(7, "abc", time.Millisecond, time.Minute, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(genericRes.Status).To(BeFalse())
+			Expect(genericRes.Message).To(Equal("done"))
+		})
+	})
+
+	Context("cancelling", func() {
+		It("should skip actions which have already finished", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/actions/8.json").
+				Reply(200).
+				BodyString(actionResponse(fmt.Sprintf(finishedActionJSON, 8, true)))
+
+			flagSet := flag.NewFlagSet("test", 0)
+			flagSet.Parse([]string{"8"})
+
+			StartCaptureStdout()
+			runCancelAction(cli.NewContext(nil, flagSet, nil))
+			StopCaptureStdout()
+
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+	})
+})
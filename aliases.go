@@ -7,7 +7,7 @@ func populateAliases(commands []*Command) []*Command {
 	aliasMap := make(map[string][]string)
 	aliasMap["backups"] = []string{"backup"}
 	aliasMap["containers"] = []string{"container"}
-	aliasMap["databases"] = []string{"database"}
+	aliasMap["databases"] = []string{"database", "db"}
 	aliasMap["jobs"] = []string{"job"}
 	aliasMap["servers"] = []string{"server"}
 	aliasMap["services"] = []string{"service"}
@@ -15,6 +15,9 @@ func runClearCaches(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endClearCaches(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -30,5 +33,5 @@ func startClearCaches(stackUid string) (*int, error) {
 }
 
 func endClearCaches(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, false)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, false)
 }
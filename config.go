@@ -7,10 +7,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/cloud66/cli"
+	"gopkg.in/go-yaml/yaml.v2"
 )
 
 var cmdConfig = &Command{
@@ -86,6 +88,31 @@ func buildConfig() cli.Command {
 					Name:  "faye-endpoint",
 					Usage: "URL for realtime push service. Used for OnPrem and Dedicated installations of Cloud 66 Enterprise",
 				},
+				cli.StringFlag{
+					Name:  "policy-file",
+					Usage: "YAML or JSON file with the deployment policy to enforce for this profile",
+				},
+				cli.StringFlag{
+					Name:  "hooks-file",
+					Usage: "YAML or JSON file with the hooks to run on deployment events for this profile",
+				},
+				cli.StringFlag{
+					Name:  "secret-patterns",
+					Usage: "comma separated glob patterns of keys whose values are masked in output, on top of the built-in ones (ie. *_PASSWORD, *_TOKEN)",
+				},
+				cli.StringFlag{
+					Name:  "ssh-key-max-age",
+					Usage: "how long fetched stack SSH keys are used before being fetched again (ie. 12h). 0 keeps them until purged. Defaults to 24h",
+				},
+				cli.StringFlag{
+					Name:  "ssh-key-storage",
+					Usage: "where fetched stack SSH keys are kept: file (under ~/.cloud66/ssh-keys) or agent (loaded into the running ssh-agent). Defaults to file",
+				},
+				cli.StringSliceFlag{
+					Name:  "gateway-key",
+					Usage: "key of a deploy gateway, as name=path, used to connect to servers behind it. Repeatable. Use name= to remove one",
+					Value: &cli.StringSlice{},
+				},
 				cli.BoolFlag{
 					Name:  "auto",
 					Usage: "Tries to pull configuration from the server provided by base-url",
@@ -131,10 +158,94 @@ cx config create foo --org acme
 					Name:  "faye-endpoint",
 					Usage: "URL for realtime push service. Used for OnPrem and Dedicated installations of Cloud 66 Enterprise",
 				},
+				cli.StringFlag{
+					Name:  "policy-file",
+					Usage: "YAML or JSON file with the deployment policy to enforce for this profile",
+				},
+				cli.StringFlag{
+					Name:  "hooks-file",
+					Usage: "YAML or JSON file with the hooks to run on deployment events for this profile",
+				},
+				cli.StringFlag{
+					Name:  "secret-patterns",
+					Usage: "comma separated glob patterns of keys whose values are masked in output, on top of the built-in ones (ie. *_PASSWORD, *_TOKEN)",
+				},
+				cli.StringFlag{
+					Name:  "ssh-key-max-age",
+					Usage: "how long fetched stack SSH keys are used before being fetched again (ie. 12h). 0 keeps them until purged. Defaults to 24h",
+				},
+				cli.StringFlag{
+					Name:  "ssh-key-storage",
+					Usage: "where fetched stack SSH keys are kept: file (under ~/.cloud66/ssh-keys) or agent (loaded into the running ssh-agent). Defaults to file",
+				},
+				cli.StringSliceFlag{
+					Name:  "gateway-key",
+					Usage: "key of a deploy gateway, as name=path, used to connect to servers behind it. Repeatable. Use name= to remove one",
+					Value: &cli.StringSlice{},
+				},
 			},
 			Description: `
 Example:
 cx config update foo --org acme
+cx config update foo --policy-file policy.yml
+cx config update foo --hooks-file hooks.yml
+cx config update foo --secret-patterns "*_PASS,STRIPE_*"
+cx config update foo --ssh-key-max-age 8h --ssh-key-storage agent
+cx config update foo --gateway-key aws_bastion=~/.ssh/bastion.pem
+
+The policy file holds freeze windows and per environment rules checked before deploy-type
+commands (redeploy, stacks reboot, formations deploy and env-vars set):
+
+freeze_windows:
+- name: weekend
+  environments: [production]
+  timezone: Europe/London
+  cron: "0 17 * * 5"   # every Friday at 17:00...
+  duration: 64h        # ...until Monday 09:00
+- name: holidays
+  timezone: Europe/London
+  start: "2026-12-20 00:00"
+  end: "2027-01-04 00:00"
+environments:
+  production:
+    deploy_strategies: [serial, rolling]   # use "default" to allow deploying without --deploy-strategy
+    git_refs: ['^v[0-9]+\.[0-9]+\.[0-9]+$']
+    required_approvals: 1
+    approvers: [alice, bob]
+
+The same policy can be placed under the "policy" key of a .cx.yml file.
+Use --override-policy "reason" on a command to run it anyway. Overrides are recorded in ~/.cloud66/policy-audit.log
+
+The hooks file lists commands and webhooks to run when cx drives a deployment (redeploy, formations deploy and
+env-vars set with the immediately strategy), or finishes waiting for an async action:
+
+pre-deploy:                 # a failing pre-deploy hook stops the deployment
+- command: ./scripts/check-release.sh
+post-deploy-success:
+- url: https://hooks.example.com/deployments
+  secret: $DEPLOY_HOOK_SECRET
+post-deploy-failure:
+- command: notify-send "deployment failed"
+  timeout: 30s
+async-action-finished:
+- url: https://hooks.example.com/actions
+
+Commands are run with the shell and receive a JSON payload describing the event on stdin, and the event name in $CX_EVENT.
+Webhooks receive the same payload as a POST, with the event name in the X-Cx-Event header and, when a secret is given,
+its HMAC-SHA256 signature in the X-Cx-Signature header (as sha256=<hex>).
+Post-deploy hooks only run when cx waits for the deployment to finish (ie. redeploy --listen).
+The same hooks can be placed under the "hooks" key of a .cx.yml file.
+
+Values of environment variables and settings with keys matching *PASSWORD*, *SECRET*, *TOKEN*, *_KEY and similar
+patterns, as well as credentials in URLs, are masked in output unless --reveal is used. Use --secret-patterns to
+mask more keys, or list them under the "secret_patterns" key of a .cx.yml file. Use --secret-patterns "" to clear them.
+
+Stack SSH keys fetched by cx are fetched again once older than --ssh-key-max-age. With --ssh-key-storage agent they
+are loaded into the running ssh-agent with the same lifetime instead of being written to disk. Use 'cx ssh keys' to
+list or purge them.
+
+Servers behind a deploy gateway are connected to with the key set for the gateway with --gateway-key, unless
+another one is given to the command.
 `,
 		},
 	}
@@ -170,6 +281,33 @@ func runShowConfig(c *cli.Context) {
 			fmt.Printf("ApiURL: %s\n", profile.ApiURL)
 			fmt.Printf("BaseURL: %s\n", profile.BaseURL)
 			fmt.Printf("FayeEndpoint: %s\n", profile.FayeEndpoint)
+			if profile.Policy != nil {
+				fmt.Println()
+				fmt.Printf("Policy: %d freeze window(s), rules for %d environment(s)\n", len(profile.Policy.FreezeWindows), len(profile.Policy.Environments))
+			}
+			if len(profile.Hooks) > 0 {
+				fmt.Println()
+				for _, event := range hookEvents {
+					if hooks := profile.Hooks[event]; len(hooks) > 0 {
+						fmt.Printf("Hooks (%s): %d\n", event, len(hooks))
+					}
+				}
+			}
+			if len(profile.SecretPatterns) > 0 {
+				fmt.Println()
+				fmt.Printf("Secret patterns: %s\n", strings.Join(profile.SecretPatterns, ", "))
+			}
+			if profile.SshKeyMaxAge != "" || profile.SshKeyStorage != "" {
+				fmt.Println()
+				fmt.Printf("SSH key max age: %s\n", profile.SshKeyMaxAge)
+				fmt.Printf("SSH key storage: %s\n", profile.SshKeyStorage)
+			}
+			if len(profile.GatewayKeys) > 0 {
+				fmt.Println()
+				for _, name := range sortedKeysOf(profile.GatewayKeys) {
+					fmt.Printf("Gateway key (%s): %s\n", name, profile.GatewayKeys[name])
+				}
+			}
 			return
 		}
 	}
@@ -259,6 +397,15 @@ func runCreateConfig(c *cli.Context) {
 	clientSecret := c.String("client-secret")
 	auto := c.Bool("auto")
 
+	policy, err := readPolicyFile(c.String("policy-file"))
+	if err != nil {
+		printFatal("error reading policy file %s", err)
+	}
+	hooks, err := readHooksFile(c.String("hooks-file"))
+	if err != nil {
+		printFatal("error reading hooks file %s", err)
+	}
+
 	if apiURL == "" {
 		apiURL = defProfile.ApiURL
 	}
@@ -296,6 +443,14 @@ func runCreateConfig(c *cli.Context) {
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		TokenFile:    fmt.Sprintf("cx_%s.json", strings.ToLower(name)),
+		Policy:       policy,
+		Hooks:        hooks,
+
+		SecretPatterns: parseSecretPatterns(c.String("secret-patterns")),
+
+		SshKeyMaxAge:  parseSshKeyMaxAgeFlag(c.String("ssh-key-max-age")),
+		SshKeyStorage: parseSshKeyStorageFlag(c.String("ssh-key-storage")),
+		GatewayKeys:   parseGatewayKeys(nil, c.StringSlice("gateway-key")),
 	}
 
 	profiles := readProfiles()
@@ -398,6 +553,38 @@ func runUpdateConfig(c *cli.Context) {
 		clientSecret = profile.ClientSecret
 	}
 
+	policy := profile.Policy
+	if c.String("policy-file") != "" {
+		var err error
+		policy, err = readPolicyFile(c.String("policy-file"))
+		if err != nil {
+			printFatal("error reading policy file %s", err)
+		}
+	}
+
+	hooks := profile.Hooks
+	if c.String("hooks-file") != "" {
+		var err error
+		hooks, err = readHooksFile(c.String("hooks-file"))
+		if err != nil {
+			printFatal("error reading hooks file %s", err)
+		}
+	}
+
+	secretPatterns := profile.SecretPatterns
+	if c.IsSet("secret-patterns") {
+		secretPatterns = parseSecretPatterns(c.String("secret-patterns"))
+	}
+
+	sshKeyMaxAge := profile.SshKeyMaxAge
+	if c.IsSet("ssh-key-max-age") {
+		sshKeyMaxAge = parseSshKeyMaxAgeFlag(c.String("ssh-key-max-age"))
+	}
+	sshKeyStorage := profile.SshKeyStorage
+	if c.IsSet("ssh-key-storage") {
+		sshKeyStorage = parseSshKeyStorageFlag(c.String("ssh-key-storage"))
+	}
+
 	newProfile := &Profile{
 		ApiURL:       apiURL,
 		BaseURL:      baseURL,
@@ -407,6 +594,14 @@ func runUpdateConfig(c *cli.Context) {
 		ClientSecret: clientSecret,
 		Name:         name,
 		TokenFile:    fmt.Sprintf("cx_%s.json", strings.ToLower(name)),
+		Policy:       policy,
+		Hooks:        hooks,
+
+		SecretPatterns: secretPatterns,
+
+		SshKeyMaxAge:  sshKeyMaxAge,
+		SshKeyStorage: sshKeyStorage,
+		GatewayKeys:   parseGatewayKeys(profile.GatewayKeys, c.StringSlice("gateway-key")),
 	}
 
 	profiles.Profiles[name] = newProfile
@@ -438,6 +633,84 @@ func findProfile(profiles *Profiles, name string) *Profile {
 	return nil
 }
 
+// reads a deployment policy from a YAML (or JSON) file. Returns nil if no file is given
+func readPolicyFile(filename string) (*deployPolicy, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(expandPath(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	var policy *deployPolicy
+	if err = yaml.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// reads deployment event hooks from a YAML (or JSON) file. Returns nil if no file is given
+func readHooksFile(filename string) (deployHooks, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(expandPath(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks deployHooks
+	if err = yaml.Unmarshal(data, &hooks); err != nil {
+		return nil, err
+	}
+
+	for event := range hooks {
+		if stringsIndex(hookEvents, event) == -1 {
+			return nil, fmt.Errorf("unknown hook event %q. Valid events are %s", event, strings.Join(hookEvents, ", "))
+		}
+	}
+
+	return hooks, nil
+}
+
+// splits comma separated secret key patterns. Invalid patterns are fatal
+func parseSecretPatterns(value string) []string {
+	var result []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			printFatal("invalid secret pattern %q", pattern)
+		}
+		result = append(result, pattern)
+	}
+	return result
+}
+
+// validates the SSH key max age. Invalid values are fatal
+func parseSshKeyMaxAgeFlag(value string) string {
+	if value == "" {
+		return ""
+	}
+	if _, err := parseSshKeyMaxAge(value); err != nil {
+		printFatal("%s", err.Error())
+	}
+	return value
+}
+
+// validates the SSH key storage. Invalid values are fatal
+func parseSshKeyStorageFlag(value string) string {
+	if value != "" && value != sshKeyStorageFile && value != sshKeyStorageAgent {
+		printFatal("invalid SSH key storage %q. Use %s or %s", value, sshKeyStorageFile, sshKeyStorageAgent)
+	}
+	return value
+}
+
 func getCxConfig(entryPoint string) (*cxConfig, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/api/tooling/cx/config", entryPoint))
 	if err != nil {
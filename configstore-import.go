@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"gopkg.in/go-yaml/yaml.v2"
+)
+
+// configStoreChange is the difference of a single record between a file and the ConfigStore
+type configStoreChange struct {
+	Namespace configStoreNamespace
+	Record    cloud66.ConfigStoreRecord
+	Existing  *cloud66.ConfigStoreRecord
+}
+
+func runConfigStoreImport(c *cli.Context) {
+	filename := c.String("file")
+	if filename == "" {
+		printFatal("No file provided. Please use --file to specify a YAML file, or - for stdin")
+	}
+
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(expandPath(filename))
+	}
+	must(err)
+
+	var bundled cloud66.BundledConfigStoreRecords
+	if err := yaml.Unmarshal(data, &bundled); err != nil {
+		printFatal("Invalid ConfigStore records file: %s", err.Error())
+	}
+
+	// records go to the namespace of their scope, or the one chosen with --account
+	defaultScope := cloud66.BundledConfigStoreStackScope
+	if c.Bool("account") {
+		defaultScope = cloud66.BundledConfigStoreAccountScope
+	}
+	namespaces := make(map[string]configStoreNamespace)
+	current := make(map[string][]cloud66.ConfigStoreRecord)
+	for _, record := range bundled.Records {
+		scope := record.Scope
+		if scope == "" {
+			scope = defaultScope
+		}
+		if _, ok := namespaces[scope]; ok {
+			continue
+		}
+		switch scope {
+		case cloud66.BundledConfigStoreAccountScope:
+			namespaces[scope] = mustAccountConfigStoreNamespace(c)
+		case cloud66.BundledConfigStoreStackScope:
+			namespaces[scope] = mustStackConfigStoreNamespace(c)
+		default:
+			printFatal("ConfigStore record scope %s is not supported. Supported values are: %s, %s.", record.Scope, cloud66.BundledConfigStoreAccountScope, cloud66.BundledConfigStoreStackScope)
+		}
+		current[scope], err = client.GetConfigStoreRecords(namespaces[scope].Namespace)
+		must(err)
+	}
+
+	var changes []configStoreChange
+	for _, record := range bundled.Records {
+		scope := record.Scope
+		if scope == "" {
+			scope = defaultScope
+		}
+		change := diffConfigStoreRecord(current[scope], record.ConfigStoreRecord)
+		if change != nil {
+			change.Namespace = namespaces[scope]
+			changes = append(changes, *change)
+		}
+	}
+
+	printConfigStoreChanges(os.Stdout, changes, newSecretReveal(c))
+	if c.Bool("diff") || len(changes) == 0 {
+		return
+	}
+
+	if !c.Bool("y") {
+		if filename == "-" {
+			printFatal("Use -y to apply changes read from stdin")
+		}
+		mustConfirm(fmt.Sprintf("Apply %d change(s)? [yes/N]", len(changes)), "yes")
+	}
+
+	for _, change := range changes {
+		if err := saveConfigStoreRecord(change.Namespace.Namespace, change.Record, change.Existing != nil); err != nil {
+			printFatal("%s: %s", change.Record.Key, err.Error())
+		}
+	}
+	fmt.Printf("%d record(s) saved\n", len(changes))
+}
+
+// returns the change needed to make the record as given, or nil if it already is
+func diffConfigStoreRecord(records []cloud66.ConfigStoreRecord, record cloud66.ConfigStoreRecord) *configStoreChange {
+	for idx := range records {
+		existing := records[idx]
+		if existing.Key != record.Key {
+			continue
+		}
+		if existing.RawValue == record.RawValue && existing.Ttl == record.Ttl && sameConfigStoreMetadata(existing.Metadata, record.Metadata) {
+			return nil
+		}
+		return &configStoreChange{Record: record, Existing: &existing}
+	}
+	return &configStoreChange{Record: record}
+}
+
+// missing and empty metadata are the same
+func sameConfigStoreMetadata(a map[string]string, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func printConfigStoreChanges(w io.Writer, changes []configStoreChange, reveal secretReveal) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+
+	for _, change := range changes {
+		value := configStoreValue(change.Record, reveal)
+		if change.Existing == nil {
+			fmt.Fprintf(w, "+ %s: %s=%s\n", change.Namespace, change.Record.Key, value)
+			continue
+		}
+		fmt.Fprintf(w, "~ %s: %s=%s (was %s)\n", change.Namespace, change.Record.Key, value, configStoreValue(*change.Existing, reveal))
+		if change.Existing.Ttl != change.Record.Ttl {
+			fmt.Fprintf(w, "    ttl: %s (was %s)\n", configStoreTtl(change.Record.Ttl), configStoreTtl(change.Existing.Ttl))
+		}
+		if !sameConfigStoreMetadata(change.Existing.Metadata, change.Record.Metadata) {
+			fmt.Fprintf(w, "    metadata: %s (was %s)\n", configStoreMetadata(change.Record.Metadata), configStoreMetadata(change.Existing.Metadata))
+		}
+	}
+}
+
+func runConfigStoreExport(c *cli.Context) {
+	var namespaces []configStoreNamespace
+	if c.Bool("all") {
+		namespaces = append(namespaces, mustAccountConfigStoreNamespace(c), mustStackConfigStoreNamespace(c))
+	} else {
+		namespaces = append(namespaces, mustConfigStoreNamespace(c))
+	}
+
+	bundled := cloud66.BundledConfigStoreRecords{Records: make([]cloud66.BundledConfigStoreRecord, 0)}
+	for _, namespace := range namespaces {
+		records, err := client.GetConfigStoreRecords(namespace.Namespace)
+		must(err)
+		for _, record := range records {
+			bundled.Records = append(bundled.Records, cloud66.BundledConfigStoreRecord{ConfigStoreRecord: record, Scope: namespace.Scope})
+		}
+	}
+
+	data, err := yaml.Marshal(&bundled)
+	must(err)
+
+	output := c.String("output")
+	if output == "" {
+		fmt.Print(string(data))
+		return
+	}
+	must(ioutil.WriteFile(expandPath(output), data, 0600))
+	fmt.Printf("%d record(s) written to %s\n", len(bundled.Records), output)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+func runConfigStoreSet(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+	key := c.Args()[0]
+	value, err := resolveEnvVarValue(c.Args()[1])
+	if err != nil {
+		printFatal("%s: %s", key, err.Error())
+	}
+
+	metadata, err := parseConfigStoreMetadata(c.StringSlice("metadata"))
+	if err != nil {
+		printFatal("%s", err.Error())
+	}
+
+	namespace := mustConfigStoreNamespace(c)
+	existing, err := findConfigStoreRecord(namespace.Namespace, key)
+	must(err)
+
+	record := cloud66.ConfigStoreRecord{Key: key, RawValue: value, Metadata: metadata}
+	if existing != nil {
+		record.Ttl = existing.Ttl
+		if len(metadata) == 0 {
+			record.Metadata = existing.Metadata
+		}
+	}
+	if c.IsSet("ttl") {
+		ttl, err := time.ParseDuration(c.String("ttl"))
+		if err != nil {
+			printFatal("Invalid --ttl %q. Use values like 30m, 24h or 0 for a record that doesn't expire", c.String("ttl"))
+		}
+		record.Ttl = int(ttl.Seconds())
+	}
+
+	must(saveConfigStoreRecord(namespace.Namespace, record, existing != nil))
+	if existing != nil {
+		fmt.Printf("%s updated in %s\n", key, namespace)
+	} else {
+		fmt.Printf("%s created in %s\n", key, namespace)
+	}
+}
+
+func runConfigStoreDelete(c *cli.Context) {
+	keys := c.Args()
+	if len(keys) == 0 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+	namespace := mustConfigStoreNamespace(c)
+
+	if !c.Bool("y") {
+		mustConfirm(fmt.Sprintf("Delete %s from %s? [yes/N]", strings.Join(keys, ", "), namespace), "yes")
+	}
+
+	for _, key := range keys {
+		_, err := client.DeleteConfigStoreRecord(namespace.Namespace, key)
+		if err != nil {
+			printFatal("%s: %s", key, err.Error())
+		}
+		fmt.Printf("%s deleted from %s\n", key, namespace)
+	}
+}
+
+func saveConfigStoreRecord(namespace string, record cloud66.ConfigStoreRecord, exists bool) error {
+	if exists {
+		_, err := client.UpdateConfigStoreRecord(namespace, record.Key, &record)
+		return err
+	}
+	_, err := client.CreateConfigStoreRecord(namespace, &record)
+	return err
+}
+
+func parseConfigStoreMetadata(values []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --metadata %q. Use key=value", value)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+var cmdConfigStore = &Command{
+	Name:       "configstore",
+	Build:      buildConfigStore,
+	Short:      "commands to work with the ConfigStore records of a stack or an account",
+	NeedsStack: false,
+	NeedsOrg:   false,
+}
+
+// configStoreNamespace is the ConfigStore namespace of a stack or an account
+type configStoreNamespace struct {
+	Scope     string
+	Name      string
+	Namespace string
+}
+
+func (n configStoreNamespace) String() string {
+	return fmt.Sprintf("%s %s", n.Scope, n.Name)
+}
+
+// flags choosing the namespace, added to all configstore subcommands
+func configStoreFlags(flags ...cli.Flag) []cli.Flag {
+	return append(flags,
+		cli.BoolFlag{
+			Name:  "account",
+			Usage: "use the ConfigStore namespace of the account instead of the stack",
+		},
+		cli.StringFlag{
+			Name:  "org",
+			Usage: "full or partial organization name. Used with --account",
+		},
+		cli.StringFlag{
+			Name:  "stack,s",
+			Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+		},
+		cli.StringFlag{
+			Name:  "environment,e",
+			Usage: "full or partial environment name",
+		},
+	)
+}
+
+func buildConfigStore() cli.Command {
+	base := buildBasicCommand()
+	base.Subcommands = []cli.Command{
+		cli.Command{
+			Name:   "list",
+			Usage:  "lists the ConfigStore records of a stack or an account",
+			Action: runConfigStoreList,
+			Flags:  configStoreFlags(revealFlags()...),
+			Description: `Lists the ConfigStore records of a stack, or of the account with --account.
+Values are masked unless they are revealed with --reveal or --reveal-key.
+
+The account is the one of the --org option or the profile, or the account of the stack if there isn't one.
+
+Examples:
+$ cx configstore list -s mystack
+$ cx configstore list -s mystack --reveal-key REDIS_HOST
+$ cx configstore list --account --org acme
+`,
+		},
+		cli.Command{
+			Name:   "get",
+			Usage:  "prints the value of a ConfigStore record",
+			Action: runConfigStoreGet,
+			Flags:  configStoreFlags(),
+			Description: `Prints the value of a ConfigStore record, as it is, so it can be used in scripts.
+
+Examples:
+$ cx configstore get -s mystack REDIS_HOST
+$ cx configstore get --account --org acme shared.api.url
+`,
+		},
+		cli.Command{
+			Name:   "set",
+			Usage:  "creates or updates a ConfigStore record",
+			Action: runConfigStoreSet,
+			Flags: configStoreFlags(
+				cli.StringFlag{
+					Name:  "ttl",
+					Usage: "how long the record is kept for, like 30m or 24h. Use 0 for a record that doesn't expire",
+				},
+				cli.StringSliceFlag{
+					Name:  "metadata",
+					Usage: "metadata of the record as key=value. Repeatable",
+					Value: &cli.StringSlice{},
+				},
+			),
+			Description: `Creates a ConfigStore record, or updates it if it exists.
+A value of @path is replaced with the content of the file at path (use @@ for a literal @), and - reads the value from stdin.
+The TTL and metadata of an existing record are kept unless they are given.
+
+Examples:
+$ cx configstore set -s mystack REDIS_HOST redis.example.com
+$ cx configstore set -s mystack TLS_CERT @cert.pem --metadata owner=ops
+$ cx configstore set --account --org acme maintenance.banner "Back soon" --ttl 2h
+`,
+		},
+		cli.Command{
+			Name:   "delete",
+			Usage:  "deletes ConfigStore records",
+			Action: runConfigStoreDelete,
+			Flags: configStoreFlags(
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			),
+			Description: `Deletes one or more ConfigStore records.
+
+Examples:
+$ cx configstore delete -s mystack REDIS_HOST
+$ cx configstore delete --account --org acme maintenance.banner -y
+`,
+		},
+		cli.Command{
+			Name:   "import",
+			Usage:  "creates and updates ConfigStore records from a YAML file",
+			Action: runConfigStoreImport,
+			Flags: configStoreFlags(append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "file,f",
+					Usage: "YAML file to import. Use - to read it from stdin",
+				},
+				cli.BoolFlag{
+					Name:  "diff",
+					Usage: "only show the differences between the file and the ConfigStore, without applying them",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			}, revealFlags()...)...),
+			Description: `Creates and updates ConfigStore records from a YAML file in the format of the configstore-records.yml
+file of formation bundles:
+
+records:
+- key: REDIS_HOST
+  raw_value: redis.example.com
+  metadata:
+    owner: ops
+  ttl: 0
+  scope: stack      # or account. Records without a scope go to the namespace chosen with --account
+
+The differences are shown with their values masked before applying them. Use --diff to only show them.
+Records which are not in the file are kept.
+
+Examples:
+$ cx configstore import -s mystack -f configstore-records.yml --diff
+$ cx configstore import -s mystack -f configstore-records.yml -y
+$ cx configstore export -s staging-stack | cx configstore import -s mystack -f - -y
+`,
+		},
+		cli.Command{
+			Name:   "export",
+			Usage:  "writes ConfigStore records as a YAML file",
+			Action: runConfigStoreExport,
+			Flags: configStoreFlags(
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "file to write to, instead of stdout",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "export the records of both the stack and its account",
+				},
+			),
+			Description: `Writes ConfigStore records in the format of the configstore-records.yml file of formation bundles,
+ready to be used with 'configstore import'. Use --all to export the records of both the stack and its account,
+like formation bundles do.
+
+Examples:
+$ cx configstore export -s mystack > configstore-records.yml
+$ cx configstore export -s mystack --all -o configstore-records.yml
+`,
+		},
+	}
+
+	return base
+}
+
+func runConfigStoreList(c *cli.Context) {
+	namespace := mustConfigStoreNamespace(c)
+	reveal := newSecretReveal(c)
+
+	records, err := client.GetConfigStoreRecords(namespace.Namespace)
+	must(err)
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "KEY", "VALUE", "TTL", "METADATA")
+	for _, record := range records {
+		listRec(w, record.Key, configStoreValue(record, reveal), configStoreTtl(record.Ttl), configStoreMetadata(record.Metadata))
+	}
+}
+
+func runConfigStoreGet(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+	namespace := mustConfigStoreNamespace(c)
+
+	record, err := findConfigStoreRecord(namespace.Namespace, c.Args()[0])
+	must(err)
+	if record == nil {
+		printFatal("No ConfigStore record named %s in %s", c.Args()[0], namespace)
+	}
+	fmt.Println(record.RawValue)
+}
+
+// ConfigStore values are masked unless revealed, whatever their key, as they are often secrets
+func configStoreValue(record cloud66.ConfigStoreRecord, reveal secretReveal) string {
+	if reveal.reveals(record.Key) {
+		return record.RawValue
+	}
+	return maskSecret(record.RawValue)
+}
+
+func configStoreTtl(ttl int) string {
+	if ttl <= 0 {
+		return "-"
+	}
+	return prettyDuration{time.Duration(ttl) * time.Second}.String()
+}
+
+func configStoreMetadata(metadata map[string]string) string {
+	var result []string
+	for _, key := range sortedKeysOf(metadata) {
+		result = append(result, key+"="+metadata[key])
+	}
+	return strings.Join(result, ",")
+}
+
+func sortedKeysOf(values map[string]string) []string {
+	keys := make(map[string]bool)
+	for key := range values {
+		keys[key] = true
+	}
+	return sortedKeys(keys)
+}
+
+// returns the namespace of the account with --account, or of the stack
+func mustConfigStoreNamespace(c *cli.Context) configStoreNamespace {
+	if c.Bool("account") {
+		return mustAccountConfigStoreNamespace(c)
+	}
+	return mustStackConfigStoreNamespace(c)
+}
+
+func mustStackConfigStoreNamespace(c *cli.Context) configStoreNamespace {
+	stack := mustStack(c)
+	mustHaveConfigStore(*stack)
+	return configStoreNamespace{Scope: cloud66.BundledConfigStoreStackScope, Name: stack.Name, Namespace: stack.ConfigStoreNamespace}
+}
+
+// the account is the one of --org or the profile, or the account of the stack if there isn't one
+func mustAccountConfigStoreNamespace(c *cli.Context) configStoreNamespace {
+	account, err := org(c)
+	must(err)
+	if account == nil {
+		stack := mustStack(c)
+		account, err = client.AccountInfo(stack.AccountId, false)
+		must(err)
+	}
+	if account.ConfigStoreNamespace == "" {
+		printFatal("Account %s doesn't have a ConfigStore namespace", account.Name)
+	}
+	return configStoreNamespace{Scope: cloud66.BundledConfigStoreAccountScope, Name: account.Name, Namespace: account.ConfigStoreNamespace}
+}
+
+// fetches a record of the namespace. Returns nil if there isn't one with the key
+func findConfigStoreRecord(namespace string, key string) (*cloud66.ConfigStoreRecord, error) {
+	records, err := client.GetConfigStoreRecords(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range records {
+		if records[idx].Key == key {
+			return &records[idx], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/cloud66-oss/cloud66"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigStore import", func() {
+	records := []cloud66.ConfigStoreRecord{
+		{Key: "REDIS_HOST", RawValue: "redis.example.com"},
+		{Key: "API_URL", RawValue: "https://api.example.com", Metadata: map[string]string{"owner": "ops"}},
+	}
+
+	It("should skip records which are the same", func() {
+		Expect(diffConfigStoreRecord(records, cloud66.ConfigStoreRecord{Key: "REDIS_HOST", RawValue: "redis.example.com", Metadata: map[string]string{}})).To(BeNil())
+	})
+
+	It("should find new and changed records", func() {
+		change := diffConfigStoreRecord(records, cloud66.ConfigStoreRecord{Key: "API_URL", RawValue: "https://api.example.com"})
+		Expect(change).NotTo(BeNil())
+		Expect(change.Existing.Metadata).To(HaveKeyWithValue("owner", "ops"))
+
+		change = diffConfigStoreRecord(records, cloud66.ConfigStoreRecord{Key: "NEW", RawValue: "1"})
+		Expect(change).NotTo(BeNil())
+		Expect(change.Existing).To(BeNil())
+	})
+})
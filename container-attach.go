@@ -15,6 +15,7 @@ func runContainerAttach(c *cli.Context) {
 	}
 
 	fmt.Println("Attaching to container...")
+	flagGatewayKey = c.String("gateway-key")
 
 	stack := mustStack(c)
 	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
@@ -60,7 +61,7 @@ func runContainerAttach(c *cli.Context) {
 		userCommand = fmt.Sprintf("sudo docker attach --no-stdin=true --sig-proxy=false %s", container.Uid)
 	}
 
-	err = runServerCommand(*server, userCommand, false)
+	err = runServerCommand(*server, userCommand, false, nil)
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -90,7 +90,9 @@ $ cx containers stop -s mystack web
 			Name:   "attach",
 			Action: runContainerAttach,
 			Usage:  "Attach to a container on the given stack",
+			Flags:  []cli.Flag{gatewayKeyFlag()},
 			Description: `Attach to a container on the given stack by container Id.
+Servers behind a deploy gateway are reached through it (see --gateway-key).
 Examples:
 $ cx containers attach -s mystack 2844142c
 `,
@@ -45,6 +45,9 @@ func runSlavePromote(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endSlavePromote(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -60,5 +63,5 @@ func startSlavePromote(stackUid string, serverUid string, dbType *string) (*int,
 }
 
 func endSlavePromote(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// dbConsole is a database type cx db console can connect to. Its credentials are read from the
+// <EnvPrefix>_* environment variables of the stack
+type dbConsole struct {
+	EnvPrefix string
+	Scheme    string
+	// local clients to launch, in order of preference
+	Clients []string
+}
+
+var dbConsoles = map[string]dbConsole{
+	"postgresql": {EnvPrefix: "POSTGRESQL", Scheme: "postgresql", Clients: []string{"psql"}},
+	"mysql":      {EnvPrefix: "MYSQL", Scheme: "mysql", Clients: []string{"mysql"}},
+	"redis":      {EnvPrefix: "REDIS", Scheme: "redis", Clients: []string{"redis-cli"}},
+	"mongodb":    {EnvPrefix: "MONGODB", Scheme: "mongodb", Clients: []string{"mongosh", "mongo"}},
+}
+
+// dbCredentials are the connection details of a stack database
+type dbCredentials struct {
+	Address  string
+	Username string
+	Password string
+	Database string
+}
+
+func dbConsoleTypes() []string {
+	var names []string
+	for name := range dbConsoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func runDatabaseConsole(c *cli.Context) {
+	if runtime.GOOS == "windows" {
+		printFatal("Not supported on Windows")
+	}
+	flagGatewayKey = c.String("gateway-key")
+
+	stack := mustStack(c)
+	servers, err := client.Servers(stack.Uid)
+	must(err)
+
+	dbType := strings.ToLower(c.String("type"))
+	if dbType == "" {
+		dbType, err = detectDatabaseType(servers)
+		must(err)
+	}
+	console, ok := dbConsoles[dbType]
+	if !ok {
+		printFatal("Unknown database type %s. Use one of %s", dbType, strings.Join(dbConsoleTypes(), ", "))
+	}
+	preset := tunnelPresets[dbType]
+
+	serverName := c.String("server")
+	if serverName == "" {
+		serverName = preset.Role
+	}
+	server, err := findServer(servers, serverName)
+	must(err)
+	if server == nil {
+		printFatal("No %s server found in %s", dbType, stack.Name)
+	}
+
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+	values := make(map[string]string)
+	for _, envVar := range envVars {
+		values[envVar.Key] = envVarValue(envVar)
+	}
+	credentials := readDbCredentials(console, values)
+
+	var clientPath string
+	if !c.Bool("print-url") {
+		if clientPath = findDbClient(console); clientPath == "" {
+			printFatal("%s is not installed. Install it, or use --print-url to connect with another client", strings.Join(console.Clients, " or "))
+		}
+	}
+
+	localPort, err := freeLocalPort()
+	must(err)
+	forward := tunnelForward{Local: localPort, RemoteHost: credentials.Address, RemotePort: preset.Port}
+	tunnel, err := openServerTunnel(*server, []tunnelForward{forward}, os.Stderr)
+	if err != nil {
+		printFatal("Unable to open the tunnel: %s", err.Error())
+	}
+
+	if c.Bool("print-url") {
+		fmt.Println(dbConsoleURL(console, credentials, localPort))
+		fmt.Fprintln(os.Stderr, "The tunnel stays open until you press Ctrl-C")
+		tunnel.Wait()
+		return
+	}
+
+	args, env := dbClientCommand(clientPath, console, credentials, localPort)
+	code := execWithEnv(args, env)
+	tunnel.Close()
+	closeSessionGateways()
+	os.Exit(code)
+}
+
+// returns the only database type with a server in the stack
+func detectDatabaseType(servers []cloud66.Server) (string, error) {
+	var found []string
+	for _, dbType := range dbConsoleTypes() {
+		role := tunnelPresets[dbType].Role
+		for _, server := range servers {
+			if stringsIndex(server.Roles, role) != -1 {
+				found = append(found, dbType)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("No database servers found. Use --type to specify one of %s", strings.Join(dbConsoleTypes(), ", "))
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("More than one database type found (%s). Use --type to pick one", strings.Join(found, ", "))
+}
+
+// reads the credentials from the <PREFIX>_ADDRESS, _USERNAME, _PASSWORD and _DATABASE environment variables,
+// filling the missing ones from <PREFIX>_URL
+func readDbCredentials(console dbConsole, values map[string]string) dbCredentials {
+	credentials := dbCredentials{
+		Address:  values[console.EnvPrefix+"_ADDRESS"],
+		Username: values[console.EnvPrefix+"_USERNAME"],
+		Password: values[console.EnvPrefix+"_PASSWORD"],
+		Database: values[console.EnvPrefix+"_DATABASE"],
+	}
+	if parsed, err := url.Parse(values[console.EnvPrefix+"_URL"]); err == nil {
+		if credentials.Address == "" {
+			credentials.Address = parsed.Hostname()
+		}
+		if parsed.User != nil {
+			if credentials.Username == "" {
+				credentials.Username = parsed.User.Username()
+			}
+			if password, ok := parsed.User.Password(); ok && credentials.Password == "" {
+				credentials.Password = password
+			}
+		}
+		if credentials.Database == "" {
+			credentials.Database = strings.TrimPrefix(parsed.Path, "/")
+		}
+	}
+	return credentials
+}
+
+// the connection string to the local end of the tunnel
+func dbConsoleURL(console dbConsole, credentials dbCredentials, localPort int) string {
+	result := url.URL{
+		Scheme: console.Scheme,
+		Host:   net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)),
+	}
+	if credentials.Password != "" {
+		result.User = url.UserPassword(credentials.Username, credentials.Password)
+	} else if credentials.Username != "" {
+		result.User = url.User(credentials.Username)
+	}
+	if credentials.Database != "" {
+		result.Path = "/" + credentials.Database
+	}
+	return result.String()
+}
+
+func findDbClient(console dbConsole) string {
+	for _, name := range console.Clients {
+		if clientPath, err := exec.LookPath(name); err == nil {
+			return clientPath
+		}
+	}
+	return ""
+}
+
+// the command line and environment variables of the client. Passwords are given in the environment
+// where the client supports it, so they don't show up in the process list
+func dbClientCommand(clientPath string, console dbConsole, credentials dbCredentials, localPort int) ([]string, map[string]string) {
+	port := strconv.Itoa(localPort)
+	env := make(map[string]string)
+	args := []string{clientPath}
+	switch console.Scheme {
+	case "postgresql":
+		args = append(args, "-h", "127.0.0.1", "-p", port)
+		if credentials.Username != "" {
+			args = append(args, "-U", credentials.Username)
+		}
+		if credentials.Database != "" {
+			args = append(args, credentials.Database)
+		}
+		if credentials.Password != "" {
+			env["PGPASSWORD"] = credentials.Password
+		}
+	case "mysql":
+		args = append(args, "-h", "127.0.0.1", "-P", port)
+		if credentials.Username != "" {
+			args = append(args, "-u", credentials.Username)
+		}
+		if credentials.Database != "" {
+			args = append(args, credentials.Database)
+		}
+		if credentials.Password != "" {
+			env["MYSQL_PWD"] = credentials.Password
+		}
+	case "redis":
+		args = append(args, "-h", "127.0.0.1", "-p", port)
+		if credentials.Password != "" {
+			env["REDISCLI_AUTH"] = credentials.Password
+		}
+	default:
+		args = append(args, dbConsoleURL(console, credentials, localPort))
+	}
+	return args, env
+}
+
+// returns a local port nothing is listening on
+func freeLocalPort() (int, error) {
+	listener, err := listenLocal(0)
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
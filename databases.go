@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/cloud66/cli"
 )
 
@@ -56,6 +58,44 @@ This can happen depending on many factors (such as DB size, frequency of change,
 Examples:
 $ cx databases resync-slave -s 'my stack name' postgresql_slave_name
 $ cx databases resync-slave -s 'my stack name' --db-type=postgresql pg_slave1
+`,
+		},
+		cli.Command{
+			Name:   "console",
+			Action: runDatabaseConsole,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "type of the database: " + strings.Join(dbConsoleTypes(), ", "),
+				},
+				cli.StringFlag{
+					Name:  "server",
+					Usage: "database server to connect to. Defaults to the first server with the role of the database",
+				},
+				cli.BoolFlag{
+					Name:  "print-url",
+					Usage: "print a connection string and keep the tunnel open instead of launching a client",
+				},
+				gatewayKeyFlag(),
+			},
+			Usage: "opens a console to a database of the stack",
+			Description: `Opens a console to a database of the stack with its local client.
+
+This finds the database server by its role, opens a tunnel to it on a free local port, reads the credentials
+from the environment variables of the stack (like POSTGRESQL_USERNAME and POSTGRESQL_PASSWORD) and launches
+psql, mysql, redis-cli or mongosh with them. The tunnel is closed when the client exits.
+
+If the stack only has one type of database, --type can be left out.
+
+Use --print-url to print a connection string for GUI tools instead. The tunnel stays open until you press Ctrl-C.
+
+This command is only supported on Linux and OS X.
+
+Examples:
+$ cx db console -s mystack
+$ cx db console -s mystack --type mysql
+$ cx db console -s mystack --type postgresql --server pg_slave1
+$ cx db console -s mystack --type postgresql --print-url
 `,
 		},
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envVarPair is a single KEY=value entry of a dotenv file
+type envVarPair struct {
+	Key   string
+	Value string
+}
+
+var envVarKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// parses a dotenv file. Blank lines and comments are ignored, an optional "export " prefix is allowed
+// and values can be unquoted, 'single quoted' (taken literally) or "double quoted" (with \n, \t, \" and \\ escapes).
+// The entries are returned in the order they appear, with later duplicates replacing earlier ones
+func parseDotEnv(r io.Reader) ([]envVarPair, error) {
+	var result []envVarPair
+	index := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		key := strings.TrimSpace(line[:idx])
+		if !envVarKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNo, key)
+		}
+		value, err := parseDotEnvValue(strings.TrimSpace(line[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+
+		if existing, ok := index[key]; ok {
+			result[existing].Value = value
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, envVarPair{Key: key, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func parseDotEnvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var value strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch raw[i] {
+			case '"':
+				return value.String(), nil
+			case '\\':
+				if i+1 == len(raw) {
+					return "", fmt.Errorf("unterminated double quote")
+				}
+				i++
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				case 'r':
+					value.WriteByte('\r')
+				default:
+					value.WriteByte(raw[i])
+				}
+			default:
+				value.WriteByte(raw[i])
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+	}
+
+	// unquoted values end at an inline comment
+	if idx := strings.Index(raw, " #"); idx != -1 {
+		raw = raw[:idx]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// formats a pair as a dotenv line, quoting the value when needed
+func formatDotEnv(pair envVarPair) string {
+	value := pair.Value
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"'#\\$`") {
+		replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+		value = `"` + replacer.Replace(value) + `"`
+	}
+	return pair.Key + "=" + value
+}
+
+// resolves values given as @file to the content of the file, and - to the content of stdin.
+// Use @@ to start a value with a literal @. Only for values given on the command line, never for values read from files
+func resolveEnvVarValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "@@"):
+		return value[1:], nil
+	case strings.HasPrefix(value, "@") && len(value) > 1:
+		return readValueFile(value[1:])
+	case value == "-":
+		return readValueFile("-")
+	}
+	return value, nil
+}
+
+// reads a value from the file at path, or from stdin when path is -, without its trailing newline
+func readValueFile(path string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(expandPath(path))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cloud66-oss/cloud66"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dotenv files", func() {
+	It("should parse quoted, unquoted and exported values", func() {
+		pairs, err := parseDotEnv(strings.NewReader(`
+# a comment
+export PLAIN=value # inline comment
+SINGLE='literal \n $HOME'
+DOUBLE="line\nbreak \"quoted\""
+EMPTY=
+PLAIN=override
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pairs).To(Equal([]envVarPair{
+			{Key: "PLAIN", Value: "override"},
+			{Key: "SINGLE", Value: `literal \n $HOME`},
+			{Key: "DOUBLE", Value: "line\nbreak \"quoted\""},
+			{Key: "EMPTY", Value: ""},
+		}))
+	})
+
+	It("should reject invalid lines", func() {
+		_, err := parseDotEnv(strings.NewReader("NOT A PAIR"))
+		Expect(err).To(HaveOccurred())
+		_, err = parseDotEnv(strings.NewReader(`KEY="unterminated`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should format values so they parse back", func() {
+		pair := envVarPair{Key: "KEY", Value: "multi\nline with \"quotes\" and #hash"}
+		pairs, err := parseDotEnv(strings.NewReader(formatDotEnv(pair)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pairs).To(Equal([]envVarPair{pair}))
+		Expect(formatDotEnv(envVarPair{Key: "KEY", Value: "simple"})).To(Equal("KEY=simple"))
+	})
+
+	It("should diff a file against the stack variables", func() {
+		envVars := []cloud66.StackEnvVar{
+			{Key: "SAME", Value: "1"},
+			{Key: "CHANGED", Value: "old"},
+			{Key: "GONE", Value: "x"},
+			{Key: "STACK_PATH", Value: "/var", Readonly: true},
+		}
+		pairs := []envVarPair{{"SAME", "1"}, {"CHANGED", "new"}, {"NEW", "n"}, {"STACK_PATH", "/tmp"}}
+
+		changes, readonly := diffEnvVars(envVars, pairs)
+		Expect(readonly).To(Equal([]string{"STACK_PATH"}))
+		Expect(changes).To(Equal([]envVarChange{
+			{Key: "CHANGED", Kind: envVarChanged, OldValue: "old", NewValue: "new"},
+			{Key: "NEW", Kind: envVarAdded, NewValue: "n"},
+			{Key: "GONE", Kind: envVarRemoved, OldValue: "x"},
+		}))
+		Expect(envVarsToSet(changes)).To(Equal([]envVarPair{{"CHANGED", "new"}, {"NEW", "n"}}))
+	})
+
+	It("should read values from files without their trailing newline", func() {
+		file, err := ioutil.TempFile("", "cx-value")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Remove(file.Name())
+		_, err = file.WriteString("-----BEGIN KEY-----\nabc\n")
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+
+		value, err := readValueFile(file.Name())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("-----BEGIN KEY-----\nabc"))
+		_, err = readValueFile(file.Name() + ".missing")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should mask the changed values unless they are revealed", func() {
+		changes := []envVarChange{
+			{Key: "API_TOKEN", Kind: envVarChanged, OldValue: "old-token", NewValue: "new-token"},
+			{Key: "RAILS_ENV", Kind: envVarAdded, NewValue: "production"},
+		}
+
+		var masked bytes.Buffer
+		printEnvVarChanges(&masked, changes, secretReveal{})
+		Expect(masked.String()).NotTo(ContainSubstring("token"))
+		Expect(masked.String()).To(ContainSubstring("+ RAILS_ENV=production"))
+
+		var revealed bytes.Buffer
+		printEnvVarChanges(&revealed, changes, secretReveal{keys: []string{"API_TOKEN"}})
+		Expect(revealed.String()).To(ContainSubstring("~ API_TOKEN=new-token (was old-token)"))
+	})
+})
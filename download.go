@@ -18,6 +18,7 @@ var cmdDownload = &Command{
 			Name:  "server",
 			Usage: "name of the server to download from",
 		},
+		gatewayKeyFlag(),
 	},
 	Build:      buildBasicCommand,
 	NeedsStack: true,
@@ -37,9 +38,12 @@ You can use either the server name (ie lion) or the server IP (ie. 123.123.123.1
 with thie command.
 
 If a role is specified the command will connect to the first server with that role.
+Files are downloaded through the deploy gateway of servers behind one (see --gateway-key).
 
 Names are case insensitive and will work with the starting characters as well.
 
+To copy only what changed, with a progress bar, or with many servers at once, use cx sync.
+
 This command is only supported on Linux and OS X.
 
 Examples:
@@ -54,6 +58,7 @@ func runDownload(c *cli.Context) {
 		printFatal("Not supported on Windows")
 		os.Exit(2)
 	}
+	flagGatewayKey = c.String("gateway-key")
 
 	stack := mustStack(c)
 
@@ -110,7 +115,7 @@ func sshToServerToDownload(server cloud66.Server, filePath string, targetDirecto
 		targetDir = targetDirectory[0]
 	}
 
-	sshFile, err := prepareLocalSshKey(server)
+	sshKey, err := prepareLocalSshKey(server)
 	must(err)
 
 	// open the firewall
@@ -124,16 +129,10 @@ func sshToServerToDownload(server cloud66.Server, filePath string, targetDirecto
 
 	fmt.Printf("Connecting to %s (%s)...\n", server.Name, server.Address)
 
-	return startProgram("scp", []string{
-		"-i", sshFile,
-		"-r",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "CheckHostIP=no",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "LogLevel=QUIET",
-		"-o", "IdentitiesOnly=yes",
-		"-P", "22",
-		server.UserName + "@" + server.Address + ":" + filePath,
-		targetDir,
-	})
+	conn, err := dialServer(server, sshKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Download(filePath, targetDir)
 }
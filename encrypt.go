@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// files encrypted by cx start with this, followed by base64(salt + nonce + sealed data)
+const encryptedPrefix = "cx-encrypted:v1:"
+
+const (
+	encryptionSaltSize   = 16
+	encryptionIterations = 200000
+)
+
+// encrypts data with AES-256-GCM, using a key derived from the passphrase with PBKDF2-HMAC-SHA256
+func encryptWithPassphrase(data []byte, passphrase string) (string, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, nonce, data, nil)
+	payload := append(append(salt, nonce...), sealed...)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(payload) + "\n", nil
+}
+
+func decryptWithPassphrase(text string, passphrase string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, encryptedPrefix) {
+		return nil, errors.New("not a file encrypted by cx")
+	}
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < encryptionSaltSize {
+		return nil, errors.New("the encrypted data is too short")
+	}
+
+	gcm, err := passphraseCipher(passphrase, payload[:encryptionSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[encryptionSaltSize:]
+	if len(payload) < gcm.NonceSize() {
+		return nil, errors.New("the encrypted data is too short")
+	}
+
+	data, err := gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt. Is the passphrase correct?")
+	}
+	return data, nil
+}
+
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, encryptionIterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Passphrase encryption", func() {
+	It("should decrypt data encrypted by earlier versions", func() {
+		data, err := decryptWithPassphrase("cx-encrypted:v1:Vv5Eei5xcZH89FkRIlxBu9ZnzVSMvj/uUK3gxmCBqleFcxhRr5EkGP6hWTsAr8hlbgABTHk=", "correct horse")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("SECRET=1\n"))
+	})
+
+	It("should decrypt what it encrypts with the same passphrase only", func() {
+		encrypted, err := encryptWithPassphrase([]byte("SECRET=1\n"), "correct horse")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(HavePrefix(encryptedPrefix))
+
+		data, err := decryptWithPassphrase(encrypted, "correct horse")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("SECRET=1\n"))
+
+		_, err = decryptWithPassphrase(encrypted, "wrong")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// hostRewrite replaces a host, or a host:port, in environment variable values
+type hostRewrite struct {
+	From string
+	To   string
+}
+
+// tunnelPort forwards a local port to a remote one
+type tunnelPort struct {
+	Remote int
+	Local  int
+}
+
+func runEnvVarsExec(c *cli.Context) {
+	flagGatewayKey = c.String("gateway-key")
+	args := c.Args()
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		printFatal("No command given. Use cx env-vars exec -s mystack -- <command>")
+	}
+
+	only := splitCommaValues(c.StringSlice("only"))
+	except := splitCommaValues(c.StringSlice("except"))
+	for _, pattern := range append(only, except...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			printFatal("Invalid key pattern %q", pattern)
+		}
+	}
+
+	var rewrites []hostRewrite
+	for _, value := range c.StringSlice("rewrite-host") {
+		rewrite, err := parseHostRewrite(value)
+		if err != nil {
+			printFatal("%s", err.Error())
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+
+	var ports []tunnelPort
+	for _, value := range splitCommaValues(c.StringSlice("tunnel-port")) {
+		port, err := parseTunnelPort(value)
+		if err != nil {
+			printFatal("%s", err.Error())
+		}
+		ports = append(ports, port)
+	}
+	serverName := c.String("tunnel")
+	if serverName == "" && len(ports) > 0 {
+		printFatal("Use --tunnel to specify the server to tunnel to")
+	}
+	if serverName != "" && len(ports) == 0 {
+		printFatal("No ports to tunnel. Use --tunnel-port")
+	}
+	if serverName != "" && runtime.GOOS == "windows" {
+		printFatal("Tunnels are not supported on Windows")
+	}
+
+	stack := mustStack(c)
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+
+	values := make(map[string]string)
+	for _, envVar := range envVars {
+		if envVar.Key == "" || (len(only) > 0 && !matchesAnyGlob(only, envVar.Key)) || matchesAnyGlob(except, envVar.Key) {
+			continue
+		}
+		values[envVar.Key] = envVarValue(envVar)
+	}
+
+	var tunnel *serverTunnel
+	if serverName != "" {
+		servers, err := client.Servers(stack.Uid)
+		must(err)
+		server, err := findServer(servers, serverName)
+		must(err)
+		if server == nil {
+			printFatal("Server '%s' not found", serverName)
+		}
+
+		tunnel, err = startExecTunnel(*server, ports)
+		if err != nil {
+			printFatal("Unable to open the tunnel: %s", err.Error())
+		}
+
+		rewrites = append(rewrites, tunnelHostRewrites(*server, ports)...)
+		rewriteTunnelPorts(values, ports)
+	}
+
+	for key, value := range values {
+		for _, rewrite := range rewrites {
+			value = replaceHost(value, rewrite.From, rewrite.To)
+		}
+		values[key] = value
+	}
+
+	code := execWithEnv(args, values)
+	tunnel.Close()
+	closeSessionGateways()
+	os.Exit(code)
+}
+
+// runs the command with the values added to the current environment and returns its exit code.
+// Ctrl-C is left for the command to handle
+func execWithEnv(args []string, values map[string]string) int {
+	env := os.Environ()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+values[key])
+	}
+
+	if debugMode {
+		fmt.Printf("Running %s with %s\n", strings.Join(args, " "), strings.Join(keys, ", "))
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	atomic.StoreInt32(&interruptsHandledByCommand, 1)
+	defer atomic.StoreInt32(&interruptsHandledByCommand, 0)
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		printError("%s", err.Error())
+		return 127
+	}
+	return 0
+}
+
+func parseHostRewrite(value string) (hostRewrite, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return hostRewrite{}, fmt.Errorf("invalid --rewrite-host %q. Use from=to, like 10.0.0.5:5432=127.0.0.1:15432", value)
+	}
+	return hostRewrite{From: strings.TrimSpace(parts[0]), To: strings.TrimSpace(parts[1])}, nil
+}
+
+func parseTunnelPort(value string) (tunnelPort, error) {
+	parts := strings.SplitN(value, ":", 2)
+	remote, err := strconv.Atoi(parts[0])
+	if err != nil || remote <= 0 || remote > 65535 {
+		return tunnelPort{}, fmt.Errorf("invalid --tunnel-port %q. Use remote or remote:local, like 5432:15432", value)
+	}
+	port := tunnelPort{Remote: remote, Local: remote + 1}
+	if len(parts) == 2 {
+		if port.Local, err = strconv.Atoi(parts[1]); err != nil || port.Local <= 0 || port.Local > 65535 {
+			return tunnelPort{}, fmt.Errorf("invalid --tunnel-port %q. Use remote or remote:local, like 5432:15432", value)
+		}
+	}
+	return port, nil
+}
+
+func isHostChar(b byte) bool {
+	return b == '.' || b == '-' || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// replaces whole occurrences of a host (or host:port) in a value, so 10.0.0.5 doesn't match 10.0.0.50
+func replaceHost(value string, from string, to string) string {
+	var result strings.Builder
+	for {
+		idx := strings.Index(value, from)
+		if idx == -1 {
+			result.WriteString(value)
+			return result.String()
+		}
+		end := idx + len(from)
+		if (idx > 0 && isHostChar(value[idx-1])) || (end < len(value) && isHostChar(value[end])) {
+			result.WriteString(value[:idx+1])
+			value = value[idx+1:]
+			continue
+		}
+		result.WriteString(value[:idx])
+		result.WriteString(to)
+		value = value[end:]
+	}
+}
+
+// points the addresses of the server at the local end of the tunnel. Rewrites with ports come first
+// so host:port is changed to the local port before the host is changed on its own
+func tunnelHostRewrites(server cloud66.Server, ports []tunnelPort) []hostRewrite {
+	var hosts []string
+	for _, host := range []string{server.Address, server.DnsRecord, server.ExtIpV4} {
+		if host != "" && stringsIndex(hosts, host) == -1 {
+			hosts = append(hosts, host)
+		}
+	}
+
+	var result []hostRewrite
+	for _, host := range hosts {
+		for _, port := range ports {
+			result = append(result, hostRewrite{From: fmt.Sprintf("%s:%d", host, port.Remote), To: fmt.Sprintf("127.0.0.1:%d", port.Local)})
+		}
+	}
+	for _, host := range hosts {
+		result = append(result, hostRewrite{From: host, To: "127.0.0.1"})
+	}
+	return result
+}
+
+// changes variables like POSTGRESQL_PORT holding a tunnelled port to the local port
+func rewriteTunnelPorts(values map[string]string, ports []tunnelPort) {
+	for key, value := range values {
+		if !strings.HasSuffix(strings.ToUpper(key), "_PORT") {
+			continue
+		}
+		for _, port := range ports {
+			if value == strconv.Itoa(port.Remote) {
+				values[key] = strconv.Itoa(port.Local)
+			}
+		}
+	}
+}
+
+// opens a tunnel to the server in the background, for the command to reach the ports
+func startExecTunnel(server cloud66.Server, ports []tunnelPort) (*serverTunnel, error) {
+	var forwards []tunnelForward
+	for _, port := range ports {
+		forwards = append(forwards, tunnelForward{Local: port.Local, RemotePort: port.Remote})
+	}
+	return openServerTunnel(server, forwards, os.Stderr)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/cloud66-oss/cloud66"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment variable host rewrites", func() {
+	It("should only replace whole hosts", func() {
+		Expect(replaceHost("postgres://app@10.0.0.5:5432/app", "10.0.0.5", "db")).To(Equal("postgres://app@db:5432/app"))
+		Expect(replaceHost("10.0.0.50,10.0.0.5", "10.0.0.5", "db")).To(Equal("10.0.0.50,db"))
+	})
+
+	It("should point tunnelled ports at localhost", func() {
+		server := cloud66.Server{Address: "52.1.2.3", DnsRecord: "db.example.com"}
+		ports := []tunnelPort{{Remote: 5432, Local: 15432}}
+		value := "postgres://app@db.example.com:5432/app"
+		for _, rewrite := range tunnelHostRewrites(server, ports) {
+			value = replaceHost(value, rewrite.From, rewrite.To)
+		}
+		Expect(value).To(Equal("postgres://app@127.0.0.1:15432/app"))
+
+		values := map[string]string{"POSTGRESQL_PORT": "5432", "WEB_PORTS": "5432"}
+		rewriteTunnelPorts(values, ports)
+		Expect(values).To(Equal(map[string]string{"POSTGRESQL_PORT": "15432", "WEB_PORTS": "5432"}))
+	})
+})
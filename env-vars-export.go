@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/cloud66/cli"
+)
+
+func runEnvVarsExport(c *cli.Context) {
+	stack := mustStack(c)
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+
+	var w io.Writer = os.Stdout
+	if output := c.String("output"); output != "" {
+		file, err := os.OpenFile(expandPath(output), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		must(err)
+		defer file.Close()
+		w = file
+	}
+
+	sort.Sort(envVarsByName(envVars))
+	fmt.Fprintf(w, "# environment variables of %s (%s)\n", stack.Name, stack.Environment)
+	for _, envVar := range envVars {
+		if envVar.Key == "" || (envVar.Readonly && !c.Bool("include-readonly")) {
+			continue
+		}
+		fmt.Fprintln(w, formatDotEnv(envVarPair{Key: envVar.Key, Value: envVarValue(envVar)}))
+	}
+}
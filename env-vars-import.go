@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+const (
+	envVarAdded   = "added"
+	envVarChanged = "changed"
+	envVarRemoved = "removed"
+)
+
+// envVarChange is the difference of a single environment variable between a dotenv file and a stack
+type envVarChange struct {
+	Key      string
+	Kind     string
+	OldValue string
+	NewValue string
+}
+
+func runEnvVarsImport(c *cli.Context) {
+	filename := c.String("file")
+	if filename == "" {
+		printFatal("No file provided. Please use --file to specify a dotenv file, or - for stdin")
+	}
+
+	flagApplyStrategy := mustApplyStrategy(c)
+
+	// values are imported as they are: @file and - are only resolved for values given on the command line,
+	// so a dotenv file can't read other local files
+	pairs, err := readDotEnvFile(filename)
+	must(err)
+
+	stack := mustStack(c)
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+
+	changes, readonly := diffEnvVars(envVars, pairs)
+	for _, key := range readonly {
+		printWarning("Skipping %s as it is readonly", key)
+	}
+
+	printEnvVarChanges(os.Stdout, changes, newSecretReveal(c))
+	toSet := envVarsToSet(changes)
+	if c.Bool("diff") {
+		return
+	}
+	if len(toSet) == 0 {
+		fmt.Println("No changes to apply")
+		return
+	}
+
+	if !c.Bool("y") {
+		if filename == "-" {
+			printFatal("Use -y to apply changes read from stdin")
+		}
+		mustConfirm(fmt.Sprintf("Apply %d change(s) to %s %s? [yes/N]", len(toSet), stack.Name, flagApplyStrategy), "yes")
+	}
+
+	existing := make(map[string]bool)
+	for _, envVar := range envVars {
+		existing[envVar.Key] = true
+	}
+
+	var updates []envVarUpdate
+	for _, pair := range toSet {
+		updates = append(updates, envVarUpdate{Key: pair.Key, Value: pair.Value, Existing: existing[pair.Key]})
+	}
+	applyEnvVars(c, *stack, updates, flagApplyStrategy, "env-vars import")
+}
+
+// envVarUpdate sets or removes a single environment variable
+type envVarUpdate struct {
+	Key      string
+	Value    string
+	Existing bool
+	Unset    bool
+}
+
+func (u envVarUpdate) start(stackUid string, applyStrategy string) (*int, error) {
+	if u.Unset {
+		return startEnvVarUnset(stackUid, u.Key, applyStrategy)
+	}
+	return startEnvVarSet(stackUid, u.Key, u.Value, u.Existing, applyStrategy)
+}
+
+// changes the given environment variables as one batch. Changes applied immediately are deployments,
+// so they are checked against the deployment lock, policies and pre-deploy hooks first
+func applyEnvVars(c *cli.Context, stack cloud66.Stack, updates []envVarUpdate, applyStrategy string, command string) {
+	if applyStrategy != "immediately" {
+		changeEnvVars(stack, updates, applyStrategy, nil)
+		return
+	}
+
+	payload := newHookPayload(hookPreDeploy, command, stack)
+	mustNotBeLocked(c, stack)
+	mustPassPolicy(c, stack, policyAction{Command: command})
+	mustFireHooks(payload)
+	changeEnvVars(stack, updates, applyStrategy, &payload)
+}
+
+// changes the given environment variables: all but the last one are staged for the next deployment, and the last
+// one is changed with the apply strategy, which applies all of them at once. When payload is given, the post-deploy
+// hooks are fired with the outcome
+func changeEnvVars(stack cloud66.Stack, updates []envVarUpdate, applyStrategy string, payload *hookPayload) {
+	deploying := applyStrategy == "immediately"
+
+	var staged []string
+	fail := func(err error) {
+		if payload != nil {
+			fireHooks(payload.finished(false, err.Error()))
+		}
+		if len(staged) > 0 {
+			printWarning("These variables were already staged and will be applied during the next deployment: %s", strings.Join(staged, ", "))
+		}
+		printFatal("%s", err.Error())
+	}
+
+	for idx, update := range updates {
+		strategy := "deployment"
+		if idx == len(updates)-1 {
+			strategy = applyStrategy
+			if deploying {
+				fmt.Println("Please wait while your changes are applied immediately...")
+			}
+		}
+
+		asyncId, err := update.start(stack.Uid, strategy)
+		if err != nil {
+			fail(fmt.Errorf("%s: %s", update.Key, err))
+		}
+
+		if idx < len(updates)-1 {
+			// staging a variable doesn't touch the servers so it's quick
+			genericRes, err := waitForStackAsyncAction(*asyncId, stack.Uid, 2*time.Second, 5*time.Minute, false)
+			if err != nil {
+				fail(fmt.Errorf("%s: %s", update.Key, err))
+			}
+			if !genericRes.Status {
+				fail(fmt.Errorf("%s: %s", update.Key, genericRes.Message))
+			}
+			staged = append(staged, update.Key)
+			fmt.Printf("%s staged\n", update.Key)
+			continue
+		}
+
+		// the post-deploy hooks need the outcome of the change
+		genericRes, err := endEnvVarSet(*asyncId, stack.Uid, payload != nil && postDeployHooksActive())
+		if err == errAsyncActionNotWaited {
+			return
+		}
+		if err != nil {
+			fail(err)
+		}
+		if payload != nil {
+			fireHooks(payload.finished(genericRes.Status, genericRes.Message))
+		}
+		if !deploying {
+			fmt.Println("Your changes will be applied during your next deployment!")
+		}
+		printGenericResponse(*genericRes)
+	}
+}
+
+func readDotEnvFile(filename string) ([]envVarPair, error) {
+	if filename == "-" {
+		return parseDotEnv(os.Stdin)
+	}
+
+	file, err := os.Open(expandPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseDotEnv(file)
+}
+
+// compares the stack environment variables with the pairs. Readonly variables can't be changed so
+// they are returned separately and removed variables only include the ones which could be changed
+func diffEnvVars(envVars []cloud66.StackEnvVar, pairs []envVarPair) ([]envVarChange, []string) {
+	current := make(map[string]cloud66.StackEnvVar)
+	for _, envVar := range envVars {
+		current[envVar.Key] = envVar
+	}
+
+	var changes []envVarChange
+	var readonly []string
+	wanted := make(map[string]bool)
+	for _, pair := range pairs {
+		wanted[pair.Key] = true
+
+		envVar, ok := current[pair.Key]
+		switch {
+		case !ok:
+			changes = append(changes, envVarChange{Key: pair.Key, Kind: envVarAdded, NewValue: pair.Value})
+		case envVar.Readonly:
+			readonly = append(readonly, pair.Key)
+		case envVarValue(envVar) != pair.Value:
+			changes = append(changes, envVarChange{Key: pair.Key, Kind: envVarChanged, OldValue: envVarValue(envVar), NewValue: pair.Value})
+		}
+	}
+
+	var removed []envVarChange
+	for _, envVar := range envVars {
+		if !wanted[envVar.Key] && !envVar.Readonly && envVar.Key != "" {
+			removed = append(removed, envVarChange{Key: envVar.Key, Kind: envVarRemoved, OldValue: envVarValue(envVar)})
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Key < removed[j].Key })
+
+	return append(changes, removed...), readonly
+}
+
+// returns the added and changed variables
+func envVarsToSet(changes []envVarChange) []envVarPair {
+	var result []envVarPair
+	for _, change := range changes {
+		if change.Kind != envVarRemoved {
+			result = append(result, envVarPair{Key: change.Key, Value: change.NewValue})
+		}
+	}
+	return result
+}
+
+func printEnvVarChanges(w io.Writer, changes []envVarChange, reveal secretReveal) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+
+	for _, change := range changes {
+		switch change.Kind {
+		case envVarAdded:
+			fmt.Fprintf(w, "+ %s=%v\n", change.Key, reveal.value(change.Key, change.NewValue))
+		case envVarChanged:
+			fmt.Fprintf(w, "~ %s=%v (was %v)\n", change.Key, reveal.value(change.Key, change.NewValue), reveal.value(change.Key, change.OldValue))
+		case envVarRemoved:
+			fmt.Fprintf(w, "- %s (not in the file, kept on the stack. Use 'env-vars unset' to remove it)\n", change.Key)
+		}
+	}
+}
+
+func envVarValue(envVar cloud66.StackEnvVar) string {
+	if envVar.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(envVar.Value)
+}
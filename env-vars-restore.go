@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// timestamp layouts accepted by env-vars restore --to, in local time unless a zone is given
+var envVarRestoreLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func runEnvVarsRestore(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+	key := c.Args()[0]
+
+	to := c.String("to")
+	if to == "" {
+		printFatal("Please use --to to specify the history index or timestamp to restore")
+	}
+
+	flagApplyStrategy := mustApplyStrategy(c)
+	stack := mustStack(c)
+
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+
+	var envVar *cloud66.StackEnvVar
+	for idx := range envVars {
+		if envVars[idx].Key == key {
+			envVar = &envVars[idx]
+		}
+	}
+	if envVar == nil {
+		printFatal("Environment variable %s not found", key)
+	}
+	if envVar.Readonly {
+		printFatal("%s is readonly and cannot be restored", key)
+	}
+
+	value, err := historicalEnvVarValue(*envVar, to)
+	must(err)
+
+	current := envVarValue(*envVar)
+	if value == current {
+		fmt.Printf("%s already has this value\n", key)
+		return
+	}
+
+	reveal := newSecretReveal(c)
+	fmt.Printf("%s\n", key)
+	fmt.Printf("  current:  %s\n", reveal.value(key, current))
+	fmt.Printf("  restored: %s\n", reveal.value(key, value))
+	if !c.Bool("y") {
+		mustConfirm(fmt.Sprintf("Restore %s on %s? [yes/N]", key, stack.Name), "yes")
+	}
+
+	applyEnvVars(c, *stack, []envVarUpdate{{Key: key, Value: value, Existing: true}}, flagApplyStrategy, "env-vars restore")
+}
+
+// finds the value to restore. to is either the index of the value in the history, as shown
+// by 'env-vars list --history' starting from 1, or a timestamp to restore the value in effect at the time.
+// The time of a history entry is its updated_at, which is the time shown by 'env-vars list --history':
+// the entry is the value in effect from that time until the next entry, or the current value
+func historicalEnvVarValue(envVar cloud66.StackEnvVar, to string) (string, error) {
+	if index, err := strconv.Atoi(to); err == nil {
+		if index < 1 || index > len(envVar.History) {
+			return "", fmt.Errorf("%s has %d history entries, so %d is not a valid index", envVar.Key, len(envVar.History), index)
+		}
+		return historyValue(envVar.History[index-1]), nil
+	}
+
+	var at time.Time
+	var err error
+	for _, layout := range envVarRestoreLayouts {
+		if at, err = time.ParseInLocation(layout, to, time.Local); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%q is neither a history index nor a timestamp like 2006-01-02 15:04", to)
+	}
+
+	// the current value has been in effect since it was last updated
+	if !envVar.UpdatedAt.After(at) {
+		return envVarValue(envVar), nil
+	}
+
+	var found *cloud66.StackEnvVarHistory
+	for idx := range envVar.History {
+		entry := envVar.History[idx]
+		if !entry.UpdatedAt.After(at) && (found == nil || entry.UpdatedAt.After(found.UpdatedAt)) {
+			found = &entry
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("%s had no value at %s", envVar.Key, at.Format(time.RFC1123))
+	}
+	return historyValue(*found), nil
+}
+
+func historyValue(entry cloud66.StackEnvVarHistory) string {
+	if entry.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(entry.Value)
+}
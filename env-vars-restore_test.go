@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment variable history", func() {
+	jan := time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local)
+	feb := time.Date(2019, 2, 1, 0, 0, 0, 0, time.Local)
+	mar := time.Date(2019, 3, 1, 0, 0, 0, 0, time.Local)
+	envVar := cloud66.StackEnvVar{
+		Key:       "KEY",
+		Value:     "current",
+		UpdatedAt: mar,
+		History: []cloud66.StackEnvVarHistory{
+			{Value: "first", UpdatedAt: jan},
+			{Value: "second", UpdatedAt: feb},
+		},
+	}
+
+	It("should find values by index", func() {
+		Expect(historicalEnvVarValue(envVar, "2")).To(Equal("second"))
+		_, err := historicalEnvVarValue(envVar, "3")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should find the value in effect at a timestamp", func() {
+		Expect(historicalEnvVarValue(envVar, "2019-01-15")).To(Equal("first"))
+		Expect(historicalEnvVarValue(envVar, "2019-02-01 00:00")).To(Equal("second"))
+		Expect(historicalEnvVarValue(envVar, "2019-04-01")).To(Equal("current"))
+		_, err := historicalEnvVarValue(envVar, "2018-12-01")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -18,23 +18,40 @@ func runEnvVarsSet(c *cli.Context) {
 	}
 
 	// when to apply the env var changes
-	flagApplyStrategy := c.String("apply-strategy")
-	if flagApplyStrategy == "" {
-		// default the apply-strategy
-		flagApplyStrategy = "immediately"
-	} else if flagApplyStrategy != "immediately" && flagApplyStrategy != "deployment" {
-		printFatal("The selected apply-strategy is not valid. Please choose from \"immediately\" or \"deployment\"")
-	}
+	flagApplyStrategy := mustApplyStrategy(c)
 
 	kv := c.Args()[0]
-	kvs := strings.Split(kv, "=")
-	if len(kvs) < 2 {
-		cli.ShowSubcommandHelp(c)
-		os.Exit(2)
+	var (
+		key   string
+		value string
+		err   error
+	)
+	fromFile := c.String("from-file")
+	if c.Bool("stdin") {
+		if fromFile != "" {
+			printFatal("--from-file and --stdin cannot be used together")
+		}
+		fromFile = "-"
+	}
+	if fromFile != "" {
+		// the value comes from the file, so only the key is given
+		key = strings.TrimSuffix(kv, "=")
+		if key == "" || strings.Contains(key, "=") {
+			printFatal("Only the name of the environment variable can be given with --from-file or --stdin")
+		}
+		value, err = readValueFile(fromFile)
+		if err != nil {
+			printFatal("%s", err.Error())
+		}
+	} else {
+		kvs := strings.Split(kv, "=")
+		if len(kvs) < 2 {
+			cli.ShowSubcommandHelp(c)
+			os.Exit(2)
+		}
+		key = kvs[0]
+		value = strings.Join(kvs[1:], "=")
 	}
-
-	key := kvs[0]
-	value := strings.Join(kvs[1:], "=")
 
 	stack := mustStack(c)
 
@@ -53,6 +70,17 @@ func runEnvVarsSet(c *cli.Context) {
 	}
 
 	if flagApplyStrategy == "immediately" {
+		mustNotBeLocked(c, *stack)
+		mustPassPolicy(c, *stack, policyAction{Command: "env-vars set"})
+	}
+
+	// only changes applied immediately are deployments of their own
+	payload := newHookPayload(hookPreDeploy, "env-vars set", *stack)
+	payload.EnvVar = key
+	deploying := flagApplyStrategy == "immediately"
+
+	if deploying {
+		mustFireHooks(payload)
 		fmt.Println("Please wait while your changes are applied immediately...")
 	} else {
 		fmt.Println("Your changes will be applied during your next deployment!")
@@ -60,17 +88,43 @@ func runEnvVarsSet(c *cli.Context) {
 
 	asyncId, err := startEnvVarSet(stack.Uid, key, value, existing, flagApplyStrategy)
 	if err != nil {
+		if deploying {
+			fireHooks(payload.finished(false, err.Error()))
+		}
 		printFatal(err.Error())
 	}
-	genericRes, err := endEnvVarSet(*asyncId, stack.Uid)
+	// the post-deploy hooks need the outcome of the change
+	genericRes, err := endEnvVarSet(*asyncId, stack.Uid, deploying && postDeployHooksActive())
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
+		if deploying {
+			fireHooks(payload.finished(false, err.Error()))
+		}
 		printFatal(err.Error())
 	}
+	if deploying {
+		fireHooks(payload.finished(genericRes.Status, genericRes.Message))
+	}
 	printGenericResponse(*genericRes)
 
 	return
 }
 
+// returns the apply-strategy option, which defaults to immediately
+func mustApplyStrategy(c *cli.Context) string {
+	flagApplyStrategy := c.String("apply-strategy")
+	if flagApplyStrategy == "" {
+		// default the apply-strategy
+		return "immediately"
+	}
+	if flagApplyStrategy != "immediately" && flagApplyStrategy != "deployment" {
+		printFatal("The selected apply-strategy is not valid. Please choose from \"immediately\" or \"deployment\"")
+	}
+	return flagApplyStrategy
+}
+
 func startEnvVarSet(stackUid string, key string, value string, existing bool, applyStrategy string) (*int, error) {
 	var (
 		asyncRes *cloud66.AsyncResult
@@ -87,6 +141,10 @@ func startEnvVarSet(stackUid string, key string, value string, existing bool, ap
 	return &asyncRes.Id, err
 }
 
-func endEnvVarSet(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 3*time.Second, 20*time.Minute, true)
+// waits for the change to be applied. With mustWait it waits even with --no-wait
+func endEnvVarSet(asyncId int, stackUid string, mustWait bool) (*cloud66.GenericResponse, error) {
+	if mustWait {
+		return waitForStackAsyncAction(asyncId, stackUid, 3*time.Second, 20*time.Minute, true)
+	}
+	return waitStackAsyncAction(asyncId, stackUid, 3*time.Second, 20*time.Minute, true)
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+func runEnvVarsUnset(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+
+	flagApplyStrategy := mustApplyStrategy(c)
+	stack := mustStack(c)
+
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+
+	current := make(map[string]cloud66.StackEnvVar)
+	for _, envVar := range envVars {
+		current[envVar.Key] = envVar
+	}
+
+	var updates []envVarUpdate
+	for _, key := range c.Args() {
+		envVar, ok := current[key]
+		if !ok {
+			printFatal("Environment variable %s not found", key)
+		}
+		if envVar.Readonly {
+			printFatal("%s is readonly and cannot be removed", key)
+		}
+		updates = append(updates, envVarUpdate{Key: key, Existing: true, Unset: true})
+	}
+
+	if !c.Bool("y") {
+		mustConfirm(fmt.Sprintf("Remove %d environment variable(s) from %s? [yes/N]", len(updates), stack.Name), "yes")
+	}
+
+	applyEnvVars(c, *stack, updates, flagApplyStrategy, "env-vars unset")
+}
+
+func startEnvVarUnset(stackUid string, key string, applyStrategy string) (*int, error) {
+	params := struct {
+		ApplyStrategy string `json:"apply_strategy"`
+	}{
+		ApplyStrategy: applyStrategy,
+	}
+
+	var asyncRes *cloud66.AsyncResult
+	err := client.APIReq(&asyncRes, "DELETE", "/stacks/"+stackUid+"/environments/"+key+".json", params, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &asyncRes.Id, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"sort"
@@ -27,12 +28,12 @@ func buildEnvVars() cli.Command {
 			Name:   "list",
 			Usage:  "lists environment variables",
 			Action: runEnvVars,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.BoolFlag{
 					Name:  "history",
 					Usage: "show environment variable history",
 				},
-			},
+			}, revealFlags()...),
 			Description: `Lists all the environment variables of the given stack.
 The environment_variables options can be a list of multiple environment_variables as separate parameters.
 To change environment variable values, use the env-vars set command.
@@ -62,33 +63,233 @@ $ cx env-vars list -s mystack -history STACK_BASE
 STACK_BASE      	/abc/def
 --> 2015-02-24 12:32:11     /xyz/123
 --> 2015-03-12 15:54:08     /xyz/456
+
+Values of keys like *PASSWORD*, *SECRET*, *TOKEN* and *_KEY, and credentials in URLs, are masked,
+including in the history. More patterns can be added with cx config update --secret-patterns.
+Use --reveal to show all values, or --reveal-key to show some of them:
+
+$ cx env-vars list -s mystack DATABASE_URL SECRET_KEY_BASE
+DATABASE_URL     	postgres://app:******(3fa2c9e1)@db/app
+SECRET_KEY_BASE  	******(8d01b6f4)
+
+$ cx env-vars list -s mystack --reveal-key SECRET_KEY_BASE
 `,
 		},
 		{
 			Name:   "set",
 			Usage:  "sets the value of an environment variable on a stack",
 			Action: runEnvVarsSet,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "apply-strategy",
 					Usage: "apply changes immediately, or during next deployment",
 				},
-			},
+				cli.StringFlag{
+					Name:  "from-file",
+					Usage: "set the value to the content of this file",
+				},
+				cli.BoolFlag{
+					Name:  "stdin",
+					Usage: "read the value from stdin",
+				},
+			}, deployGuardFlags()...),
 			Description: `This sets and applies the value of an environment variable on a stack.
 This work happens in the background, therefore this command will return immediately after the operation has started.
 
 You can use the apply-strategy option to specify "immediately" or "deployment". This will determine how Cloud 66 will apply
 these environment variables to your servers. The default is "immediately" (for backwards compatibility) 
+
+Use --from-file path to set the variable to the content of a file, or --stdin to read it from stdin. Only the name
+of the variable is given then.
 			
 Warning! Applying environment variable changes "immediately" will result in all your environment variables
 being sent to your servers immediately, and running processes being restarted. NOTE: If you have load balancer, we will
 automatically remove servers from the load balancer before applying changes.
+
+Changes applied "immediately" are checked against the deployment policy (see 'cx help config update').
+Use --override-policy "reason" to apply them anyway. Use --force to apply them to a stack locked by someone else
+with 'cx stacks lock'.
 			
 Examples:
 $ cx env-vars set -s mystack FIRST_VAR=123
 $ cx env-vars set -s mystack SECOND_ONE='this value has a space in it'
 $ cx env-vars set -s mystack --apply-strategy=immediately EXAMPLE1='this will be applied on immediately' 
 $ cx env-vars set -s mystack --apply-strategy=deployment EXAMPLE2='this will be applied on next deployment'
+$ cx env-vars set -s mystack --from-file server.crt TLS_CERT
+$ cat server.key | cx env-vars set -s mystack --stdin TLS_KEY
+`,
+		},
+		{
+			Name:   "unset",
+			Usage:  "removes environment variables from a stack",
+			Action: runEnvVarsUnset,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "apply-strategy",
+					Usage: "apply changes immediately, or during next deployment",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			}, deployGuardFlags()...),
+			Description: `Removes one or more environment variables from a stack. Readonly variables cannot be removed.
+
+Like 'env-vars set', the apply-strategy can be "immediately" (the default) or "deployment". When removing several
+variables, they are all removed first and the change is applied once.
+
+Examples:
+$ cx env-vars unset -s mystack OLD_VAR
+$ cx env-vars unset -s mystack FIRST_VAR SECOND_VAR --apply-strategy deployment -y
+`,
+		},
+		{
+			Name:   "restore",
+			Usage:  "restores a previous value of an environment variable",
+			Action: runEnvVarsRestore,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "history index (as shown by 'env-vars list --history') or timestamp of the value to restore",
+				},
+				cli.StringFlag{
+					Name:  "apply-strategy",
+					Usage: "apply changes immediately, or during next deployment",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			}, append(deployGuardFlags(), revealFlags()...)...),
+			Description: `Sets an environment variable back to one of its previous values.
+
+--to is either the index of the value in the history of the variable, as shown by 'env-vars list --history',
+or a timestamp (like "2019-03-12 15:54" in local time, or RFC3339) to restore the value in effect at that time.
+Each value in the history is in effect from the time shown next to it by 'env-vars list --history'.
+The current and restored values are shown before applying the change, with secret looking values and
+credentials in URLs masked. Use --reveal to show them.
+
+Examples:
+$ cx env-vars list -s mystack --history STACK_BASE
+$ cx env-vars restore -s mystack STACK_BASE --to 2
+$ cx env-vars restore -s mystack STACK_BASE --to "2019-03-12 15:54"
+`,
+		},
+		{
+			Name:   "import",
+			Usage:  "sets environment variables from a dotenv file",
+			Action: runEnvVarsImport,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "file,f",
+					Usage: "dotenv file to import. Use - to read it from stdin",
+				},
+				cli.StringFlag{
+					Name:  "apply-strategy",
+					Usage: "apply changes immediately, or during next deployment",
+				},
+				cli.BoolFlag{
+					Name:  "diff",
+					Usage: "only show the differences between the file and the stack, without applying them",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			}, append(deployGuardFlags(), revealFlags()...)...),
+			Description: `Sets the environment variables of a stack from a dotenv file.
+
+The file holds one KEY=value per line. Blank lines, comments (#) and an "export " prefix are allowed.
+Values can be quoted with single quotes (taken literally) or double quotes (supporting \n, \t, \" and \\ escapes).
+Values are imported as they are, and are never read from a file or stdin.
+
+The differences between the file and the stack are shown before applying them, with secret looking values and
+credentials in URLs masked. Use --reveal or --reveal-key to show them, and --diff to only show the differences. Readonly variables are skipped with a warning, and variables which are not in the file
+are listed but kept on the stack.
+
+All changes are applied as one batch: every variable is saved first and the changes are applied once, using
+the apply-strategy ("immediately" by default, or "deployment"), instead of restarting the servers for each variable.
+Changes applied "immediately" are checked against the deployment lock and policy like 'env-vars set'.
+
+Examples:
+$ cx env-vars import -s mystack -f .env --diff
+$ cx env-vars import -s mystack -f .env
+$ cx env-vars import -s mystack -f .env --apply-strategy deployment -y
+$ cx env-vars export -s staging-stack | cx env-vars import -s mystack -f - -y
+`,
+		},
+		{
+			Name:   "export",
+			Usage:  "writes the environment variables of a stack as a dotenv file",
+			Action: runEnvVarsExport,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "file to write to, instead of stdout",
+				},
+				cli.BoolFlag{
+					Name:  "include-readonly",
+					Usage: "also export the readonly variables generated by Cloud 66",
+				},
+			},
+			Description: `Writes the environment variables of a stack in dotenv format, ready to be used with 'env-vars import'.
+Readonly variables are left out unless --include-readonly is used.
+
+Examples:
+$ cx env-vars export -s mystack > .env
+$ cx env-vars export -s mystack -o .env --include-readonly
+`,
+		},
+		{
+			Name:   "exec",
+			Usage:  "runs a local command with the environment variables of a stack",
+			Action: runEnvVarsExec,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "only",
+					Usage: "glob pattern of keys to pass to the command. Repeatable, or comma separated",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringSliceFlag{
+					Name:  "except",
+					Usage: "glob pattern of keys to leave out. Repeatable, or comma separated",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringSliceFlag{
+					Name:  "rewrite-host",
+					Usage: "replace a host (and optional port) in the values, as from=to. Repeatable",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringFlag{
+					Name:  "tunnel",
+					Usage: "server to open a tunnel to while the command runs",
+				},
+				cli.StringSliceFlag{
+					Name:  "tunnel-port",
+					Usage: "remote port (and optional local port, as remote:local) to tunnel to. Repeatable",
+					Value: &cli.StringSlice{},
+				},
+				gatewayKeyFlag(),
+			},
+			Description: `Runs a local command with the environment variables of a stack added to its environment.
+The variables are only passed to the command and never written to disk. Use -- to separate the command from the cx options.
+
+Use --only and --except to choose the variables to pass, and --rewrite-host to point the values at other hosts,
+for example a database reachable from your machine. Rewrites apply to whole host names, with or without a port.
+
+With --tunnel, an SSH tunnel is opened to the server for each --tunnel-port while the command runs, and
+the address and DNS name of the server (with the tunnelled port) are rewritten to 127.0.0.1 and the local port.
+Variables ending in _PORT that hold a tunnelled port are changed to the local port too.
+If a local port is not given, remote + 1 is used like 'cx tunnel'. Tunnels are not supported on Windows.
+
+The exit code of cx is the exit code of the command.
+
+Examples:
+$ cx env-vars exec -s mystack -- bundle exec rails console
+$ cx env-vars exec -s mystack --only 'AWS_*' -- aws s3 ls
+$ cx env-vars exec -s mystack --except 'RAILS_*,RACK_*' -- ./scripts/report.sh
+$ cx env-vars exec -s mystack --rewrite-host 10.0.0.5=db.example.com -- psql
+$ cx env-vars exec -s mystack --tunnel db --tunnel-port 5432:15432 -- bundle exec rails console
 `,
 		},
 	}
@@ -107,10 +308,11 @@ func runEnvVars(c *cli.Context) {
 
 	envVarKeys := c.Args()
 	flagShowHistory := c.Bool("history")
+	reveal := newSecretReveal(c)
 
 	sort.Strings(envVarKeys)
 	if len(envVarKeys) == 0 {
-		printEnvVarsList(w, envVars, flagShowHistory)
+		printEnvVarsList(w, envVars, flagShowHistory, reveal)
 	} else {
 		// filter out the unwanted env_vars
 		var filteredEnvVars []cloud66.StackEnvVar
@@ -120,20 +322,20 @@ func runEnvVars(c *cli.Context) {
 				filteredEnvVars = append(filteredEnvVars, i)
 			}
 		}
-		printEnvVarsList(w, filteredEnvVars, flagShowHistory)
+		printEnvVarsList(w, filteredEnvVars, flagShowHistory, reveal)
 	}
 }
 
-func printEnvVarsList(w io.Writer, envVars []cloud66.StackEnvVar, showHistory bool) {
+func printEnvVarsList(w io.Writer, envVars []cloud66.StackEnvVar, showHistory bool, reveal secretReveal) {
 	sort.Sort(envVarsByName(envVars))
 	for _, a := range envVars {
 		if a.Key != "" {
-			listEnvVar(w, a, showHistory)
+			listEnvVar(w, a, showHistory, reveal)
 		}
 	}
 }
 
-func listEnvVar(w io.Writer, a cloud66.StackEnvVar, showHistory bool) {
+func listEnvVar(w io.Writer, a cloud66.StackEnvVar, showHistory bool, reveal secretReveal) {
 	var readonly string
 	if a.Readonly {
 		readonly = "readonly"
@@ -142,13 +344,13 @@ func listEnvVar(w io.Writer, a cloud66.StackEnvVar, showHistory bool) {
 	}
 	listRec(w,
 		a.Key,
-		a.Value,
+		reveal.value(a.Key, a.Value),
 		readonly,
 	)
 
 	if showHistory {
-		for _, h := range a.History {
-			listRec(w, "----->", h.Value, h.UpdatedAt)
+		for idx, h := range a.History {
+			listRec(w, fmt.Sprintf("-----> %d", idx+1), reveal.value(a.Key, h.Value), h.UpdatedAt)
 		}
 	}
 }
@@ -129,7 +129,7 @@ $ cx formations list -s mystack foo bar // only show formations foo and bar
 			Name:   "deploy",
 			Action: runDeployFormation,
 			Usage:  "Deploy an existing formation",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "formation,f",
 					Usage: "the formation name",
@@ -150,7 +150,7 @@ $ cx formations list -s mystack foo bar // only show formations foo and bar
 					Name:  "log-level",
 					Usage: "[OPTIONAL, DEFAULT: info] log level. Use debug to see process output",
 				},
-			},
+			}, deployGuardFlags()...),
 		},
 		{
 			Name:  "bundle",
@@ -457,6 +457,9 @@ func runDeployFormation(c *cli.Context) {
 		printFatal("Formation with name \"%v\" could not be found", formationName)
 	}
 
+	mustNotBeLocked(c, *stack)
+	mustPassPolicy(c, *stack, policyAction{Command: "formations deploy"})
+
 	snapshotUID := c.String("snapshot-uid")
 	if snapshotUID == "" {
 		snapshotUID = "latest"
@@ -488,14 +491,21 @@ func runDeployFormation(c *cli.Context) {
 		Timeout:     10 * time.Minute,
 	}
 
+	payload := newHookPayload(hookPreDeploy, "formations deploy", *stack)
+	payload.Formation = formation.Name
+	mustFireHooks(payload)
+
 	workflow, err := trackmanType.LoadWorkflowFromReader(ctx, options, reader)
 	runErrors, stepErrors := workflow.Run(ctx)
 	if runErrors != nil {
+		fireHooks(payload.finished(false, runErrors.Error()))
 		printFatal(runErrors.Error())
 	}
 	if stepErrors != nil {
+		fireHooks(payload.finished(false, stepErrors.Error()))
 		printFatal(stepErrors.Error())
 	}
+	fireHooks(payload.finished(true, fmt.Sprintf("formation %s deployed", formation.Name)))
 }
 
 func runBundleDownload(c *cli.Context) {
@@ -1066,6 +1076,8 @@ func uploadEnvironmentVariables(fb *cloud66.FormationBundle, formation *cloud66.
 		}
 	}
 	for key, value := range envVars {
+		// bundle logs end up in CI output, so only the key is shown
+		fmt.Printf("Adding environment variable %s\n", key)
 		asyncResult, err := client.StackEnvVarNew(stack.Uid, key, value, "")
 		if err != nil {
 			if err.Error() == "Another environment variable with the same key exists. Use PUT to change it." {
@@ -1075,7 +1087,8 @@ func uploadEnvironmentVariables(fb *cloud66.FormationBundle, formation *cloud66.
 			}
 		}
 		if asyncResult != nil {
-			_, err = endEnvVarSet(asyncResult.Id, stack.Uid)
+			// the variables are set one after the other, so each one is waited for even with --no-wait
+			_, err = endEnvVarSet(asyncResult.Id, stack.Uid, true)
 			if err != nil {
 				return err
 			}
@@ -140,12 +140,7 @@ func runListGateways(c *cli.Context) {
 			}
 
 			for _, g := range result {
-				state := "N/A"
-				if (len(g.Content) > 0) && (strings.Compare(g.Content, "N/A") != 0) {
-					state = "open"
-				} else {
-					state = "close"
-				}
+				state := gatewayState(g)
 
 				ttl_string := "N/A"
 				t, err := time.Parse("2006-01-02T15:04:05Z", g.Ttl)
@@ -361,11 +356,7 @@ func findGatwayInfo(c *cli.Context, gatewayName string) (int, int, string) {
 			if strings.Compare(g.Name, gatewayName) == 0 {
 				resultGatewayId = g.Id
 				resultAccountId = org.Id
-				if (len(g.Content) > 0) && (strings.Compare(g.Content, "N/A") != 0) {
-					resultState = "open"
-				} else {
-					resultState = "close"
-				}
+				resultState = gatewayState(g)
 				break
 			}
 		}
@@ -378,3 +369,11 @@ func findGatwayInfo(c *cli.Context, gatewayName string) (int, int, string) {
 
 	return resultAccountId, resultGatewayId, resultState
 }
+
+// open gateways have a key, closed ones don't
+func gatewayState(g cloud66.Gateway) string {
+	if (len(g.Content) > 0) && (strings.Compare(g.Content, "N/A") != 0) {
+		return "open"
+	}
+	return "close"
+}
@@ -73,6 +73,72 @@ func remoteGitUrl() string {
 	return strings.TrimSpace(string(b))
 }
 
+// returns the full SHA of the local HEAD commit
+func localGitCommit() (string, error) {
+	b, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the local git commit: %s", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// returns the name of the checked out branch, or HEAD if it is detached
+func localGitCurrentBranch() string {
+	b, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// returns true if the working tree has no uncommitted or untracked changes
+func localGitIsClean() (bool, error) {
+	b, err := exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		return false, fmt.Errorf("unable to check the git working tree: %s", err)
+	}
+	return strings.TrimSpace(string(b)) == "", nil
+}
+
+// finds the name of the local git remote pointing to the given URL
+func localGitRemoteFor(gitUrl string) (string, error) {
+	b, err := exec.Command("git", "remote").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to list the git remotes: %s", err)
+	}
+
+	for _, remote := range strings.Fields(string(b)) {
+		u, err := exec.Command("git", "config", "remote."+remote+".url").Output()
+		if err != nil {
+			continue
+		}
+		same, err := areSameRemotes(string(u), gitUrl)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			return remote, nil
+		}
+	}
+
+	return "", nil
+}
+
+// returns true if the commit is reachable from any of the remote tracking branches of the remote
+func localGitIsPushed(commit string, remote string) (bool, error) {
+	b, err := exec.Command("git", "branch", "-r", "--contains", commit).Output()
+	if err != nil {
+		return false, fmt.Errorf("unable to check the remote branches: %s", err)
+	}
+
+	for _, branch := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(branch), remote+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // search for the given stack using the git URL and branch
 func stackFromGitRemote(gitUrl string, gitBranch string) (*cloud66.Stack, error) {
 	stacks, err := client.StackList()
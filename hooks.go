@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+)
+
+const (
+	hookPreDeploy           = "pre-deploy"
+	hookPostDeploySuccess   = "post-deploy-success"
+	hookPostDeployFailure   = "post-deploy-failure"
+	hookAsyncActionFinished = "async-action-finished"
+)
+
+var hookEvents = []string{hookPreDeploy, hookPostDeploySuccess, hookPostDeployFailure, hookAsyncActionFinished}
+
+const (
+	defaultCommandHookTimeout = 5 * time.Minute
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// deployHooks holds the hooks to run for each event
+type deployHooks map[string][]eventHook
+
+// eventHook is either a local command, which receives the payload on stdin, or a URL the payload is posted to
+type eventHook struct {
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	Url     string `json:"url,omitempty" yaml:"url,omitempty"`
+	// used to sign webhook payloads. Environment variables like $HOOK_SECRET are expanded
+	Secret  string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// hookPayload is the JSON document sent to hooks
+type hookPayload struct {
+	Event         string    `json:"event"`
+	Command       string    `json:"command,omitempty"`
+	Stack         string    `json:"stack,omitempty"`
+	StackUid      string    `json:"stack_uid"`
+	Environment   string    `json:"environment,omitempty"`
+	GitRef        string    `json:"git_ref,omitempty"`
+	Services      []string  `json:"services,omitempty"`
+	Formation     string    `json:"formation,omitempty"`
+	EnvVar        string    `json:"env_var,omitempty"`
+	AsyncActionId *int      `json:"async_action_id,omitempty"`
+	Success       *bool     `json:"success,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+func newHookPayload(event string, command string, stack cloud66.Stack) hookPayload {
+	return hookPayload{
+		Event:       event,
+		Command:     command,
+		Stack:       stack.Name,
+		StackUid:    stack.Uid,
+		Environment: stack.Environment,
+		Timestamp:   time.Now().UTC(),
+	}
+}
+
+// returns a copy of a pre-deploy payload for the post-deploy event matching the result
+func (p hookPayload) finished(success bool, message string) hookPayload {
+	if success {
+		p.Event = hookPostDeploySuccess
+	} else {
+		p.Event = hookPostDeployFailure
+	}
+	p.Success = &success
+	p.Message = message
+	p.Timestamp = time.Now().UTC()
+	return p
+}
+
+// commands running post-deploy hooks wait for the deployment even with --no-wait when there are any, as they need its outcome
+func postDeployHooksActive() bool {
+	return len(activeHooks(hookPostDeploySuccess)) > 0 || len(activeHooks(hookPostDeployFailure)) > 0
+}
+
+// returns the hooks from the selected profile and .cx.yml for the given event
+func activeHooks(event string) []eventHook {
+	var hooks []eventHook
+	if selectedProfile != nil {
+		hooks = append(hooks, selectedProfile.Hooks[event]...)
+	}
+	if dotYaml != nil {
+		hooks = append(hooks, dotYaml.Hooks[event]...)
+	}
+	return hooks
+}
+
+// runs the hooks of the payload event and stops if any of them fail. Used for pre-deploy hooks
+func mustFireHooks(payload hookPayload) {
+	if err := runHooks(payload); err != nil {
+		printFatal("%s hook failed: %s", payload.Event, err.Error())
+	}
+}
+
+// runs the hooks of the payload event, only warning about failures
+func fireHooks(payload hookPayload) {
+	if err := runHooks(payload); err != nil {
+		printWarning("%s hook failed: %s", payload.Event, err.Error())
+	}
+}
+
+func runHooks(payload hookPayload) error {
+	hooks := activeHooks(payload.Event)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if err := hook.run(payload.Event, body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h eventHook) run(event string, body []byte) error {
+	if (h.Command == "") == (h.Url == "") {
+		return fmt.Errorf("a hook needs either a command or a url")
+	}
+
+	timeout := defaultWebhookTimeout
+	if h.Command != "" {
+		timeout = defaultCommandHookTimeout
+	}
+	if h.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("invalid hook timeout %q", h.Timeout)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if h.Command != "" {
+		return runCommandHook(ctx, h.Command, event, body)
+	}
+	return postWebhook(ctx, h.Url, os.ExpandEnv(h.Secret), event, body)
+}
+
+func runCommandHook(ctx context.Context, command string, event string, body []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "CX_EVENT="+event)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s", command, err)
+	}
+	return nil
+}
+
+func postWebhook(ctx context.Context, url string, secret string, event string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cx/"+VERSION)
+	req.Header.Set("X-Cx-Event", event)
+	if secret != "" {
+		req.Header.Set("X-Cx-Signature", signHookPayload(secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// signs the payload with HMAC-SHA256, in the same format as GitHub webhooks
+func signHookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// returns the async-action-finished payload for an action of the stack
+func asyncActionHookPayload(asyncId int, stackUid string, success bool, message string) hookPayload {
+	return hookPayload{
+		Event:         hookAsyncActionFinished,
+		StackUid:      stackUid,
+		AsyncActionId: &asyncId,
+		Success:       &success,
+		Message:       message,
+		Timestamp:     time.Now().UTC(),
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment hooks", func() {
+	stack := cloud66.Stack{Name: "app", Uid: "abc", Environment: "production"}
+
+	It("should turn a pre-deploy payload into the matching post-deploy one", func() {
+		payload := newHookPayload(hookPreDeploy, "redeploy", stack)
+		Expect(payload.finished(true, "ok").Event).To(Equal(hookPostDeploySuccess))
+		failed := payload.finished(false, "boom")
+		Expect(failed.Event).To(Equal(hookPostDeployFailure))
+		Expect(*failed.Success).To(BeFalse())
+		Expect(payload.Success).To(BeNil())
+	})
+
+	It("should post signed payloads to webhooks", func() {
+		// mocks left behind by other suites would intercept the request
+		gock.Off()
+		var received hookPayload
+		var signature string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			signature = r.Header.Get("X-Cx-Signature")
+			Expect(signature).To(Equal(signHookPayload("secret", body)))
+			json.Unmarshal(body, &received)
+		}))
+		defer server.Close()
+
+		body, _ := json.Marshal(newHookPayload(hookPreDeploy, "redeploy", stack))
+		Expect(eventHook{Url: server.URL, Secret: "secret"}.run(hookPreDeploy, body)).To(Succeed())
+		Expect(received.Stack).To(Equal("app"))
+		Expect(signature).To(HavePrefix("sha256="))
+	})
+
+	It("should report failing commands", func() {
+		Expect(eventHook{Command: "exit 1"}.run(hookPreDeploy, []byte("{}"))).NotTo(Succeed())
+		Expect(eventHook{Command: "cat > /dev/null"}.run(hookPreDeploy, []byte("{}"))).To(Succeed())
+	})
+})
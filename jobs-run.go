@@ -52,6 +52,9 @@ func runJobRun(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endJobRun(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -68,5 +71,5 @@ func startJobRun(stackUid string, jobId string, jobArgs *string) (*int, error) {
 }
 
 func endJobRun(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHost is a pinned host key. Stack servers are pinned by their uid, so the pin survives an address change.
+// Other hosts, like gateways and servers being registered, are pinned by their address
+type knownHost struct {
+	Uid     string
+	Address string
+	Key     ssh.PublicKey
+}
+
+func (h knownHost) pins(target sshTarget) bool {
+	if target.Uid != "" {
+		return h.Uid == target.Uid
+	}
+	return h.Uid == "" && h.Address == target.Host
+}
+
+// guards reading and writing the known hosts file, as connections can be opened in parallel
+var knownHostsMutex sync.Mutex
+
+func knownHostsPath() string {
+	return filepath.Join(cxHome(), "known_hosts")
+}
+
+// the known hosts file has a line per host: <server uid or -> <address> <key type> <base64 key>
+func readKnownHosts() ([]knownHost, error) {
+	file, err := os.Open(knownHostsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []knownHost
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: invalid line", knownHostsPath(), line)
+		}
+		data, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", knownHostsPath(), line, err.Error())
+		}
+		key, err := ssh.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", knownHostsPath(), line, err.Error())
+		}
+		host := knownHost{Address: fields[1], Key: key}
+		if fields[0] != "-" {
+			host.Uid = fields[0]
+		}
+		result = append(result, host)
+	}
+	return result, scanner.Err()
+}
+
+func writeKnownHosts(hosts []knownHost) error {
+	var buffer bytes.Buffer
+	buffer.WriteString("# host keys pinned by cx. Use 'cx ssh forget-host' to remove one\n")
+	for _, host := range hosts {
+		uid := host.Uid
+		if uid == "" {
+			uid = "-"
+		}
+		fmt.Fprintf(&buffer, "%s %s %s %s\n", uid, host.Address, host.Key.Type(), base64.StdEncoding.EncodeToString(host.Key.Marshal()))
+	}
+
+	// replace the file in one go, so a failed write doesn't lose the other pins
+	return writeFileAtomically(knownHostsPath(), buffer.Bytes(), 0600)
+}
+
+func findKnownHost(target sshTarget) (*knownHost, error) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	hosts, err := readKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		if host.pins(target) {
+			return &host, nil
+		}
+	}
+	return nil, nil
+}
+
+// replaces the pin of the target with the given key
+func pinHostKey(target sshTarget, key ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	hosts, err := readKnownHosts()
+	if err != nil {
+		return err
+	}
+	var result []knownHost
+	for _, host := range hosts {
+		if !host.pins(target) {
+			result = append(result, host)
+		}
+	}
+	result = append(result, knownHost{Uid: target.Uid, Address: target.Host, Key: key})
+	return writeKnownHosts(result)
+}
+
+// removes the pins of the given server uids and addresses. Returns the number of pins removed
+func forgetKnownHosts(uids []string, addresses []string) (int, error) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	hosts, err := readKnownHosts()
+	if err != nil {
+		return 0, err
+	}
+	var result []knownHost
+	for _, host := range hosts {
+		if (host.Uid != "" && stringsIndex(uids, host.Uid) != -1) || stringsIndex(addresses, host.Address) != -1 || stringsIndex(addresses, knownHostName(host.Address)) != -1 {
+			continue
+		}
+		result = append(result, host)
+	}
+	if len(result) == len(hosts) {
+		return 0, nil
+	}
+	return len(hosts) - len(result), writeKnownHosts(result)
+}
+
+// the host of an address which might have a port
+func knownHostName(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// checks the host key against the pin of the target, pinning it on first use
+func hostKeyCallback(target sshTarget, pin *knownHost) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if pin == nil {
+			if err := pinHostKey(target, key); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Pinned the host key of %s (%s %s) in %s\n", target.displayName(), key.Type(), ssh.FingerprintSHA256(key), knownHostsPath())
+			return nil
+		}
+
+		if !bytes.Equal(pin.Key.Marshal(), key.Marshal()) {
+			return &hostKeyMismatchError{target: target, expected: pin.Key, actual: key}
+		}
+		if pin.Address != target.Host {
+			target.debugf(1, "%s moved from %s to %s", target.displayName(), pin.Address, target.Host)
+			return pinHostKey(target, key)
+		}
+		return nil
+	}
+}
+
+type hostKeyMismatchError struct {
+	target   sshTarget
+	expected ssh.PublicKey
+	actual   ssh.PublicKey
+}
+
+func (e *hostKeyMismatchError) Error() string {
+	return fmt.Sprintf(`
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+The host key of %s is not the one pinned in %s.
+Someone could be eavesdropping on you right now (man-in-the-middle attack), or the server was rebuilt.
+Expected %s %s, got %s %s.
+If the server was rebuilt, remove the pin and connect again:
+$ cx ssh forget-host %s`,
+		e.target.displayName(), knownHostsPath(),
+		e.expected.Type(), ssh.FingerprintSHA256(e.expected), e.actual.Type(), ssh.FingerprintSHA256(e.actual),
+		knownHostName(e.target.Host))
+}
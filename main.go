@@ -41,7 +41,6 @@ const (
 var (
 	client          cloud66.Client
 	debugMode       bool   = false
-	underTest       bool   = false
 	VERSION         string = "dev"
 	BUILD_DATE      string = ""
 	selectedProfile *Profile
@@ -65,6 +64,7 @@ var commands = []*Command{
 	cmdTail,
 	cmdUpload,
 	cmdDownload,
+	cmdSync,
 	cmdBackups,
 	cmdContainers,
 	cmdServices,
@@ -84,12 +84,15 @@ var commands = []*Command{
 	cmdVersion,
 	cmdDumpToken,
 	cmdConfig,
+	cmdActions,
+	cmdConfigStore,
 }
 
 var (
 	flagStack       *cloud66.Stack
 	flagOrg         *cloud66.Account
 	flagEnvironment string
+	flagNoWait      bool
 )
 
 func main() {
@@ -109,7 +112,7 @@ func main() {
 		cliCommand := cmd.Build()
 
 		if cmd.Name == "" {
-			printFatal("No Name is specified for %s", cmd)
+			printFatal("No Name is specified for %v", cmd)
 		}
 
 		cliCommand.Name = cmd.Name
@@ -175,6 +178,7 @@ func main() {
 
 	setGlobals(app)
 	err := app.Run(os.Args)
+	closeSessionGateways()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -199,15 +203,8 @@ func beforeCommand(c *cli.Context) error {
 		profileName = profiles.LastProfile
 	}
 
-	if profileName != profiles.LastProfile {
-		// if profile is switched, clear the ssh cache
-		err := clearSshKeyCache()
-		if err != nil {
-			return err
-		}
-	}
-
 	debugMode = c.GlobalBool("debug")
+	flagNoWait = c.GlobalBool("no-wait")
 
 	var command string
 	if len(c.Args()) >= 1 {
@@ -236,7 +233,10 @@ func beforeCommand(c *cli.Context) error {
 		printFatal(err.Error())
 	}
 
-	dotYaml, _ = readDotYamlFile(path.Join(dir, ".cx.yml"))
+	dotYaml, err = readDotYamlFile(path.Join(dir, ".cx.yml"))
+	if err != nil && !os.IsNotExist(err) {
+		printWarning("unable to read .cx.yml: %s", err.Error())
+	}
 	return nil
 }
 
@@ -252,6 +252,10 @@ func setGlobals(app *cli.App) {
 			Usage:  "run in debug mode",
 			EnvVar: "CXDEBUG",
 		},
+		cli.BoolFlag{
+			Name:  "no-wait",
+			Usage: "don't wait for async actions to finish. Prints the action id instead, to use with 'cx actions'. Commands which need an action to finish before going on, like run --service, still wait for it",
+		},
 	}
 }
 
@@ -289,7 +293,7 @@ func initClients(c *cli.Context, startAuth bool) {
 		if tokenValue != "" {
 			err = writeClientToken(tokenAbsolutePath, tokenValue)
 			if err != nil {
-				printFatal("an error occurred trying to write environment variable as auth token.", err)
+				printFatal("an error occurred trying to write environment variable as auth token: %s", err.Error())
 			}
 		} else {
 			fmt.Println("No previous authentication found.")
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// deployPolicy holds the local rules cx enforces before running deploy-type commands.
+// It can be defined in a profile (cxprofiles.json) or under the policy key of .cx.yml
+type deployPolicy struct {
+	FreezeWindows []freezeWindow               `json:"freeze_windows,omitempty" yaml:"freeze_windows,omitempty"`
+	Environments  map[string]environmentPolicy `json:"environments,omitempty" yaml:"environments,omitempty"`
+}
+
+// freezeWindow blocks deployments either between two points in time (start and end)
+// or for a duration every time the cron schedule fires
+type freezeWindow struct {
+	Name         string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Environments []string `json:"environments,omitempty" yaml:"environments,omitempty"`
+	Timezone     string   `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Start        string   `json:"start,omitempty" yaml:"start,omitempty"`
+	End          string   `json:"end,omitempty" yaml:"end,omitempty"`
+	Cron         string   `json:"cron,omitempty" yaml:"cron,omitempty"`
+	Duration     string   `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
+
+// environmentPolicy holds the rules for stacks in one environment. The "*" environment applies to
+// all environments without their own rules
+type environmentPolicy struct {
+	DeployStrategies  []string `json:"deploy_strategies,omitempty" yaml:"deploy_strategies,omitempty"`
+	GitRefs           []string `json:"git_refs,omitempty" yaml:"git_refs,omitempty"`
+	RequiredApprovals int      `json:"required_approvals,omitempty" yaml:"required_approvals,omitempty"`
+	Approvers         []string `json:"approvers,omitempty" yaml:"approvers,omitempty"`
+}
+
+// policyAction describes the command being checked against the policies
+type policyAction struct {
+	Command string
+	// Deployment is set for commands which deploy code, where strategies and git refs are checked
+	Deployment bool
+	Strategy   string
+	GitRefs    []string
+}
+
+// the time layout used for start and end of freeze windows
+const freezeWindowTimeLayout = "2006-01-02 15:04"
+
+// the longest cron based freeze window supported
+const maxFreezeWindowDuration = 31 * 24 * time.Hour
+
+// flags shared by the commands guarded by deployment policies and locks
+func deployGuardFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "run even if someone else holds the deployment lock of the stack",
+		},
+		cli.StringFlag{
+			Name:  "override-policy",
+			Usage: "run even if the deployment policy forbids it. The given reason is recorded in the local audit log",
+		},
+		cli.StringSliceFlag{
+			Name:  "approved-by",
+			Usage: "name of a person who approved this change, for policies requiring approvals. Repeatable for multiple approvers",
+			Value: &cli.StringSlice{},
+		},
+	}
+}
+
+// returns the policies from the selected profile and .cx.yml
+func activePolicies() []deployPolicy {
+	var policies []deployPolicy
+	if selectedProfile != nil && selectedProfile.Policy != nil {
+		policies = append(policies, *selectedProfile.Policy)
+	}
+	if dotYaml != nil && dotYaml.Policy != nil {
+		policies = append(policies, *dotYaml.Policy)
+	}
+	return policies
+}
+
+// checks the action against the active policies and stops unless there are no violations,
+// or they have been overridden with --override-policy
+func mustPassPolicy(c *cli.Context, stack cloud66.Stack, action policyAction) {
+	approvedBy := c.StringSlice("approved-by")
+	violations := policyViolations(activePolicies(), stack, action, time.Now(), approvedBy)
+
+	if len(approvedBy) > 0 {
+		if err := auditPolicyEvent("approval", stack, action, approvedBy, nil, ""); err != nil {
+			printFatal("Unable to write to the policy audit log: %s", err.Error())
+		}
+	}
+
+	if len(violations) == 0 {
+		return
+	}
+
+	reason := strings.TrimSpace(c.String("override-policy"))
+	if reason == "" {
+		printFatal("The deployment policy doesn't allow %s on %s (%s):\n - %s\nUse --override-policy \"reason\" to run it anyway", action.Command, stack.Name, stack.Environment, strings.Join(violations, "\n - "))
+	}
+
+	for _, violation := range violations {
+		printWarning("Overriding deployment policy: %s", violation)
+	}
+	if err := auditPolicyEvent("override", stack, action, approvedBy, violations, reason); err != nil {
+		printFatal("Unable to write to the policy audit log: %s", err.Error())
+	}
+}
+
+// returns a description of every rule the action breaks
+func policyViolations(policies []deployPolicy, stack cloud66.Stack, action policyAction, now time.Time, approvedBy []string) []string {
+	var violations []string
+	for _, policy := range policies {
+		for _, window := range policy.FreezeWindows {
+			if !window.appliesTo(stack.Environment) {
+				continue
+			}
+			active, err := window.activeAt(now)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("freeze window %q is invalid: %s", window.Name, err))
+			} else if active {
+				violations = append(violations, fmt.Sprintf("freeze window %q is in effect", window.Name))
+			}
+		}
+
+		rules, ok := policy.rulesFor(stack.Environment)
+		if !ok {
+			continue
+		}
+
+		if action.Deployment && len(rules.DeployStrategies) > 0 {
+			strategy := action.Strategy
+			if strategy == "" {
+				strategy = "default"
+			}
+			if stringsIndex(rules.DeployStrategies, strategy) == -1 {
+				violations = append(violations, fmt.Sprintf("deploy strategy %q is not one of %s", strategy, strings.Join(rules.DeployStrategies, ", ")))
+			}
+		}
+
+		if action.Deployment && len(rules.GitRefs) > 0 {
+			for _, ref := range action.GitRefs {
+				matched, err := matchesAnyPattern(rules.GitRefs, ref)
+				if err != nil {
+					violations = append(violations, err.Error())
+				} else if !matched {
+					violations = append(violations, fmt.Sprintf("git ref %q doesn't match any of %s", ref, strings.Join(rules.GitRefs, ", ")))
+				}
+			}
+		}
+
+		if rules.RequiredApprovals > 0 {
+			approvals := validApprovals(rules.Approvers, approvedBy)
+			if approvals < rules.RequiredApprovals {
+				violations = append(violations, fmt.Sprintf("%d approval(s) required but %d given (use --approved-by)", rules.RequiredApprovals, approvals))
+			}
+		}
+	}
+
+	return violations
+}
+
+func (p deployPolicy) rulesFor(environment string) (environmentPolicy, bool) {
+	for name, rules := range p.Environments {
+		if strings.EqualFold(name, environment) {
+			return rules, true
+		}
+	}
+	rules, ok := p.Environments["*"]
+	return rules, ok
+}
+
+func (w freezeWindow) appliesTo(environment string) bool {
+	if len(w.Environments) == 0 {
+		return true
+	}
+	for _, env := range w.Environments {
+		if env == "*" || strings.EqualFold(env, environment) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks if the freeze window is in effect at the given time
+func (w freezeWindow) activeAt(now time.Time) (bool, error) {
+	location := time.Local
+	if w.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(w.Timezone)
+		if err != nil {
+			return false, err
+		}
+	}
+	now = now.In(location)
+
+	if w.Cron != "" {
+		schedule, err := parseCronSchedule(w.Cron)
+		if err != nil {
+			return false, err
+		}
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil {
+			return false, fmt.Errorf("invalid duration %q", w.Duration)
+		}
+		if duration <= 0 || duration > maxFreezeWindowDuration {
+			return false, fmt.Errorf("duration should be between 1m and %s", maxFreezeWindowDuration)
+		}
+
+		// look for the schedule firing within the duration before now
+		started := now.Truncate(time.Minute)
+		for t := started; now.Sub(t) < duration; t = t.Add(-time.Minute) {
+			if schedule.matches(t) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if w.Start == "" || w.End == "" {
+		return false, errors.New("either cron and duration or start and end are required")
+	}
+	start, err := time.ParseInLocation(freezeWindowTimeLayout, w.Start, location)
+	if err != nil {
+		return false, fmt.Errorf("invalid start %q (expected %s)", w.Start, freezeWindowTimeLayout)
+	}
+	end, err := time.ParseInLocation(freezeWindowTimeLayout, w.End, location)
+	if err != nil {
+		return false, fmt.Errorf("invalid end %q (expected %s)", w.End, freezeWindowTimeLayout)
+	}
+
+	return !now.Before(start) && now.Before(end), nil
+}
+
+func matchesAnyPattern(patterns []string, value string) (bool, error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid git ref pattern %q: %s", pattern, err)
+		}
+		if re.MatchString(value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// counts the distinct approvers which are allowed to approve
+func validApprovals(approvers []string, approvedBy []string) int {
+	seen := make(map[string]bool)
+	for _, name := range approvedBy {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if len(approvers) > 0 {
+			allowed := false
+			for _, approver := range approvers {
+				if strings.EqualFold(approver, name) {
+					allowed = true
+				}
+			}
+			if !allowed {
+				continue
+			}
+		}
+		seen[name] = true
+	}
+	return len(seen)
+}
+
+// policyAuditRecord is a line in the policy audit log
+type policyAuditRecord struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	User        string    `json:"user"`
+	Profile     string    `json:"profile"`
+	Stack       string    `json:"stack"`
+	StackUid    string    `json:"stack_uid"`
+	Environment string    `json:"environment"`
+	Command     string    `json:"command"`
+	ApprovedBy  []string  `json:"approved_by,omitempty"`
+	Violations  []string  `json:"violations,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+func policyAuditLogPath() string {
+	return filepath.Join(cxHome(), "policy-audit.log")
+}
+
+func auditPolicyEvent(event string, stack cloud66.Stack, action policyAction, approvedBy []string, violations []string, reason string) error {
+	record := policyAuditRecord{
+		Time:        time.Now().UTC(),
+		Event:       event,
+		Stack:       stack.Name,
+		StackUid:    stack.Uid,
+		Environment: stack.Environment,
+		Command:     action.Command,
+		ApprovedBy:  approvedBy,
+		Violations:  violations,
+		Reason:      reason,
+	}
+	if selectedProfile != nil {
+		record.Profile = selectedProfile.Name
+	}
+	if usr, err := user.Current(); err == nil {
+		record.User = usr.Username
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(policyAuditLogPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// cronSchedule is a parsed standard 5 field cron expression (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyDay   bool
+	anyWeek  bool
+}
+
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: 5 fields expected", spec)
+	}
+
+	var err error
+	schedule := &cronSchedule{
+		anyDay:  fields[2] == "*",
+		anyWeek: fields[4] == "*",
+	}
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// both 0 and 7 are Sunday
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	return schedule, nil
+}
+
+// parses a single cron field supporting *, lists, ranges and steps (ie. 1-5,10,*/15)
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	result := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			part = part[:idx]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid cron field %q", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid cron field %q", field)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("cron field %q is out of range (%d-%d)", field, min, max)
+		}
+
+		for i := from; i <= to; i += step {
+			result[i] = true
+		}
+	}
+	return result, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+
+	dayMatch := s.days[t.Day()]
+	weekMatch := s.weekdays[int(t.Weekday())]
+	// as with cron, if both day fields are restricted either of them can match
+	switch {
+	case s.anyDay && s.anyWeek:
+		return true
+	case s.anyDay:
+		return weekMatch
+	case s.anyWeek:
+		return dayMatch
+	default:
+		return dayMatch || weekMatch
+	}
+}
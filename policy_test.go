@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment policy", func() {
+	production := cloud66.Stack{Name: "app", Environment: "production", GitBranch: "master"}
+	staging := cloud66.Stack{Name: "app", Environment: "staging", GitBranch: "master"}
+	deploy := policyAction{Command: "redeploy", Deployment: true}
+
+	Context("a weekend freeze window", func() {
+		policy := deployPolicy{
+			FreezeWindows: []freezeWindow{
+				{Name: "weekend", Environments: []string{"production"}, Timezone: "UTC", Cron: "0 17 * * 5", Duration: "64h"},
+			},
+		}
+
+		It("should block production deployments on Saturday", func() {
+			saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			violations := policyViolations([]deployPolicy{policy}, production, deploy, saturday, nil)
+			Expect(violations).To(HaveLen(1))
+			Expect(violations[0]).To(ContainSubstring("weekend"))
+		})
+
+		It("should allow deployments once the window is over", func() {
+			monday := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+			Expect(policyViolations([]deployPolicy{policy}, production, deploy, monday, nil)).To(BeEmpty())
+		})
+
+		It("should not apply to other environments", func() {
+			saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			Expect(policyViolations([]deployPolicy{policy}, staging, deploy, saturday, nil)).To(BeEmpty())
+		})
+	})
+
+	Context("a fixed freeze window", func() {
+		window := freezeWindow{Name: "holidays", Timezone: "UTC", Start: "2026-12-20 00:00", End: "2027-01-04 00:00"}
+
+		It("should be active between start and end", func() {
+			active, err := window.activeAt(time.Date(2026, 12, 25, 10, 0, 0, 0, time.UTC))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(active).To(BeTrue())
+
+			active, err = window.activeAt(time.Date(2027, 1, 4, 0, 0, 0, 0, time.UTC))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(active).To(BeFalse())
+		})
+
+		It("should report invalid windows", func() {
+			_, err := freezeWindow{Start: "tomorrow", End: "2027-01-04 00:00"}.activeAt(time.Now())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("environment rules", func() {
+		policy := deployPolicy{
+			Environments: map[string]environmentPolicy{
+				"production": {
+					DeployStrategies:  []string{"serial"},
+					GitRefs:           []string{`^v[0-9]+\.[0-9]+\.[0-9]+$`},
+					RequiredApprovals: 1,
+					Approvers:         []string{"alice", "bob"},
+				},
+			},
+		}
+		now := time.Now()
+
+		It("should allow a tagged serial deployment approved by an approver", func() {
+			action := policyAction{Command: "redeploy", Deployment: true, Strategy: "serial", GitRefs: []string{"v1.2.3"}}
+			Expect(policyViolations([]deployPolicy{policy}, production, action, now, []string{"Alice"})).To(BeEmpty())
+		})
+
+		It("should report every broken rule", func() {
+			action := policyAction{Command: "redeploy", Deployment: true, GitRefs: []string{"master"}}
+			violations := policyViolations([]deployPolicy{policy}, production, action, now, []string{"mallory"})
+			Expect(violations).To(HaveLen(3))
+		})
+
+		It("should only check approvals for non deployment commands", func() {
+			action := policyAction{Command: "stacks reboot"}
+			Expect(policyViolations([]deployPolicy{policy}, production, action, now, []string{"bob"})).To(BeEmpty())
+		})
+	})
+
+	Context("cron schedules", func() {
+		It("should match ranges, lists and steps", func() {
+			schedule, err := parseCronSchedule("*/15 9-17 * * 1,3,5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schedule.matches(time.Date(2026, 10, 19, 9, 45, 0, 0, time.UTC))).To(BeTrue())
+			Expect(schedule.matches(time.Date(2026, 10, 19, 9, 46, 0, 0, time.UTC))).To(BeFalse())
+			Expect(schedule.matches(time.Date(2026, 10, 20, 9, 45, 0, 0, time.UTC))).To(BeFalse())
+		})
+
+		It("should reject invalid expressions", func() {
+			_, err := parseCronSchedule("61 * * * *")
+			Expect(err).To(HaveOccurred())
+			_, err = parseCronSchedule("* * *")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -42,6 +42,9 @@ func runProcessResume(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endProcessAction(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -89,6 +89,9 @@ func runProcessScale(c *cli.Context) {
 
 	must(err)
 	genericRes, err := endProcessScale(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	must(err)
 	printGenericResponse(*genericRes)
 	return
@@ -101,5 +104,5 @@ func startProcessScale(stackUid string, processName string, serverCount map[stri
 }
 
 func endProcessScale(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
@@ -219,7 +219,7 @@ func startProcessAction(stackUid string, processName *string, serverUid *string,
 }
 
 func endProcessAction(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 10*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 10*time.Minute, true)
 }
 
 type ProcessByNameServer []cloud66.Process
@@ -16,6 +16,17 @@ type Profile struct {
 	Organization string `json:"organization" yaml:"organization"`
 	Name         string `json:"name" yaml:"name"`
 	TokenFile    string `json:"token_file" yaml:"token_file"`
+
+	Policy *deployPolicy `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Hooks  deployHooks   `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+
+	SecretPatterns []string `json:"secret_patterns,omitempty" yaml:"secret_patterns,omitempty"`
+
+	SshKeyMaxAge  string `json:"ssh_key_max_age,omitempty" yaml:"ssh_key_max_age,omitempty"`
+	SshKeyStorage string `json:"ssh_key_storage,omitempty" yaml:"ssh_key_storage,omitempty"`
+
+	// paths of the keys of deploy gateways, by gateway name
+	GatewayKeys map[string]string `json:"gateway_keys,omitempty" yaml:"gateway_keys,omitempty"`
 }
 
 type Profiles struct {
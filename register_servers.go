@@ -117,40 +117,20 @@ func runRegisterServer(c *cli.Context) {
 }
 
 func registerServer(server string, script string, params url.Values, keyFile string, user string, useLocalIP bool) error {
+	conn, err := dialSSH(sshTarget{User: user, Host: server, KeyFile: keyFile})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Run(registrationCommand(script, params, useLocalIP), true)
+}
+
+// the command running the registration script of the account on a server
+func registrationCommand(script string, params url.Values, useLocalIP bool) string {
 	extraHeader := ""
 	if useLocalIP {
 		extraHeader = "--header \"X-Force-Local-IP:true\""
 	}
 
-	toRun := fmt.Sprintf("'curl %s -s %s?%s| bash -s'", extraHeader, script, params.Encode())
-	if keyFile == "" {
-		return startProgram("ssh", []string{
-			user + "@" + server,
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "CheckHostIP=no",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "LogLevel=QUIET",
-			"-o", "IdentitiesOnly=yes",
-			"-A",
-			"-t",
-			"-p", "22",
-			"sudo su - -c",
-			toRun,
-		})
-	} else {
-		return startProgram("ssh", []string{
-			user + "@" + server,
-			"-i", keyFile,
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "CheckHostIP=no",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "LogLevel=QUIET",
-			"-o", "IdentitiesOnly=yes",
-			"-A",
-			"-t",
-			"-p", "22",
-			"sudo su - -c",
-			toRun,
-		})
-	}
+	return fmt.Sprintf("sudo su - -c 'curl %s -s %s?%s| bash -s'", extraHeader, script, params.Encode())
 }
@@ -4,67 +4,64 @@ import (
 	"flag"
 
 	"github.com/cloud66/cli"
+	"github.com/h2non/gock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Register a new server", func() {
-	Context("with a local (private) IP adresss", func() {
-		var flagSet *flag.FlagSet
+	var server *testSSHServer
+	var restoreHome func()
+	var restoreClient func()
+	var flagSet *flag.FlagSet
 
-		BeforeEach(func() {
-			underTest = true
-			//capture output and mock API endpoint
-			StartCaptureStdout()
-			MockApiGetCall("/accounts/139.json", 200, "./mocks/accounts/account.json")
-			MockApiGetCall("/accounts.json", 200, "./mocks/accounts/list.json")
-			flagSet = flag.NewFlagSet("test", 0)
-		})
+	BeforeEach(func() {
+		gock.Off()
+		restoreClient = MockApiClient()
+		restoreHome = useTempHome()
+		server = startTestSSHServer()
+
+		MockApiGetCall("/accounts/139.json", 200, "./mocks/accounts/account.json")
+		MockApiGetCall("/accounts.json", 200, "./mocks/accounts/list.json")
+		flagSet = flag.NewFlagSet("test", 0)
+		flagSet.String("org", "test_org", "")
+		flagSet.String("server", server.Address(), "")
+		flagSet.String("key", server.KeyFile, "")
+		flagSet.String("user", "root", "")
+	})
 
+	AfterEach(func() {
+		server.Close()
+		restoreHome()
+		gock.Off()
+		restoreClient()
+	})
+
+	Context("with a local (private) IP adresss", func() {
 		It("should use the special header", func() {
-			// set the enviroment flag to production
-			flagSet.String("org", "test_org", "")
-			flagSet.String("server", "192.168.1.10", "")
-			flagSet.String("user", "root", "")
 			flagSet.String("force-local-ip", "true", "")
 
 			// run context with the CLI
 			context := cli.NewContext(nil, flagSet, nil)
+			StartCaptureStdout()
 			runRegisterServer(context)
-
-			// read stdout
 			output := StopCaptureStdout()
-			Expect(lastCommandExecuted.Args[17]).To(HavePrefix("'curl --header \"X-Force-Local-IP:true\" -s http://app.cloud66.com/xxx?| bash -s'"))
-			Expect(output[0]).To(HavePrefix("Register server(s) done."))
+
+			Expect(server.Commands()).To(Equal([]string{"sudo su - -c 'curl --header \"X-Force-Local-IP:true\" -s http://app.cloud66.com/xxx?| bash -s'"}))
+			Expect(output).To(ContainElement(HavePrefix("Register server(s) done.")))
 		})
 
 	})
 	Context("using the public IP address", func() {
-		var flagSet *flag.FlagSet
-
-		BeforeEach(func() {
-			underTest = true
-			//capture output and mock API endpoint
-			StartCaptureStdout()
-			MockApiGetCall("/accounts/139.json", 200, "./mocks/accounts/account.json")
-			MockApiGetCall("/accounts.json", 200, "./mocks/accounts/list.json")
-			flagSet = flag.NewFlagSet("test", 0)
-		})
-
-		It("should use the special header", func() {
-			// set the enviroment flag to production
-			flagSet.String("org", "test_org", "")
-			flagSet.String("server", "192.168.1.10", "")
-			flagSet.String("user", "root", "")
-
+		It("should not use the special header", func() {
 			// run context with the CLI
 			context := cli.NewContext(nil, flagSet, nil)
+			StartCaptureStdout()
 			runRegisterServer(context)
-
-			// read stdout
 			output := StopCaptureStdout()
-			Expect(lastCommandExecuted.Args[17]).To(HavePrefix("'curl  -s http://app.cloud66.com/xxx?| bash -s'"))
-			Expect(output[0]).To(HavePrefix("Register server(s) done."))
+
+			Expect(server.Commands()).To(Equal([]string{"sudo su - -c 'curl  -s http://app.cloud66.com/xxx?| bash -s'"}))
+			Expect(output).To(ContainElement(HavePrefix("Register server(s) done.")))
 		})
 
 	})
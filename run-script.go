@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// interpreters reading a script from stdin with -s. Others read it with -, like python, ruby, perl and node
+var stdinShells = []string{"sh", "bash", "dash", "zsh", "ksh", "ash"}
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// remoteScript is a local script run on a server, service or container by streaming it over stdin,
+// so nothing is left behind on the remote side
+type remoteScript struct {
+	Content []byte
+	Args    []string
+	Env     []string
+}
+
+// reads the script, checking the KEY=VALUE environment variables to run it with
+func readRemoteScript(file string, args []string, env []string) (*remoteScript, error) {
+	if strings.HasPrefix(file, "~/") {
+		file = expandPath(file)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range env {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !envVarName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid --env %q. Use KEY=VALUE", value)
+		}
+	}
+	return &remoteScript{Content: content, Args: args, Env: env}, nil
+}
+
+// the interpreter of the script, from its #! line. Defaults to bash
+func (s remoteScript) interpreter() string {
+	if !bytes.HasPrefix(s.Content, []byte("#!")) {
+		return "/bin/bash"
+	}
+	line := string(s.Content[2:])
+	if idx := strings.IndexByte(line, '\n'); idx != -1 {
+		line = line[:idx]
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return "/bin/bash"
+	}
+	return line
+}
+
+// the name of the program of a #! line, like bash for "/bin/bash -e" or "/usr/bin/env -S bash -e"
+func interpreterName(interpreter string) string {
+	fields := strings.Fields(interpreter)
+	name := path.Base(fields[0])
+	if name != "env" {
+		return name
+	}
+	// the program run by env comes after its options and variables
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+			return path.Base(field)
+		}
+	}
+	return name
+}
+
+// the command running the script read from stdin, with its arguments and environment variables
+func (s remoteScript) command() string {
+	var parts []string
+	if len(s.Env) > 0 {
+		parts = append(parts, "env", shellquote.Join(s.Env...))
+	}
+	interpreter := s.interpreter()
+	parts = append(parts, interpreter)
+	if stringsIndex(stdinShells, interpreterName(interpreter)) != -1 {
+		parts = append(parts, "-s", "--")
+	} else {
+		parts = append(parts, "-")
+	}
+	if len(s.Args) > 0 {
+		parts = append(parts, shellquote.Join(s.Args...))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s remoteScript) reader() io.Reader {
+	return bytes.NewReader(s.Content)
+}
+
+// the stdin of a command: the script if there is one, or the terminal
+func scriptInput(script *remoteScript) io.Reader {
+	if script == nil {
+		return nil
+	}
+	return script.reader()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Remote scripts", func() {
+	It("should run shell scripts from stdin with quoted arguments and environment", func() {
+		script := remoteScript{Content: []byte("echo $1\n"), Args: []string{"--verbose", "two words"}, Env: []string{"DRY_RUN=1", "NAME=it's"}}
+		Expect(script.command()).To(Equal(`env DRY_RUN=1 NAME=it\'s /bin/bash -s -- --verbose 'two words'`))
+	})
+
+	It("should use the interpreter of the script", func() {
+		script := remoteScript{Content: []byte("#!/usr/bin/env python3\nprint('hi')\n")}
+		Expect(script.command()).To(Equal("/usr/bin/env python3 -"))
+
+		script = remoteScript{Content: []byte("#!/bin/sh\necho hi\n"), Args: []string{"a"}}
+		Expect(script.command()).To(Equal("/bin/sh -s -- a"))
+	})
+
+	It("should pick the way to read the script by the interpreter, not its options", func() {
+		script := remoteScript{Content: []byte("#!/bin/bash -e\necho hi\n"), Args: []string{"--verbose"}}
+		Expect(script.command()).To(Equal("/bin/bash -e -s -- --verbose"))
+
+		script = remoteScript{Content: []byte("#!/usr/bin/env -S bash -eu\necho hi\n")}
+		Expect(script.command()).To(Equal("/usr/bin/env -S bash -eu -s --"))
+
+		script = remoteScript{Content: []byte("#!/usr/bin/ruby -w\nputs 'hi'\n")}
+		Expect(script.command()).To(Equal("/usr/bin/ruby -w -"))
+	})
+})
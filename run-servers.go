@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"golang.org/x/crypto/ssh"
+)
+
+// runOptions are the options of running a command on many servers
+type runOptions struct {
+	MaxParallel int
+	FailFast    bool
+	// runs on one server at a time, stopping at the first failure
+	Rolling bool
+	// command run on each server after the command during a rolling run, until it succeeds or times out
+	HealthCheck        string
+	HealthCheckTimeout time.Duration
+	// prints the output of each server in one block once it is done, instead of prefixing each line
+	Group bool
+	// streamed to the stdin of the command on each server, like a --script
+	Stdin []byte
+}
+
+// serverRunResult is the outcome of running a command on a server
+type serverRunResult struct {
+	Server   cloud66.Server
+	ExitCode int
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+}
+
+func (r serverRunResult) failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+func (r serverRunResult) status() string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Err != nil:
+		return "error: " + r.Err.Error()
+	case r.ExitCode != 0:
+		return "failed"
+	}
+	return "ok"
+}
+
+// connects to a server to run a command on it
+type serverDialer func(server cloud66.Server) (*sshConnection, error)
+
+func dialServerForRun(server cloud66.Server) (*sshConnection, error) {
+	target, err := openSSHAccess(server)
+	if err != nil {
+		return nil, err
+	}
+	return dialSSH(target)
+}
+
+func runRunOnServers(c *cli.Context, stack *cloud66.Stack, servers []cloud66.Server, userCommand string, script *remoteScript) {
+	if c.Bool("interactive") {
+		printFatal("--interactive can only be used with a single server")
+	}
+	if c.String("service") != "" || c.String("container") != "" {
+		printFatal("--servers and --all can't be used with a service or container")
+	}
+	if userCommand == "" {
+		printFatal("A command is required to run on many servers")
+	}
+
+	var targets []cloud66.Server
+	if c.Bool("all") {
+		targets = servers
+	} else {
+		var err error
+		targets, err = findServers(servers, splitCommaValues([]string{c.String("servers")}))
+		must(err)
+	}
+	if len(targets) == 0 {
+		printFatal("No servers found in %s", stack.Name)
+	}
+
+	options := runOptions{
+		MaxParallel:        c.Int("max-parallel"),
+		FailFast:           c.Bool("fail-fast"),
+		Rolling:            c.Bool("rolling"),
+		HealthCheck:        c.String("health-check"),
+		HealthCheckTimeout: c.Duration("health-check-timeout"),
+		Group:              c.Bool("group"),
+	}
+	if script != nil {
+		options.Stdin = script.Content
+	}
+	if options.HealthCheck != "" && !options.Rolling {
+		printFatal("--health-check can only be used with --rolling")
+	}
+	if options.HealthCheck != "" {
+		options.HealthCheck = serverCommand(options.HealthCheck)
+	}
+
+	results := runOnServers(targets, serverCommand(strings.TrimSpace(userCommand)), options, dialServerForRun, os.Stdout, os.Stderr)
+	printRunSummary(os.Stdout, results)
+	for _, result := range results {
+		if result.failed() || result.Skipped {
+			closeSessionGateways()
+			os.Exit(1)
+		}
+	}
+}
+
+// returns the servers matching any of the names, roles or addresses, in the order of the stack
+func findServers(servers []cloud66.Server, names []string) ([]cloud66.Server, error) {
+	selected := make([]bool, len(servers))
+	for _, name := range names {
+		found := false
+		for idx, server := range servers {
+			if server.Address == name || strings.EqualFold(server.Name, name) {
+				selected[idx] = true
+				found = true
+			}
+			for _, role := range server.Roles {
+				if strings.EqualFold(role, name) {
+					selected[idx] = true
+					found = true
+				}
+			}
+		}
+		if !found {
+			// fall back to the partial names of findServer
+			server, err := findServer(servers, name)
+			if err != nil {
+				return nil, err
+			}
+			if server == nil {
+				return nil, fmt.Errorf("Server %s not found", name)
+			}
+			for idx := range servers {
+				if servers[idx].Uid == server.Uid {
+					selected[idx] = true
+				}
+			}
+		}
+	}
+
+	var result []cloud66.Server
+	for idx, server := range servers {
+		if selected[idx] {
+			result = append(result, server)
+		}
+	}
+	return result, nil
+}
+
+// runs the command on the servers, at most options.MaxParallel at a time, and returns the result of each server
+func runOnServers(servers []cloud66.Server, command string, options runOptions, dial serverDialer, stdout io.Writer, stderr io.Writer) []serverRunResult {
+	parallel := options.MaxParallel
+	if options.Rolling || parallel < 1 {
+		parallel = 1
+	}
+	if parallel > len(servers) {
+		parallel = len(servers)
+	}
+	stopOnFailure := options.FailFast || options.Rolling
+
+	results := make([]serverRunResult, len(servers))
+	for idx, server := range servers {
+		results[idx] = serverRunResult{Server: server, Skipped: true}
+	}
+
+	var outputMutex sync.Mutex
+	var stateMutex sync.Mutex
+	stopped := false
+	queue := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for idx := range queue {
+				// the server could have been queued before another one failed
+				stateMutex.Lock()
+				stop := stopped
+				stateMutex.Unlock()
+				if stop {
+					continue
+				}
+				result := runOnServer(servers[idx], command, options, dial, &outputMutex, stdout, stderr)
+				stateMutex.Lock()
+				results[idx] = result
+				if result.failed() && stopOnFailure {
+					stopped = true
+				}
+				stateMutex.Unlock()
+			}
+		}()
+	}
+
+	for idx := range servers {
+		stateMutex.Lock()
+		stop := stopped
+		stateMutex.Unlock()
+		if stop {
+			break
+		}
+		queue <- idx
+	}
+	close(queue)
+	workers.Wait()
+	return results
+}
+
+func runOnServer(server cloud66.Server, command string, options runOptions, dial serverDialer, outputMutex *sync.Mutex, stdout io.Writer, stderr io.Writer) serverRunResult {
+	started := time.Now()
+	result := serverRunResult{Server: server}
+
+	var out, errOut io.Writer
+	var grouped bytes.Buffer
+	var prefixedOut, prefixedErr *prefixWriter
+	if options.Group {
+		// stdout and stderr are written by separate goroutines of the session
+		groupedOut := &lockedWriter{out: &grouped}
+		out, errOut = groupedOut, groupedOut
+	} else {
+		prefixedOut = &prefixWriter{prefix: "[" + server.Name + "] ", out: stdout, mutex: outputMutex}
+		prefixedErr = &prefixWriter{prefix: "[" + server.Name + "] ", out: stderr, mutex: outputMutex}
+		out, errOut = prefixedOut, prefixedErr
+	}
+
+	conn, err := dial(server)
+	if err == nil {
+		var stdin io.Reader
+		if options.Stdin != nil {
+			stdin = bytes.NewReader(options.Stdin)
+		}
+		result.ExitCode, result.Err = runWithExitCode(conn, command, stdin, out, errOut)
+		if !result.failed() && options.HealthCheck != "" {
+			result.ExitCode, result.Err = waitForHealthCheck(conn, options.HealthCheck, options.HealthCheckTimeout, out)
+		}
+		conn.Close()
+	} else {
+		result.Err = err
+	}
+	result.Duration = time.Since(started)
+
+	if options.Group {
+		outputMutex.Lock()
+		fmt.Fprintf(stdout, "=== %s (%s) ===\n", server.Name, result.status())
+		stdout.Write(grouped.Bytes())
+		outputMutex.Unlock()
+	} else {
+		prefixedOut.Flush()
+		prefixedErr.Flush()
+	}
+	return result
+}
+
+// returns the exit code of the command, or an error if it could not be run
+func runWithExitCode(conn *sshConnection, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	err := conn.RunWith(command, false, stdin, stdout, stderr)
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// runs the health check until it succeeds or the timeout passes
+func waitForHealthCheck(conn *sshConnection, healthCheck string, timeout time.Duration, out io.Writer) (int, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		code, err := runWithExitCode(conn, healthCheck, nil, out, out)
+		if err != nil || code == 0 || time.Now().After(deadline) {
+			if err == nil && code != 0 {
+				err = fmt.Errorf("health check failed with exit code %d", code)
+			}
+			return code, err
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func printRunSummary(out io.Writer, results []serverRunResult) {
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "SERVER", "ADDRESS", "EXIT CODE", "DURATION", "RESULT")
+	for _, result := range results {
+		exitCode, duration := "-", "-"
+		if !result.Skipped && result.Err == nil {
+			exitCode = fmt.Sprint(result.ExitCode)
+		}
+		if !result.Skipped {
+			duration = result.Duration.Round(time.Millisecond).String()
+		}
+		listRec(w, result.Server.Name, result.Server.Address, exitCode, duration, result.status())
+	}
+}
+
+// prefixWriter writes whole lines with a prefix, so the output of servers running in parallel doesn't mix
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	// shared by the writers of all servers
+	mutex *sync.Mutex
+	// guards the buffer, as the writer can be used for both stdout and stderr
+	bufferMutex sync.Mutex
+	buffer      bytes.Buffer
+}
+
+func (w *prefixWriter) Write(data []byte) (int, error) {
+	w.bufferMutex.Lock()
+	defer w.bufferMutex.Unlock()
+	w.buffer.Write(data)
+	for {
+		line, err := w.buffer.ReadBytes('\n')
+		if err != nil {
+			// keep the partial line for the next write
+			rest := append([]byte{}, line...)
+			w.buffer.Reset()
+			w.buffer.Write(rest)
+			return len(data), nil
+		}
+		w.mutex.Lock()
+		fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+		w.mutex.Unlock()
+	}
+}
+
+// writes the last line if it has no newline
+func (w *prefixWriter) Flush() {
+	w.bufferMutex.Lock()
+	defer w.bufferMutex.Unlock()
+	if w.buffer.Len() == 0 {
+		return
+	}
+	w.mutex.Lock()
+	fmt.Fprintf(w.out, "%s%s\n", w.prefix, w.buffer.String())
+	w.mutex.Unlock()
+	w.buffer.Reset()
+}
+
+// lockedWriter serializes the writes of several goroutines to a writer
+type lockedWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.out.Write(data)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run on many servers", func() {
+	var restoreHome func()
+	var sshServers map[string]*testSSHServer
+	var servers []cloud66.Server
+	var dial serverDialer
+
+	BeforeEach(func() {
+		restoreHome = useTempHome()
+		sshServers = map[string]*testSSHServer{}
+		servers = []cloud66.Server{
+			{Uid: "1", Name: "lion", Roles: []string{"web"}},
+			{Uid: "2", Name: "tiger", Roles: []string{"web"}},
+			{Uid: "3", Name: "bear", Roles: []string{"worker"}},
+		}
+		for idx := range servers {
+			sshServer := startTestSSHServer()
+			sshServers[servers[idx].Name] = sshServer
+			servers[idx].Address = sshServer.Address()
+		}
+		dial = func(server cloud66.Server) (*sshConnection, error) {
+			sshServer := sshServers[server.Name]
+			return dialSSH(sshTarget{Uid: server.Uid, User: "cloud66-user", Host: sshServer.Address(), KeyFile: sshServer.KeyFile})
+		}
+	})
+
+	AfterEach(func() {
+		for _, sshServer := range sshServers {
+			sshServer.Close()
+		}
+		restoreHome()
+	})
+
+	It("should find all servers with a role", func() {
+		found, err := findServers(servers, []string{"web"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(HaveLen(2))
+
+		found, err = findServers(servers, []string{"BEAR", "lion"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect([]string{found[0].Name, found[1].Name}).To(Equal([]string{"lion", "bear"}))
+
+		_, err = findServers(servers, []string{"db"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should prefix the output with server names and return exit codes", func() {
+		var stdout bytes.Buffer
+		StartCaptureStdout()
+		results := runOnServers(servers, "uptime", runOptions{MaxParallel: 2}, dial, &stdout, &stdout)
+		StopCaptureStdout()
+
+		Expect(stdout.String()).To(ContainSubstring("[lion] ran uptime\n"))
+		Expect(stdout.String()).To(ContainSubstring("[bear] ran uptime\n"))
+		for _, result := range results {
+			Expect(result.status()).To(Equal("ok"))
+		}
+	})
+
+	It("should stop a rolling run at the first failure", func() {
+		var stdout bytes.Buffer
+		StartCaptureStdout()
+		results := runOnServers(servers, "exit 2", runOptions{Rolling: true, HealthCheckTimeout: time.Second}, dial, &stdout, &stdout)
+		StopCaptureStdout()
+
+		Expect(results[0].ExitCode).To(Equal(2))
+		Expect(results[0].status()).To(Equal("failed"))
+		Expect(results[1].Skipped).To(BeTrue())
+		Expect(results[2].Skipped).To(BeTrue())
+		Expect(sshServers["tiger"].Commands()).To(BeEmpty())
+	})
+
+	It("should run the health check after each server", func() {
+		var stdout bytes.Buffer
+		StartCaptureStdout()
+		results := runOnServers(servers[:2], "reload", runOptions{Rolling: true, HealthCheck: "check", HealthCheckTimeout: time.Second, Group: true}, dial, &stdout, &stdout)
+		StopCaptureStdout()
+
+		Expect(results[1].status()).To(Equal("ok"))
+		Expect(sshServers["tiger"].Commands()).To(Equal([]string{"reload", "check"}))
+		Expect(stdout.String()).To(ContainSubstring("=== lion (ok) ===\nran reload\nran check\n"))
+	})
+})
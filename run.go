@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
@@ -40,6 +41,50 @@ var cmdRun = &Command{
 			Name:  "shell",
 			Usage: "(deprecated)",
 		},
+		gatewayKeyFlag(),
+		cli.StringFlag{
+			Name:  "servers",
+			Usage: "comma separated server names, roles or addresses to run the command on. All servers with a role are used",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "run the command on all servers of the stack",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "maximum number of servers to run the command on at the same time, with --servers or --all",
+			Value: 5,
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "don't start the command on more servers once it fails on one",
+		},
+		cli.BoolFlag{
+			Name:  "rolling",
+			Usage: "run the command on one server at a time, stopping at the first failure",
+		},
+		cli.StringFlag{
+			Name:  "health-check",
+			Usage: "command to run on each server after the command with --rolling. The next server is only started once it succeeds",
+		},
+		cli.DurationFlag{
+			Name:  "health-check-timeout",
+			Usage: "how long to retry the health check for before failing",
+			Value: time.Minute,
+		},
+		cli.StringFlag{
+			Name:  "script",
+			Usage: "local script to run. It is streamed over stdin, with the arguments of the command as its arguments",
+		},
+		cli.StringSliceFlag{
+			Name:  "env",
+			Usage: "environment variable for the script, as KEY=VALUE. Repeatable",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "group",
+			Usage: "print the output of each server in one block when it is done, instead of prefixing each line with the server name",
+		},
 	},
 	Run:        runRun,
 	NeedsStack: true,
@@ -61,6 +106,20 @@ with this command.
 If a role is specified the command will connect to the first server with that role.
 Names are case insensitive and will work with the starting characters as well.
 
+To run a command on many servers, use --servers with server names, roles or addresses, or --all. All servers with a
+given role are used. The command runs on up to --max-parallel servers at a time, and the output of each line is
+prefixed with the server name (or grouped per server with --group). A summary with the exit code of each server is
+printed at the end, and cx exits with 1 if the command failed on any server. With --fail-fast, the command isn't
+started on more servers once it fails on one. With --rolling, it runs on one server at a time and stops at the first
+failure, running the --health-check command on each server before moving to the next.
+
+To run a local script, use --script. The script is streamed to the server, service or container over stdin, so no
+file is left behind, and run with the interpreter of its #! line (bash if it has none). The arguments of the command
+are passed to the script, quoted as given, and --env sets environment variables for it.
+
+Servers behind a deploy gateway (bastion server) are reached through it. Use --gateway-key unless the key of the
+gateway is set in the profile.
+
 This command is only supported on Linux and OS X (for Windows you can run this in a virtual machine if necessary)
 
 Examples:
@@ -78,6 +137,21 @@ $ cx run -s mystack --service api --interactive 'bundle exec rails c'
 
 $ cx run -s mystack --container web-123 -i 'bundle exec rails c'
 (runs "bundle exec rails c" INSIDE THE SPECIFIED CONTAINER, and remains in the session)
+
+$ cx run -s mystack --server lion --script ./fix.sh --env DRY_RUN=1 -- --verbose 'two words'
+(runs the local script fix.sh ON THE SERVER with the arguments --verbose and "two words")
+
+$ cx run -s mystack --service api --script ./migrate.rb
+(runs the local ruby script migrate.rb IN A NEW CONTAINER OF THE SERVICE)
+
+$ cx run -s mystack --servers web,worker 'uptime'
+(runs "uptime" ON ALL WEB AND WORKER SERVERS, 5 at a time, and prints a summary)
+
+$ cx run -s mystack --all --max-parallel 10 --fail-fast 'df -h /'
+(runs "df -h /" ON ALL SERVERS, 10 at a time, and stops starting new ones after a failure)
+
+$ cx run -s mystack --servers web --rolling --health-check 'curl -fs localhost/health' 'sudo service nginx reload'
+(runs the command ON ONE WEB SERVER AT A TIME, waiting for the health check to pass before the next one)
 `,
 }
 
@@ -89,12 +163,14 @@ func runRun(c *cli.Context) {
 		printFatal("Not supported on Windows")
 		os.Exit(2)
 	}
+	flagGatewayKey = c.String("gateway-key")
 	serviceName := c.String("service")
 	containerName := c.String("container")
 	serverName := c.String("server")
 	interactive := c.Bool("interactive")
-	if serverName == "" && containerName == "" && serviceName == "" {
-		printFatal("At least ONE of server/service/container must be specified")
+	manyServers := c.String("servers") != "" || c.Bool("all")
+	if serverName == "" && containerName == "" && serviceName == "" && !manyServers {
+		printFatal("At least ONE of server/servers/service/container must be specified")
 		os.Exit(2)
 	}
 
@@ -105,6 +181,23 @@ func runRun(c *cli.Context) {
 		}
 	}
 
+	var script *remoteScript
+	if c.String("script") != "" {
+		if interactive {
+			printFatal("--script can't be used with --interactive")
+		}
+		args := c.Args()
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		var err error
+		script, err = readRemoteScript(c.String("script"), args, c.StringSlice("env"))
+		must(err)
+		userCommand = script.command()
+	} else if len(c.StringSlice("env")) > 0 {
+		printFatal("--env can only be used with --script")
+	}
+
 	stack := mustStack(c)
 	if (serviceName != "" || containerName != "") && stack.Backend != "docker" && stack.Backend != "kubernetes" {
 		printFatal("The service & container options only apply to docker/kubernetes stacks")
@@ -120,6 +213,14 @@ func runRun(c *cli.Context) {
 		printFatal(err.Error())
 	}
 
+	if manyServers {
+		if serverName != "" {
+			printFatal("Only one of options server OR servers/all may be specified")
+		}
+		runRunOnServers(c, stack, servers, userCommand, script)
+		return
+	}
+
 	var server *cloud66.Server
 	if serverName != "" {
 		server, err = findServer(servers, serverName)
@@ -136,7 +237,7 @@ func runRun(c *cli.Context) {
 		if userCommand == "" && !interactive {
 			printFatal("A command is required if you're not running an interactive session")
 		}
-		err = runServerCommand(*server, userCommand, interactive)
+		err = runServerCommand(*server, userCommand, interactive, scriptInput(script))
 		must(err)
 		return
 	}
@@ -159,7 +260,8 @@ func runRun(c *cli.Context) {
 			// we need a session
 			asyncResult, err := client.StartRemoteSession(stack.Uid, serviceName)
 			must(err)
-			genericRes, err := client.WaitStackAsyncAction(asyncResult.Id, stack.Uid, 5*time.Second, 4*time.Minute, false)
+			// the command runs in the session, so it has to be started even with --no-wait
+			genericRes, err := waitForStackAsyncAction(asyncResult.Id, stack.Uid, 5*time.Second, 4*time.Minute, false)
 			must(err)
 			if genericRes.Status != true {
 				printFatal("Unable to start session")
@@ -168,11 +270,11 @@ func runRun(c *cli.Context) {
 			session, err := client.FetchRemoteSession(stack.Uid, nil, &serviceName)
 			must(err)
 			// now we have pods
-			err = runKubesCommand(*server, stack.Namespace(), session.PodName, userCommand, interactive)
+			err = runKubesCommand(*server, stack.Namespace(), session.PodName, userCommand, interactive, scriptInput(script))
 			must(err)
 		} else if containerName != "" {
 			// we have the pod name
-			err = runKubesCommand(*server, stack.Namespace(), containerName, userCommand, interactive)
+			err = runKubesCommand(*server, stack.Namespace(), containerName, userCommand, interactive, scriptInput(script))
 			must(err)
 		}
 	} else if stack.Backend == "docker" {
@@ -189,7 +291,10 @@ func runRun(c *cli.Context) {
 			service, err := client.GetService(stack.Uid, serviceName, &server.Uid, &userCommand)
 			must(err)
 			userCommand = service.WrapCommand
-			if !interactive {
+			if script != nil {
+				// the script is read from stdin, but needs no terminal
+				userCommand = strings.Replace(userCommand, "-it", "-i", 1)
+			} else if !interactive {
 				// we always get interactive back
 				userCommand = strings.Replace(userCommand, "-it", "", 1)
 			}
@@ -203,11 +308,13 @@ func runRun(c *cli.Context) {
 			}
 			if interactive {
 				userCommand = fmt.Sprintf("sudo docker exec -it %s %s", container.Uid, userCommand)
+			} else if script != nil {
+				userCommand = fmt.Sprintf("sudo docker exec -i %s %s", container.Uid, userCommand)
 			} else {
 				userCommand = fmt.Sprintf("sudo docker exec %s %s", container.Uid, userCommand)
 			}
 		}
-		err = runServerCommand(*server, userCommand, interactive)
+		err = runServerCommand(*server, userCommand, interactive, scriptInput(script))
 		must(err)
 
 	} else {
@@ -215,23 +322,26 @@ func runRun(c *cli.Context) {
 	}
 }
 
-func runServerCommand(server cloud66.Server, userCommand string, interactive bool) error {
-	// open lease, get address, get sshkey
-	sshFile, address := prepareForSSH(server)
+// runs the command on the server. The stdin of the command is the terminal, unless another one is given
+func runServerCommand(server cloud66.Server, userCommand string, interactive bool, stdin io.Reader) error {
+	// open lease, get sshkey
+	target := prepareForSSH(server)
+
+	// run the ssh
+	return runSSH(target, serverCommand(userCommand), interactive, stdin)
+}
 
-	// default user command if it isn't specified
+// wraps the command to run with the environment of the stack, defaulting to a shell
+func serverCommand(userCommand string) string {
 	if userCommand == "" {
 		userCommand = ShellCommand
 	}
-	userCommand = fmt.Sprintf("source /var/.cloud66_env &>/dev/null ; %s", userCommand)
-
-	// run the ssh
-	return runSSH(address, sshFile, userCommand, interactive)
+	return fmt.Sprintf("source /var/.cloud66_env &>/dev/null ; %s", userCommand)
 }
 
-func runKubesCommand(server cloud66.Server, namespace string, podName string, userCommand string, interactive bool) error {
-	// open lease, get address, get sshkey
-	sshFile, address := prepareForSSH(server)
+func runKubesCommand(server cloud66.Server, namespace string, podName string, userCommand string, interactive bool, stdin io.Reader) error {
+	// open lease, get sshkey
+	target := prepareForSSH(server)
 	// default the command if not supplied
 	if userCommand == "" {
 		userCommand = ShellCommand
@@ -239,54 +349,47 @@ func runKubesCommand(server cloud66.Server, namespace string, podName string, us
 	if interactive {
 		// override with shell for interactive
 		userCommand = fmt.Sprintf("kubectl --namespace %s exec -it %s -- %s", namespace, podName, userCommand)
+	} else if stdin != nil {
+		userCommand = fmt.Sprintf("kubectl --namespace %s exec -i %s -- %s", namespace, podName, userCommand)
 	} else {
 		userCommand = fmt.Sprintf("kubectl --namespace %s exec %s -- %s", namespace, podName, userCommand)
 	}
 	// run the ssh
-	return runSSH(address, sshFile, userCommand, interactive)
+	return runSSH(target, userCommand, interactive, stdin)
 }
 
-func prepareForSSH(server cloud66.Server) (string, string) {
-	address := fmt.Sprintf("%s@%s", server.UserName, server.Address)
-
-	sshFile, err := prepareLocalSshKey(server)
+func prepareForSSH(server cloud66.Server) sshTarget {
+	target, err := openSSHAccess(server)
 	must(err)
+	return target
+}
+
+// fetches the SSH key of the server and opens the firewall for SSH from this machine
+func openSSHAccess(server cloud66.Server) (sshTarget, error) {
+	sshKey, err := prepareLocalSshKey(server)
+	if err != nil {
+		return sshTarget{}, err
+	}
 	// open the firewall
 	var timeToOpen = 2
 	genericRes, err := client.LeaseSync(server.StackUid, nil, &timeToOpen, nil, &server.Uid)
-	must(err)
+	if err != nil {
+		return sshTarget{}, err
+	}
 	if genericRes.Status != true {
-		printFatal("Unable to open server lease")
+		return sshTarget{}, fmt.Errorf("Unable to open server lease")
 	}
-	return sshFile, address
+	return serverSSHTarget(server, sshKey)
 }
 
-func runSSH(address, sshFile, userCommand string, interactive bool) error {
-	if interactive {
-		return startProgram("ssh", []string{
-			address,
-			"-i", sshFile,
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "CheckHostIP=no",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "LogLevel=QUIET",
-			"-o", "IdentitiesOnly=yes",
-			"-A",
-			"-p", "22",
-			"-t",
-			userCommand,
-		})
+func runSSH(target sshTarget, userCommand string, interactive bool, stdin io.Reader) error {
+	conn, err := dialSSH(target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if stdin != nil {
+		return conn.RunWith(userCommand, false, stdin, os.Stdout, os.Stderr)
 	}
-	return startProgram("ssh", []string{
-		address,
-		"-i", sshFile,
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "CheckHostIP=no",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "LogLevel=QUIET",
-		"-o", "IdentitiesOnly=yes",
-		"-A",
-		"-p", "22",
-		userCommand,
-	})
+	return conn.Run(userCommand, interactive)
 }
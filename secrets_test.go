@@ -0,0 +1,27 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secret masking", func() {
+	It("should mask secrets consistently", func() {
+		Expect(maskKeyValue("DB_PASSWORD", "hunter2")).To(Equal(maskSecret("hunter2")))
+		Expect(maskKeyValue("DB_PASSWORD", "hunter2")).NotTo(ContainSubstring("hunter2"))
+		Expect(maskKeyValue("DATABASE_URL", "postgres://app:hunter2@db/app")).NotTo(ContainSubstring("hunter2"))
+		Expect(maskSecretsInText("password: hunter2\nport: 5432")).To(HaveSuffix("\nport: 5432"))
+		Expect(maskKeyValue("RAILS_ENV", "production")).To(Equal("production"))
+	})
+
+	It("should only reveal the chosen secrets", func() {
+		Expect(isSecretKey("stripe_api_token")).To(BeTrue())
+		Expect(isSecretKey("MONKEY")).To(BeFalse())
+
+		reveal := secretReveal{keys: []string{"API_KEY"}}
+		Expect(reveal.value("api_key", "abc")).To(Equal("abc"))
+		Expect(reveal.value("GITHUB_TOKEN", "abc")).To(Equal(maskSecret("abc")))
+		Expect(reveal.value("GITHUB_TOKEN", nil)).To(BeNil())
+		Expect(secretReveal{all: true}.value("GITHUB_TOKEN", "abc")).To(Equal("abc"))
+	})
+})
@@ -37,10 +37,10 @@ func runServerSettings(c *cli.Context) {
 
 	fmt.Printf("Server: %s\n", server.Name)
 
-	getServerSettings(*stack, *server, c.Args())
+	getServerSettings(*stack, *server, c.Args(), newSecretReveal(c))
 }
 
-func getServerSettings(stack cloud66.Stack, server cloud66.Server, settingNames []string) {
+func getServerSettings(stack cloud66.Stack, server cloud66.Server, settingNames []string, reveal secretReveal) {
 	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
 	defer w.Flush()
 	var settings []cloud66.StackSetting
@@ -51,7 +51,7 @@ func getServerSettings(stack cloud66.Stack, server cloud66.Server, settingNames
 
 	sort.Strings(settingNames)
 	if len(settingNames) == 0 {
-		printSettingList(w, settings)
+		printSettingList(w, settings, reveal)
 	} else {
 		// filter out the unwanted settings
 		var filteredSettings []cloud66.StackSetting
@@ -62,6 +62,6 @@ func getServerSettings(stack cloud66.Stack, server cloud66.Server, settingNames
 			}
 		}
 
-		printSettingList(w, filteredSettings)
+		printSettingList(w, filteredSettings, reveal)
 	}
 }
@@ -64,7 +64,7 @@ $ cx server reboot -s mystack --server lion
 					Name:   "list",
 					Action: runServerSettings,
 					Usage:  "lists server settings",
-					Flags: []cli.Flag{
+					Flags: append([]cli.Flag{
 						cli.StringFlag{
 							Name:  "stack,s",
 							Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
@@ -77,7 +77,7 @@ $ cx server reboot -s mystack --server lion
 							Name:  "server",
 							Usage: "server name",
 						},
-					},
+					}, revealFlags()...),
 					Description: `Lists all the settings applicable to the given server.
 It also shows the key, value and the readonly flag for each setting.
 Settings can be a list of multiple settings as separate parameters.
@@ -89,6 +89,8 @@ server.name         lion                                                       f
 
 $ cx servers settings list -s mystack --server db server.name
 server.name         tiger                                                      false
+
+Values of secret looking settings are masked. Use --reveal or --reveal-key to show them.
 `,
 				},
 				cli.Command{
@@ -35,6 +35,9 @@ func runServicePause(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endServiceAction(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -69,6 +69,9 @@ func runServiceScale(c *cli.Context) {
 	must(err)
 
 	genericRes, err := endServiceScale(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	must(err)
 
 	printGenericResponse(*genericRes)
@@ -83,5 +86,5 @@ func startServiceScaleByGroup(stackUid string, serviceName string, groupCount ma
 }
 
 func endServiceScale(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
@@ -48,6 +48,9 @@ func runServiceStop(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endServiceStop(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -64,5 +67,5 @@ func startServiceStop(stackUid string, serviceName string, serverUid *string) (*
 }
 
 func endServiceStop(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
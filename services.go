@@ -244,7 +244,7 @@ func startServiceAction(stackUid string, serviceName *string, serverUid *string,
 }
 
 func endServiceAction(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 10*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 10*time.Minute, true)
 }
 
 type ServiceByNameServer []cloud66.Service
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"gopkg.in/go-yaml/yaml.v2"
+)
+
+// settingsFile holds the desired settings of a stack and its servers. Servers can be
+// addressed by role, and settings given for a server by name win over the ones of its roles
+type settingsFile struct {
+	Stack   map[string]interface{}            `yaml:"stack"`
+	Roles   map[string]map[string]interface{} `yaml:"roles"`
+	Servers map[string]map[string]interface{} `yaml:"servers"`
+}
+
+// settingChange is a single setting to change on the stack, or on a server if Server is set
+type settingChange struct {
+	Server   *cloud66.Server
+	Key      string
+	OldValue interface{}
+	NewValue string
+}
+
+func (s settingChange) target() string {
+	if s.Server == nil {
+		return "stack"
+	}
+	return "server " + s.Server.Name
+}
+
+func runSettingsPlan(c *cli.Context) {
+	stack := mustStack(c)
+	changes := mustPlanSettings(c, *stack)
+	printSettingChanges(os.Stdout, changes, newSecretReveal(c))
+}
+
+func runSettingsApply(c *cli.Context) {
+	stack := mustStack(c)
+	changes := mustPlanSettings(c, *stack)
+	printSettingChanges(os.Stdout, changes, newSecretReveal(c))
+	if len(changes) == 0 {
+		return
+	}
+
+	if !c.Bool("y") {
+		mustConfirm(fmt.Sprintf("Apply %d change(s) to %s? [yes/N]", len(changes), stack.Name), "yes")
+	}
+
+	if failed := applySettingChanges(*stack, changes, false); failed > 0 {
+		printFatal("%d of %d change(s) failed", failed, len(changes))
+	}
+}
+
+// changes the settings one at a time, reporting the result of each one. Returns the number of failed changes.
+// With --no-wait the changes are only started, unless mustWait is set
+func applySettingChanges(stack cloud66.Stack, changes []settingChange, mustWait bool) int {
+	failed := 0
+	for _, change := range changes {
+		var asyncRes *cloud66.AsyncResult
+		var err error
+		if change.Server == nil {
+			asyncRes, err = client.Set(stack.Uid, change.Key, change.NewValue)
+		} else {
+			asyncRes, err = client.ServerSet(stack.Uid, change.Server.Uid, change.Key, change.NewValue)
+		}
+		if err == nil {
+			var genericRes *cloud66.GenericResponse
+			if mustWait {
+				genericRes, err = waitForStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, false)
+			} else {
+				genericRes, err = waitStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, false)
+			}
+			if err == errAsyncActionNotWaited {
+				continue
+			}
+			if err == nil && !genericRes.Status {
+				err = fmt.Errorf("%s", genericRes.Message)
+			}
+		}
+
+		if err != nil {
+			failed++
+			printError("%s on %s: %s", change.Key, change.target(), err.Error())
+			continue
+		}
+		fmt.Printf("%s on %s: done\n", change.Key, change.target())
+	}
+	return failed
+}
+
+func mustPlanSettings(c *cli.Context, stack cloud66.Stack) []settingChange {
+	filename := c.String("file")
+	if filename == "" {
+		printFatal("No file provided. Please use --file to specify a settings file")
+	}
+	desired, err := readSettingsFile(filename)
+	must(err)
+
+	return mustDiffSettingsFile(stack, desired)
+}
+
+// returns the changes needed to match the settings file. Unknown settings, servers and roles are fatal
+func mustDiffSettingsFile(stack cloud66.Stack, desired *settingsFile) []settingChange {
+	var changes []settingChange
+	var problems []string
+
+	if len(desired.Stack) > 0 {
+		current, err := client.StackSettings(stack.Uid)
+		must(err)
+		stackChanges, stackProblems := diffSettings(current, desired.Stack, nil)
+		changes = append(changes, stackChanges...)
+		problems = append(problems, stackProblems...)
+	}
+
+	if len(desired.Roles) > 0 || len(desired.Servers) > 0 {
+		servers, err := client.Servers(stack.Uid)
+		must(err)
+		targets, targetProblems := serverSettingTargets(servers, desired)
+		problems = append(problems, targetProblems...)
+
+		for idx := range servers {
+			wanted, ok := targets[servers[idx].Uid]
+			if !ok {
+				continue
+			}
+			current, err := client.ServerSettings(stack.Uid, servers[idx].Uid)
+			must(err)
+			serverChanges, serverProblems := diffSettings(current, wanted, &servers[idx])
+			changes = append(changes, serverChanges...)
+			problems = append(problems, serverProblems...)
+		}
+	}
+
+	if len(problems) > 0 {
+		printFatal("Invalid settings file:\n%s", strings.Join(problems, "\n"))
+	}
+	return changes
+}
+
+func readSettingsFile(filename string) (*settingsFile, error) {
+	data, err := ioutil.ReadFile(expandPath(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	var result settingsFile
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// returns the desired settings of each server, by server uid
+func serverSettingTargets(servers []cloud66.Server, desired *settingsFile) (map[string]map[string]interface{}, []string) {
+	result := make(map[string]map[string]interface{})
+	var problems []string
+
+	add := func(server cloud66.Server, settings map[string]interface{}) {
+		if result[server.Uid] == nil {
+			result[server.Uid] = make(map[string]interface{})
+		}
+		for key, value := range settings {
+			result[server.Uid][key] = value
+		}
+	}
+
+	for _, role := range sortedSettingTargets(desired.Roles) {
+		found := false
+		for _, server := range servers {
+			for _, serverRole := range server.Roles {
+				if strings.EqualFold(serverRole, role) {
+					add(server, desired.Roles[role])
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("no servers with the role %s", role))
+		}
+	}
+
+	for _, name := range sortedSettingTargets(desired.Servers) {
+		found := false
+		for _, server := range servers {
+			if strings.EqualFold(server.Name, name) {
+				add(server, desired.Servers[name])
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("no server named %s", name))
+		}
+	}
+
+	return result, problems
+}
+
+func sortedSettingTargets(targets map[string]map[string]interface{}) []string {
+	var result []string
+	for name := range targets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// compares the current settings with the desired ones. Readonly settings are skipped with a warning
+// and unknown ones are returned as problems
+func diffSettings(current []cloud66.StackSetting, desired map[string]interface{}, server *cloud66.Server) ([]settingChange, []string) {
+	byKey := make(map[string]cloud66.StackSetting)
+	for _, setting := range current {
+		byKey[setting.Key] = setting
+	}
+
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []settingChange
+	var problems []string
+	for _, key := range keys {
+		change := settingChange{Server: server, Key: key, NewValue: settingValueString(desired[key])}
+		setting, ok := byKey[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not a valid setting for the %s", key, change.target()))
+			continue
+		}
+		if setting.Readonly {
+			printWarning("Skipping %s on the %s as it is readonly", key, change.target())
+			continue
+		}
+		if sameSettingValue(setting.Value, change.NewValue) {
+			continue
+		}
+		change.OldValue = setting.Value
+		changes = append(changes, change)
+	}
+	return changes, problems
+}
+
+func settingValueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// compares a setting value with a desired one, accepting the same boolean values as settings set
+func sameSettingValue(current interface{}, desired string) bool {
+	if current == nil {
+		return desired == ""
+	}
+	if value, ok := current.(bool); ok {
+		switch strings.ToLower(desired) {
+		case "1", "true", "on", "enable":
+			return value
+		case "0", "false", "off", "disable":
+			return !value
+		}
+	}
+	return fmt.Sprint(current) == desired
+}
+
+func printSettingChanges(w io.Writer, changes []settingChange, reveal secretReveal) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+
+	for _, change := range changes {
+		fmt.Fprintf(w, "~ %s: %s=%v (was %v)\n", change.target(), change.Key, reveal.value(change.Key, change.NewValue), reveal.value(change.Key, change.OldValue))
+	}
+	fmt.Fprintf(w, "%d change(s) to apply\n", len(changes))
+}
@@ -38,8 +38,12 @@ allowed.web.source  <nil>                                                      f
 
 $ cx settings list -s mystack git.branch
 git.branch          master                                                     false
+
+Values of secret looking settings and credentials in URLs are masked. Use --reveal to show them,
+or --reveal-key to show only some of them.
 `,
 			Action: runSettings,
+			Flags:  revealFlags(),
 		},
 		cli.Command{
 			Name:  "set",
@@ -56,6 +60,60 @@ $ cx settings set -s mystack maintenance.mode  0|false|off|disable
 `,
 			Action: runSet,
 		},
+		cli.Command{
+			Name:   "plan",
+			Usage:  "shows the changes needed to match the settings of a file",
+			Action: runSettingsPlan,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "file,f",
+					Usage: "YAML file with the desired settings",
+				},
+			}, revealFlags()...),
+			Description: `Compares the settings of a stack and its servers with the ones declared in a YAML file,
+and shows the changes 'settings apply' would make. Readonly settings are skipped.
+
+The file holds stack settings, and server settings by role or server name. Settings given for a server by name
+win over the ones of its roles:
+
+stack:
+  git.branch: master
+  maintenance.mode: off
+roles:
+  web:
+    some.server.setting: value
+servers:
+  lion:
+    server.name: lion
+
+Examples:
+$ cx settings plan -s mystack -f settings.yml
+`,
+		},
+		cli.Command{
+			Name:   "apply",
+			Usage:  "changes the settings of a stack and its servers to match a file",
+			Action: runSettingsApply,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "file,f",
+					Usage: "YAML file with the desired settings",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+			}, revealFlags()...),
+			Description: `Changes the settings of a stack and its servers to match the ones declared in a YAML file.
+Only the settings which are different are changed, one at a time, and the result of each change is shown.
+With --no-wait the changes are started without waiting for them, and their action ids are shown instead.
+See 'settings plan' for the format of the file.
+
+Examples:
+$ cx settings apply -s mystack -f settings.yml
+$ cx settings apply -s mystack -f settings.yml -y
+`,
+		},
 	}
 
 	return base
@@ -73,7 +131,7 @@ func runSettings(c *cli.Context) {
 	settingNames := c.Args()
 	sort.Strings(settingNames)
 	if len(settingNames) == 0 {
-		printSettingList(w, settings)
+		printSettingList(w, settings, newSecretReveal(c))
 	} else {
 		// filter out the unwanted settings
 		var filteredSettings []cloud66.StackSetting
@@ -84,20 +142,20 @@ func runSettings(c *cli.Context) {
 			}
 		}
 
-		printSettingList(w, filteredSettings)
+		printSettingList(w, filteredSettings, newSecretReveal(c))
 	}
 }
 
-func printSettingList(w io.Writer, settings []cloud66.StackSetting) {
+func printSettingList(w io.Writer, settings []cloud66.StackSetting, reveal secretReveal) {
 	sort.Sort(settingsByName(settings))
 	for _, a := range settings {
 		if a.Key != "" {
-			listSetting(w, a)
+			listSetting(w, a, reveal)
 		}
 	}
 }
 
-func listSetting(w io.Writer, a cloud66.StackSetting) {
+func listSetting(w io.Writer, a cloud66.StackSetting, reveal secretReveal) {
 	var readonly string
 	if a.Readonly {
 		readonly = "readonly"
@@ -106,7 +164,7 @@ func listSetting(w io.Writer, a cloud66.StackSetting) {
 	}
 	listRec(w,
 		a.Key,
-		a.Value,
+		reveal.value(a.Key, a.Value),
 		readonly,
 	)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Settings plan", func() {
+	It("should only change settings which are different", func() {
+		current := []cloud66.StackSetting{
+			{Key: "git.branch", Value: "master"},
+			{Key: "maintenance.mode", Value: false},
+			{Key: "stack.name", Value: "mystack", Readonly: true},
+		}
+		desired := map[string]interface{}{"git.branch": "dev", "maintenance.mode": "off", "stack.name": "other", "nope": 1}
+
+		changes, problems := diffSettings(current, desired, nil)
+		Expect(changes).To(HaveLen(1))
+		Expect(changes[0].Key).To(Equal("git.branch"))
+		Expect(changes[0].NewValue).To(Equal("dev"))
+		Expect(problems).To(HaveLen(1))
+	})
+
+	It("should let server settings win over role settings", func() {
+		servers := []cloud66.Server{
+			{Uid: "1", Name: "lion", Roles: []string{"web"}},
+			{Uid: "2", Name: "tiger", Roles: []string{"web"}},
+		}
+		desired := &settingsFile{
+			Roles:   map[string]map[string]interface{}{"web": {"a": "role"}},
+			Servers: map[string]map[string]interface{}{"Lion": {"a": "server"}},
+		}
+
+		targets, problems := serverSettingTargets(servers, desired)
+		Expect(problems).To(BeEmpty())
+		Expect(targets["1"]["a"]).To(Equal("server"))
+		Expect(targets["2"]["a"]).To(Equal("role"))
+	})
+
+	It("should only start the changes with --no-wait", func() {
+		// other suites can leave pending mocks behind
+		gock.Off()
+		restoreClient := MockApiClient()
+		defer restoreClient()
+		defer gock.Off()
+		flagNoWait = true
+		defer func() { flagNoWait = false }()
+
+		gock.New("https://app.cloud66.com/api/3").
+			Put("/stacks/abc/settings/git-branch.json").
+			Reply(200).
+			BodyString(`{"response":{"id":7}}`)
+
+		StartCaptureStdout()
+		failed := applySettingChanges(cloud66.Stack{Uid: "abc"}, []settingChange{{Key: "git.branch", NewValue: "dev"}}, false)
+		output := StopCaptureStdout()
+
+		Expect(failed).To(Equal(0))
+		Expect(output[0]).To(Equal("Started async action 7. Use 'cx actions wait 7' to wait for it to finish"))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+})
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const sshDialTimeout = 30 * time.Second
+
+// sshTarget is a host to connect to over SSH, directly or through a gateway (jump host)
+type sshTarget struct {
+	// the uid and name of stack servers. Host keys of servers are pinned by uid, and of other hosts by address
+	Uid  string
+	Name string
+	User string
+	// host or host:port, using port 22 if none is given
+	Host string
+	// the private key to use. When empty, the keys of the SSH agent and the default keys in ~/.ssh are used
+	KeyFile string
+	// the comment of the key in the SSH agent to use, for stack keys kept in the agent
+	AgentKey string
+	Gateway  *sshTarget
+	// forwards the local SSH agent to sessions on the host. Only interactive cx ssh sessions do this
+	ForwardAgent bool
+	// 1 to 3, like ssh -v to -vvv
+	Verbosity int
+}
+
+func (t sshTarget) address() string {
+	if _, _, err := net.SplitHostPort(t.Host); err == nil {
+		return t.Host
+	}
+	return net.JoinHostPort(t.Host, "22")
+}
+
+func (t sshTarget) String() string {
+	return t.User + "@" + t.Host
+}
+
+func (t sshTarget) displayName() string {
+	if t.Name == "" {
+		return t.Host
+	}
+	return fmt.Sprintf("%s (%s)", t.Name, t.Host)
+}
+
+func (t sshTarget) debugf(level int, format string, a ...interface{}) {
+	if t.Verbosity >= level {
+		fmt.Fprintf(os.Stderr, "debug%d: %s\n", level, fmt.Sprintf(format, a...))
+	}
+}
+
+// the target of a server, using the key of its stack, through its deploy gateway if it is behind one
+func serverSSHTarget(server cloud66.Server, key serverKey) (sshTarget, error) {
+	target := sshTarget{Uid: server.Uid, Name: server.Name, User: server.UserName, Host: server.Address, KeyFile: key.File, AgentKey: key.AgentComment}
+	if server.HasDeployGateway {
+		gateway, err := serverGatewayTarget(server)
+		if err != nil {
+			return target, err
+		}
+		target.Gateway = gateway
+	}
+	return target, nil
+}
+
+// connects to a server with the key of its stack
+func dialServer(server cloud66.Server, key serverKey) (*sshConnection, error) {
+	target, err := serverSSHTarget(server, key)
+	if err != nil {
+		return nil, err
+	}
+	return dialSSH(target)
+}
+
+// sshConnection is an SSH connection to a target, used by every command accessing servers
+type sshConnection struct {
+	*ssh.Client
+	target  sshTarget
+	gateway *sshConnection
+	agent   net.Conn
+}
+
+func dialSSH(target sshTarget) (*sshConnection, error) {
+	result := &sshConnection{target: target}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			result.agent = conn
+		} else {
+			target.debugf(1, "unable to connect to the SSH agent: %s", err.Error())
+		}
+	}
+
+	config, err := result.clientConfig()
+	if err != nil {
+		result.Close()
+		return nil, err
+	}
+
+	var conn net.Conn
+	if target.Gateway != nil {
+		target.debugf(1, "connecting to the gateway %s", target.Gateway.String())
+		result.gateway, err = dialSSH(*target.Gateway)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("gateway %s: %s", target.Gateway.Host, err.Error())
+		}
+		target.debugf(1, "connecting to %s through the gateway", target.address())
+		conn, err = result.gateway.Dial("tcp", target.address())
+	} else {
+		target.debugf(1, "connecting to %s", target.address())
+		conn, err = net.DialTimeout("tcp", target.address(), sshDialTimeout)
+	}
+	if err != nil {
+		result.Close()
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, target.address(), config)
+	if err != nil {
+		conn.Close()
+		result.Close()
+		return nil, err
+	}
+	target.debugf(1, "authenticated to %s, server version %s", target.address(), string(clientConn.ServerVersion()))
+	result.Client = ssh.NewClient(clientConn, chans, reqs)
+
+	if target.ForwardAgent && result.agent != nil {
+		if err := agent.ForwardToAgent(result.Client, agent.NewClient(result.agent)); err != nil {
+			target.debugf(1, "unable to forward the SSH agent: %s", err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func (c *sshConnection) clientConfig() (*ssh.ClientConfig, error) {
+	var signers []ssh.Signer
+	if c.target.KeyFile != "" {
+		signer, err := readPrivateKey(c.target.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else if c.target.AgentKey != "" {
+		if c.agent == nil {
+			return nil, fmt.Errorf("the SSH key of %s is kept in the SSH agent, but no agent is running", c.target.displayName())
+		}
+		signer, err := agentSigner(agent.NewClient(c.agent), c.target.AgentKey)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else {
+		if c.agent != nil {
+			agentSigners, err := agent.NewClient(c.agent).Signers()
+			if err == nil {
+				c.target.debugf(2, "%d key(s) in the SSH agent", len(agentSigners))
+				signers = append(signers, agentSigners...)
+			}
+		}
+		for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+			filename := filepath.Join(homePath(), ".ssh", name)
+			// keys with a passphrase are only usable through the agent
+			if signer, err := readPrivateKey(filename); err == nil {
+				c.target.debugf(2, "using the key %s", filename)
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH keys available to connect to %s", c.target.Host)
+	}
+
+	pin, err := findKnownHost(c.target)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            c.target.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback(c.target, pin),
+		Timeout:         sshDialTimeout,
+	}
+	// ask for the pinned type of key, or hosts with more than one would look like they changed
+	if pin != nil {
+		config.HostKeyAlgorithms = []string{pin.Key.Type()}
+	}
+	return config, nil
+}
+
+func readPrivateKey(filename string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return signer, nil
+}
+
+func (c *sshConnection) Close() error {
+	var err error
+	if c.Client != nil {
+		err = c.Client.Close()
+	}
+	if c.gateway != nil {
+		c.gateway.Close()
+	}
+	if c.agent != nil {
+		c.agent.Close()
+	}
+	return err
+}
+
+// runs a command with the standard input and outputs attached, starting a shell if the command is empty.
+// With tty, a terminal is allocated and its size follows the local one
+func (c *sshConnection) Run(command string, tty bool) error {
+	return c.RunWith(command, tty, os.Stdin, os.Stdout, os.Stderr)
+}
+
+func (c *sshConnection) RunWith(command string, tty bool, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	session, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if c.target.ForwardAgent && c.agent != nil {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			c.target.debugf(1, "agent forwarding refused: %s", err.Error())
+		}
+	}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if tty {
+		restore, err := c.requestPty(session)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	c.target.debugf(1, "running %q", command)
+	if command == "" {
+		if err := session.Shell(); err != nil {
+			return err
+		}
+		return session.Wait()
+	}
+	return session.Run(command)
+}
+
+// allocates a terminal for the session and puts the local one in raw mode. Returns a function to restore it
+func (c *sshConnection) requestPty(session *ssh.Session) (func(), error) {
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return func() {}, session.RequestPty(termType, 24, 80, ssh.TerminalModes{})
+	}
+
+	width, height, err := terminal.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return nil, err
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	stop := watchWindowSize(func() {
+		if width, height, err := terminal.GetSize(fd); err == nil {
+			session.WindowChange(height, width)
+		}
+	})
+	return func() {
+		stop()
+		terminal.Restore(fd, state)
+	}, nil
+}
+
+// listens on a local port, on the loopback interface like ssh -L
+func listenLocal(port int) (net.Listener, error) {
+	return net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// forwards every connection to the listener to the remote address, as seen from the host. Returns when the listener is closed
+func (c *sshConnection) Forward(listener net.Listener, remoteAddress string) error {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			remote, err := c.Dial("tcp", remoteAddress)
+			if err != nil {
+				printError("Unable to forward to %s: %s", remoteAddress, err.Error())
+				local.Close()
+				return
+			}
+			pipeConnections(local, remote)
+		}()
+	}
+}
+
+// copies between two connections until either side closes
+func pipeConnections(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copy := func(to net.Conn, from net.Conn) {
+		io.Copy(to, from)
+		done <- struct{}{}
+	}
+	go copy(a, b)
+	go copy(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// copies a local file or directory to the host, like scp -r. If remotePath is a directory the copy goes in it
+func (c *sshConnection) Upload(localPath string, remotePath string) error {
+	client, err := sftp.NewClient(c.Client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	localPath, err = filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	if info, err := client.Stat(remotePath); err == nil && info.IsDir() {
+		remotePath = path.Join(remotePath, filepath.Base(localPath))
+	}
+
+	return filepath.Walk(localPath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, name)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remotePath, filepath.ToSlash(rel))
+		if info.IsDir() {
+			return client.MkdirAll(target)
+		}
+
+		source, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		file, err := client.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return fmt.Errorf("%s: %s", target, err.Error())
+		}
+		defer file.Close()
+		if _, err := io.Copy(file, source); err != nil {
+			return err
+		}
+		fmt.Println(target)
+		return client.Chmod(target, info.Mode().Perm())
+	})
+}
+
+// copies a file or directory from the host, like scp -r. If localPath is a directory the copy goes in it
+func (c *sshConnection) Download(remotePath string, localPath string) error {
+	client, err := sftp.NewClient(c.Client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	remotePath = path.Clean(remotePath)
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+
+	walker := client.Walk(remotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		rel := remoteRelPath(remotePath, walker.Path())
+		target := filepath.Join(localPath, filepath.FromSlash(rel))
+		info := walker.Stat()
+		if info.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := sftpDownloadFile(client, walker.Path(), target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		fmt.Println(target)
+	}
+	return nil
+}
+
+// the path of a walked remote file relative to the walked root. The walker cleans the paths it returns,
+// so the root has to be cleaned too for paths like . or ./public
+func remoteRelPath(root string, walked string) string {
+	root, walked = path.Clean(root), path.Clean(walked)
+	switch {
+	case walked == root:
+		return ""
+	case root == ".":
+		return walked
+	case root == "/":
+		return strings.TrimPrefix(walked, "/")
+	}
+	return strings.TrimPrefix(walked, root+"/")
+}
+
+func sftpDownloadFile(client *sftp.Client, remotePath string, localPath string, mode os.FileMode) error {
+	source, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("%s: %s", remotePath, err.Error())
+	}
+	defer source.Close()
+	file, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, source)
+	return err
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SSH client", func() {
+	var server *testSSHServer
+	var target sshTarget
+	var restoreHome func()
+
+	BeforeEach(func() {
+		restoreHome = useTempHome()
+		server = startTestSSHServer()
+		target = sshTarget{User: "cloud66-user", Host: server.Address(), KeyFile: server.KeyFile}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		restoreHome()
+	})
+
+	It("should run commands and return their exit status", func() {
+		conn, err := dialSSH(target)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		var stdout bytes.Buffer
+		Expect(conn.RunWith("uptime", false, nil, &stdout, ioutil.Discard)).To(Succeed())
+		Expect(stdout.String()).To(Equal("ran uptime\n"))
+
+		err = conn.RunWith("exit 3", false, nil, ioutil.Discard, ioutil.Discard)
+		Expect(err).To(BeAssignableToTypeOf(&ssh.ExitError{}))
+		Expect(err.(*ssh.ExitError).ExitStatus()).To(Equal(3))
+		Expect(server.Commands()).To(Equal([]string{"uptime", "exit 3"}))
+	})
+
+	It("should only accept the given key", func() {
+		other := startTestSSHServer()
+		defer other.Close()
+
+		target.KeyFile = other.KeyFile
+		_, err := dialSSH(target)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should pin host keys by server uid and refuse changed ones", func() {
+		target.Uid = "server-uid"
+		conn, err := dialSSH(target)
+		Expect(err).NotTo(HaveOccurred())
+		conn.Close()
+
+		// the same server, now with another address and host key
+		rebuilt := startTestSSHServer()
+		defer rebuilt.Close()
+		moved := sshTarget{Uid: "server-uid", User: "cloud66-user", Host: rebuilt.Address(), KeyFile: rebuilt.KeyFile}
+		_, err = dialSSH(moved)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("REMOTE HOST IDENTIFICATION HAS CHANGED"))
+
+		count, err := forgetKnownHosts([]string{"server-uid"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(1))
+		conn, err = dialSSH(moved)
+		Expect(err).NotTo(HaveOccurred())
+		conn.Close()
+
+		pin, err := findKnownHost(moved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pin.Address).To(Equal(rebuilt.Address()))
+	})
+
+	It("should connect through a gateway", func() {
+		gateway := startTestSSHServer()
+		defer gateway.Close()
+
+		target.Gateway = &sshTarget{User: "gateway-user", Host: gateway.Address(), KeyFile: gateway.KeyFile}
+		conn, err := dialSSH(target)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		Expect(conn.RunWith("hostname", false, nil, ioutil.Discard, ioutil.Discard)).To(Succeed())
+		Expect(server.Commands()).To(Equal([]string{"hostname"}))
+		Expect(gateway.Forwarded()).To(Equal([]string{server.Address()}))
+	})
+
+	It("should forward local ports", func() {
+		echo, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer echo.Close()
+		go func() {
+			for {
+				conn, err := echo.Accept()
+				if err != nil {
+					return
+				}
+				go io.Copy(conn, conn)
+			}
+		}()
+
+		conn, err := dialSSH(target)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		listener, err := listenLocal(0)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		go conn.Forward(listener, echo.Addr().String())
+
+		local, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer local.Close()
+		fmt.Fprint(local, "ping")
+		reply := make([]byte, 4)
+		_, err = io.ReadFull(local, reply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(reply)).To(Equal("ping"))
+		Expect(server.Forwarded()).To(Equal([]string{echo.Addr().String()}))
+	})
+
+	It("should upload and download directories", func() {
+		dir, err := ioutil.TempDir("", "cx-ssh")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		source := filepath.Join(dir, "source")
+		Expect(os.MkdirAll(filepath.Join(source, "config"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(source, "config", "app.yml"), []byte("name: app\n"), 0600)).To(Succeed())
+		remote := filepath.Join(dir, "remote")
+		Expect(os.Mkdir(remote, 0755)).To(Succeed())
+		local := filepath.Join(dir, "local")
+		Expect(os.Mkdir(local, 0755)).To(Succeed())
+
+		conn, err := dialSSH(target)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		StartCaptureStdout()
+		uploadErr := conn.Upload(source, remote)
+		downloadErr := conn.Download(filepath.Join(remote, "source"), local)
+		StopCaptureStdout()
+		Expect(uploadErr).NotTo(HaveOccurred())
+		Expect(downloadErr).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(local, "source", "config", "app.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("name: app\n"))
+		info, err := os.Stat(filepath.Join(remote, "source", "config", "app.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("should not forward the agent to servers by default", func() {
+		target, err := serverSSHTarget(cloud66.Server{Uid: "s1", Name: "lion", UserName: "ubuntu", Address: "52.65.34.98"}, serverKey{File: server.KeyFile})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.ForwardAgent).To(BeFalse())
+	})
+
+	It("should make walked remote paths relative to cleaned roots", func() {
+		Expect(remoteRelPath(".", ".bashrc")).To(Equal(".bashrc"))
+		Expect(remoteRelPath(".", ".")).To(Equal(""))
+		Expect(remoteRelPath("./public", "public/assets/app.css")).To(Equal("assets/app.css"))
+		Expect(remoteRelPath("/var/www/", "/var/www/index.html")).To(Equal("index.html"))
+		Expect(remoteRelPath("/", "/etc/hosts")).To(Equal("etc/hosts"))
+		Expect(remoteRelPath("/var/www", "/var/www")).To(Equal(""))
+	})
+})
+
+// points $HOME to a temporary directory, so host keys are pinned there. Returns a function to restore it
+func useTempHome() func() {
+	home := os.Getenv("HOME")
+	dir, err := ioutil.TempDir("", "cx-home")
+	Expect(err).NotTo(HaveOccurred())
+	os.Setenv("HOME", dir)
+	return func() {
+		os.Setenv("HOME", home)
+		os.RemoveAll(dir)
+	}
+}
+
+// testSSHServer is an in-process SSH server accepting a single generated key. It records commands instead
+// of running them, answers "ran <command>" (or exits with N for "exit N"), serves SFTP on the local
+// file system and forwards TCP connections
+type testSSHServer struct {
+	KeyFile   string
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	mutex     sync.Mutex
+	commands  []string
+	forwarded []string
+}
+
+func startTestSSHServer() *testSSHServer {
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyFile, err := ioutil.TempFile("", "cx-ssh-key")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)})).To(Succeed())
+	keyFile.Close()
+
+	server := &testSSHServer{KeyFile: keyFile.Name()}
+	server.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientPublicKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	server.config.AddHostKey(hostSigner)
+
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	go server.serve()
+	return server
+}
+
+func (s *testSSHServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testSSHServer) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func (s *testSSHServer) Forwarded() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.forwarded...)
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+	os.Remove(s.KeyFile)
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				switch newChannel.ChannelType() {
+				case "session":
+					go s.session(newChannel)
+				case "direct-tcpip":
+					go s.directTcpip(newChannel)
+				default:
+					newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
+				}
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) session(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for request := range requests {
+		switch request.Type {
+		case "pty-req", "window-change":
+			request.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(request.Payload, &payload)
+			request.Reply(true, nil)
+			s.mutex.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mutex.Unlock()
+
+			status := 0
+			if strings.HasPrefix(payload.Command, "exit ") {
+				fmt.Sscanf(payload.Command, "exit %d", &status)
+			} else {
+				fmt.Fprintf(channel, "ran %s\n", payload.Command)
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		case "subsystem":
+			request.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err == nil {
+				server.Serve()
+			}
+			return
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) directTcpip(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	ssh.Unmarshal(newChannel.ExtraData(), &payload)
+	address := net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port))
+	s.mutex.Lock()
+	s.forwarded = append(s.forwarded, address)
+	s.mutex.Unlock()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(channel, conn)
+		channel.Close()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+}
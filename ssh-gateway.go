@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// closed gateways are opened for this long, and renewed while cx runs
+const sessionGatewayTtl = 30 * time.Minute
+
+// set from --gateway-key by commands connecting to servers. Takes precedence over the gateway keys of the profile
+var flagGatewayKey string
+
+// the --gateway-key flag of commands connecting to servers
+func gatewayKeyFlag() cli.Flag {
+	return cli.StringFlag{
+		Name:  "gateway-key",
+		Usage: "path to the key of the deploy gateway (bastion server), for servers behind one. Defaults to the key set for the gateway in the profile",
+	}
+}
+
+// deployGateway is the deploy gateway of servers behind a bastion, looked up once per command
+type deployGateway struct {
+	address   string
+	accountId int
+	// nil if the gateway isn't one of the account, or can't be listed
+	gateway *cloud66.Gateway
+	// set when cx opened the gateway, and has to close it again on exit
+	opened bool
+	stop   chan struct{}
+}
+
+func (g *deployGateway) name() string {
+	if g.gateway == nil {
+		return g.address
+	}
+	return g.gateway.Name
+}
+
+var (
+	deployGateways = map[string]*deployGateway{}
+	// guards deployGateways, and the opened state of the gateways in it
+	deployGatewaysMutex sync.Mutex
+	// servers are connected to in parallel, but gateways are looked up and opened one at a time
+	deployGatewaysLookup sync.Mutex
+)
+
+// returns the target of the deploy gateway of the server, opening the gateway if it is closed and the user agrees
+func serverGatewayTarget(server cloud66.Server) (*sshTarget, error) {
+	if len(server.DeployGatewayAddress) == 0 {
+		return nil, fmt.Errorf("Can not find the address of gateway server")
+	}
+	if len(server.DeployGatewayUsername) == 0 {
+		return nil, fmt.Errorf("Can not find the username of gateway server")
+	}
+
+	deployGatewaysLookup.Lock()
+	defer deployGatewaysLookup.Unlock()
+
+	deployGatewaysMutex.Lock()
+	gateway := deployGateways[server.DeployGatewayAddress]
+	deployGatewaysMutex.Unlock()
+	if gateway == nil {
+		gateway = findDeployGateway(server)
+		deployGatewaysMutex.Lock()
+		deployGateways[server.DeployGatewayAddress] = gateway
+		deployGatewaysMutex.Unlock()
+	}
+
+	keyFile := flagGatewayKey
+	if keyFile == "" && gateway.gateway != nil && selectedProfile != nil {
+		keyFile = selectedProfile.GatewayKeys[gateway.gateway.Name]
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("%s is deployed behind the gateway %s. Use --gateway-key, or set the key of the gateway with 'cx config update <profile> --gateway-key %s=<path/to/key>'", server.Name, gateway.name(), gateway.name())
+	}
+	if strings.HasPrefix(keyFile, "~/") {
+		keyFile = expandPath(keyFile)
+	}
+
+	if gateway.gateway != nil && gatewayState(*gateway.gateway) != "open" && !gateway.opened {
+		if err := openSessionGateway(gateway, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sshTarget{User: server.DeployGatewayUsername, Host: server.DeployGatewayAddress, KeyFile: keyFile}, nil
+}
+
+func findDeployGateway(server cloud66.Server) *deployGateway {
+	result := &deployGateway{address: server.DeployGatewayAddress}
+	stack, err := client.FindStackByUid(server.StackUid)
+	if err != nil {
+		if debugMode {
+			fmt.Printf("Unable to find the stack of %s: %s\n", server.Name, err.Error())
+		}
+		return result
+	}
+	result.accountId = stack.AccountId
+
+	gateways, err := client.ListGateways(stack.AccountId)
+	if err != nil {
+		if debugMode {
+			fmt.Printf("Unable to list the gateways: %s\n", err.Error())
+		}
+		return result
+	}
+	for _, g := range gateways {
+		if g.Address == server.DeployGatewayAddress || g.PrivateIp == server.DeployGatewayAddress {
+			g := g
+			result.gateway = &g
+			break
+		}
+	}
+	return result
+}
+
+// opens a closed gateway for as long as cx runs. The gateway is renewed until cx exits, and closes by itself
+// if cx is killed before it can close it
+func openSessionGateway(gateway *deployGateway, keyFile string) error {
+	name := gateway.gateway.Name
+	if !ask(fmt.Sprintf("The gateway %s is closed. Open it while cx runs? (y/N) ", name), "y") {
+		return fmt.Errorf("The gateway %s is closed. Open it with 'cx gateways open --name %s --key <path/to/key> --ttl 1h'", name, name)
+	}
+
+	keyContent, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	ttl := int(sessionGatewayTtl.Seconds())
+	if err := client.UpdateGateway(gateway.accountId, gateway.gateway.Id, string(keyContent), ttl); err != nil {
+		return fmt.Errorf("Error opening gateway : %s", err.Error())
+	}
+	fmt.Printf("Opened the gateway %s. It will be closed when cx exits\n", name)
+
+	deployGatewaysMutex.Lock()
+	gateway.opened = true
+	gateway.stop = make(chan struct{})
+	deployGatewaysMutex.Unlock()
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(sessionGatewayTtl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := client.UpdateGateway(gateway.accountId, gateway.gateway.Id, string(keyContent), ttl); err != nil {
+					printWarning("Unable to renew the gateway %s: %s", name, err.Error())
+				}
+			}
+		}
+	}(gateway.stop)
+
+	closeGatewaysOnInterrupt()
+	return nil
+}
+
+var interruptHandler sync.Once
+
+// set while a local command runs which handles Ctrl-C itself, like the command of 'cx env-vars exec'
+var interruptsHandledByCommand int32
+
+// closes the gateways opened by cx when it is interrupted, like by Ctrl-C during a tunnel
+func closeGatewaysOnInterrupt() {
+	interruptHandler.Do(func() {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for range interrupts {
+				if atomic.LoadInt32(&interruptsHandledByCommand) == 1 {
+					continue
+				}
+				closeSessionGateways()
+				os.Exit(130)
+			}
+		}()
+	})
+}
+
+// closes the gateways opened by cx. Called when cx exits
+func closeSessionGateways() {
+	deployGatewaysMutex.Lock()
+	defer deployGatewaysMutex.Unlock()
+
+	for address, gateway := range deployGateways {
+		if !gateway.opened {
+			continue
+		}
+		close(gateway.stop)
+		gateway.opened = false
+		// closing a gateway is opening it for a second without a key, like 'cx gateways close' does
+		if err := client.UpdateGateway(gateway.accountId, gateway.gateway.Id, "", 1); err != nil {
+			printWarning("Unable to close the gateway %s: %s. Close it with 'cx gateways close --name %s'", gateway.name(), err.Error(), gateway.name())
+			continue
+		}
+		fmt.Printf("Closed the gateway %s\n", gateway.name())
+		delete(deployGateways, address)
+	}
+}
+
+// parses name=path gateway keys. A name without a path removes the key of the gateway. Invalid values are fatal
+func parseGatewayKeys(keys map[string]string, values []string) map[string]string {
+	result := map[string]string{}
+	for name, path := range keys {
+		result[name] = path
+	}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			printFatal("invalid gateway key %q. Use name=path, like aws_bastion=~/.ssh/bastion.pem", value)
+		}
+		name, path := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if path == "" {
+			delete(result, name)
+		} else {
+			result[name] = path
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
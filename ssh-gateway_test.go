@@ -0,0 +1,18 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gateway keys", func() {
+	It("should add, replace and remove keys by gateway name", func() {
+		keys := parseGatewayKeys(nil, []string{"aws_bastion=~/.ssh/bastion.pem", "gcp = /keys/gcp"})
+		Expect(keys).To(Equal(map[string]string{"aws_bastion": "~/.ssh/bastion.pem", "gcp": "/keys/gcp"}))
+
+		keys = parseGatewayKeys(keys, []string{"aws_bastion=/keys/aws", "gcp="})
+		Expect(keys).To(Equal(map[string]string{"aws_bastion": "/keys/aws"}))
+
+		Expect(parseGatewayKeys(keys, []string{"aws_bastion="})).To(BeNil())
+	})
+})
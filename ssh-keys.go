@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// stack SSH keys are fetched again once they are older than this, unless the profile sets another max age
+const defaultSshKeyMaxAge = 24 * time.Hour
+
+// where fetched stack SSH keys are kept
+const (
+	sshKeyStorageFile  = "file"
+	sshKeyStorageAgent = "agent"
+)
+
+var sshKeysMutex sync.Mutex
+
+// serverKey is the SSH key to connect to a server with: a key file, or a key in the SSH agent
+type serverKey struct {
+	File         string
+	AgentComment string
+}
+
+// sshKeyInfo describes a stack SSH key fetched by cx. It is kept next to the key, or on its own if the key is in the SSH agent
+type sshKeyInfo struct {
+	Name       string     `json:"name"`
+	Profile    string     `json:"profile"`
+	StackUid   string     `json:"stack_uid"`
+	ServerUid  string     `json:"server_uid"`
+	ServerName string     `json:"server_name"`
+	Personal   bool       `json:"personal"`
+	Storage    string     `json:"storage"`
+	FetchedAt  time.Time  `json:"fetched_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+func sshKeysDir() string {
+	return filepath.Join(cxHome(), "ssh-keys")
+}
+
+func (k sshKeyInfo) keyFile() string {
+	return filepath.Join(sshKeysDir(), k.Profile, k.Name)
+}
+
+func (k sshKeyInfo) infoFile() string {
+	return k.keyFile() + ".json"
+}
+
+func (k sshKeyInfo) agentComment() string {
+	return "cx:" + k.Profile + ":" + k.Name
+}
+
+func (k sshKeyInfo) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func (k sshKeyInfo) serverKey() serverKey {
+	if k.Storage == sshKeyStorageAgent {
+		return serverKey{AgentComment: k.agentComment()}
+	}
+	return serverKey{File: k.keyFile()}
+}
+
+func currentProfileName() string {
+	if selectedProfile == nil || selectedProfile.Name == "" {
+		return "default"
+	}
+	return selectedProfile.Name
+}
+
+// the max age of stack SSH keys set in the profile. 0 means keys never expire
+func sshKeyMaxAge() time.Duration {
+	if selectedProfile == nil || selectedProfile.SshKeyMaxAge == "" {
+		return defaultSshKeyMaxAge
+	}
+	maxAge, err := parseSshKeyMaxAge(selectedProfile.SshKeyMaxAge)
+	if err != nil {
+		printWarning("Invalid ssh_key_max_age in the profile: %s", err.Error())
+		return defaultSshKeyMaxAge
+	}
+	return maxAge
+}
+
+func parseSshKeyMaxAge(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	maxAge, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max age %q. Use a duration like 12h, or 0 for keys which never expire", value)
+	}
+	return maxAge, nil
+}
+
+func sshKeyStorage() string {
+	if selectedProfile == nil || selectedProfile.SshKeyStorage == "" {
+		return sshKeyStorageFile
+	}
+	return selectedProfile.SshKeyStorage
+}
+
+// returns the SSH key of the stack of the server, fetching it again if it has expired or is gone
+func prepareLocalSshKey(server cloud66.Server) (serverKey, error) {
+	// servers of the same stack share the key, so it is only fetched once when connecting to them in parallel
+	sshKeysMutex.Lock()
+	defer sshKeysMutex.Unlock()
+
+	name := server.StackUid
+	if server.PersonalKey {
+		name += "_pkey"
+	}
+	profile := currentProfileName()
+	storage := sshKeyStorage()
+
+	if info, err := readSshKeyInfo(profile, name); err == nil && info.Storage == storage && !info.expired(time.Now()) && sshKeyAvailable(*info) {
+		if debugMode {
+			fmt.Println("Found an existing SSH key for this server")
+		}
+		return info.serverKey(), nil
+	}
+
+	fmt.Println("Fetching SSH key...")
+	content, err := client.ServerKeyInformation(server.StackUid, server.Uid)
+	if err != nil {
+		return serverKey{}, err
+	}
+
+	info := sshKeyInfo{
+		Name:       name,
+		Profile:    profile,
+		StackUid:   server.StackUid,
+		ServerUid:  server.Uid,
+		ServerName: server.Name,
+		Personal:   server.PersonalKey,
+		Storage:    storage,
+		FetchedAt:  time.Now().UTC(),
+	}
+	if maxAge := sshKeyMaxAge(); maxAge > 0 {
+		expiresAt := info.FetchedAt.Add(maxAge)
+		info.ExpiresAt = &expiresAt
+	}
+
+	if storage == sshKeyStorageAgent {
+		if err := addKeyToAgent(info, content); err != nil {
+			return serverKey{}, err
+		}
+		// an earlier key file isn't needed anymore
+		os.Remove(info.keyFile())
+	} else if err := writeFileAtomically(info.keyFile(), []byte(content), 0600); err != nil {
+		return serverKey{}, err
+	}
+
+	if err := writeSshKeyInfo(info); err != nil {
+		return serverKey{}, err
+	}
+	return info.serverKey(), nil
+}
+
+func sshKeyAvailable(info sshKeyInfo) bool {
+	if info.Storage != sshKeyStorageAgent {
+		exists, _ := fileExists(info.keyFile())
+		return exists
+	}
+	keyring, conn, err := connectToAgent()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_, err = agentKeyByComment(keyring, info.agentComment())
+	return err == nil
+}
+
+func connectToAgent() (agent.ExtendedAgent, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, fmt.Errorf("no SSH agent running ($SSH_AUTH_SOCK is not set)")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// adds the key to the SSH agent, which removes it again when it expires
+func addKeyToAgent(info sshKeyInfo, content string) error {
+	key, err := ssh.ParseRawPrivateKey([]byte(content))
+	if err != nil {
+		return err
+	}
+	keyring, conn, err := connectToAgent()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	removeAgentKey(keyring, info.agentComment())
+	added := agent.AddedKey{PrivateKey: key, Comment: info.agentComment()}
+	if info.ExpiresAt != nil {
+		added.LifetimeSecs = uint32(info.ExpiresAt.Sub(info.FetchedAt).Seconds())
+	}
+	return keyring.Add(added)
+}
+
+func agentKeyByComment(keyring agent.Agent, comment string) (*agent.Key, error) {
+	keys, err := keyring.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Comment == comment {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("the key %s is not in the SSH agent", comment)
+}
+
+func removeAgentKey(keyring agent.Agent, comment string) {
+	if key, err := agentKeyByComment(keyring, comment); err == nil {
+		keyring.Remove(key)
+	}
+}
+
+// returns the signer of the key with the given comment in the SSH agent
+func agentSigner(keyring agent.Agent, comment string) (ssh.Signer, error) {
+	key, err := agentKeyByComment(keyring, comment)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := keyring.Signers()
+	if err != nil {
+		return nil, err
+	}
+	for _, signer := range signers {
+		if string(signer.PublicKey().Marshal()) == string(key.Blob) {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("the key %s is not in the SSH agent", comment)
+}
+
+func readSshKeyInfo(profile string, name string) (*sshKeyInfo, error) {
+	data, err := ioutil.ReadFile(sshKeyInfo{Profile: profile, Name: name}.infoFile())
+	if err != nil {
+		return nil, err
+	}
+	var info sshKeyInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func writeSshKeyInfo(info sshKeyInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(info.infoFile(), data, 0600)
+}
+
+// returns the stack SSH keys of all profiles
+func readSshKeyInfos() ([]sshKeyInfo, error) {
+	files, err := filepath.Glob(filepath.Join(sshKeysDir(), "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var result []sshKeyInfo
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		info, err := readSshKeyInfo(filepath.Base(filepath.Dir(file)), name)
+		if err != nil {
+			printWarning("Unable to read %s: %s", file, err.Error())
+			continue
+		}
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Profile != result[j].Profile {
+			return result[i].Profile < result[j].Profile
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// removes a stack SSH key, wherever it is kept
+func removeSshKey(info sshKeyInfo) error {
+	if info.Storage == sshKeyStorageAgent {
+		if keyring, conn, err := connectToAgent(); err == nil {
+			removeAgentKey(keyring, info.agentComment())
+			conn.Close()
+		}
+	}
+	if err := os.Remove(info.keyFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(info.infoFile())
+}
+
+// keys written to ~/.ssh by earlier versions of cx, named after the uid of their stack
+var legacySshKeyName = regexp.MustCompile(`^cx_[0-9a-f]{32}(_pkey)?$`)
+
+func legacySshKeyFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(homePath(), ".ssh", "cx_*"))
+	var result []string
+	for _, file := range files {
+		if legacySshKeyName.MatchString(filepath.Base(file)) {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+func runSshKeysList(c *cli.Context) {
+	infos, err := readSshKeyInfos()
+	must(err)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	listRec(w, "PROFILE", "STACK", "SERVER", "STORAGE", "FETCHED", "EXPIRES", "STATUS")
+	now := time.Now()
+	for _, info := range infos {
+		expires := "never"
+		if info.ExpiresAt != nil {
+			expires = prettyTime{*info.ExpiresAt}.String()
+		}
+		status := "ok"
+		if info.expired(now) {
+			status = "expired"
+		} else if !sshKeyAvailable(info) {
+			status = "missing"
+		}
+		key := info.StackUid
+		if info.Personal {
+			key += " (personal)"
+		}
+		listRec(w, info.Profile, key, info.ServerName, info.Storage, prettyTime{info.FetchedAt}, expires, status)
+	}
+	// the warning goes after the list
+	w.Flush()
+
+	if legacy := legacySshKeyFiles(); len(legacy) > 0 {
+		printWarning("%d key(s) written to ~/.ssh by earlier versions of cx. Use 'cx ssh keys purge' to remove them", len(legacy))
+	}
+}
+
+func runSshKeysPurge(c *cli.Context) {
+	infos, err := readSshKeyInfos()
+	must(err)
+
+	now := time.Now()
+	count := 0
+	for _, info := range infos {
+		if c.Bool("expired") && !info.expired(now) {
+			continue
+		}
+		if c.String("profile-name") != "" && info.Profile != c.String("profile-name") {
+			continue
+		}
+		must(removeSshKey(info))
+		count++
+	}
+	if !c.Bool("expired") && c.String("profile-name") == "" {
+		for _, file := range legacySshKeyFiles() {
+			fmt.Printf("Removing %s\n", file)
+			must(os.Remove(file))
+			count++
+		}
+	}
+
+	fmt.Printf("Removed %d key(s)\n", count)
+}
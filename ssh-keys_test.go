@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stack SSH keys", func() {
+	var restoreHome func()
+	var info sshKeyInfo
+
+	BeforeEach(func() {
+		restoreHome = useTempHome()
+		expiresAt := time.Now().Add(time.Hour)
+		info = sshKeyInfo{Name: "stack-uid", Profile: "default", StackUid: "stack-uid", ServerUid: "server-uid", ServerName: "lion", Storage: sshKeyStorageFile, FetchedAt: time.Now(), ExpiresAt: &expiresAt}
+		Expect(writeFileAtomically(info.keyFile(), []byte("key"), 0600)).To(Succeed())
+		Expect(writeSshKeyInfo(info)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		restoreHome()
+	})
+
+	It("should keep keys under the profile with owner only access", func() {
+		Expect(info.keyFile()).To(Equal(filepath.Join(os.Getenv("HOME"), ".cloud66", "ssh-keys", "default", "stack-uid")))
+		stat, err := os.Stat(info.keyFile())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		files, err := ioutil.ReadDir(filepath.Dir(info.keyFile()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+	})
+
+	It("should reuse keys until they expire", func() {
+		key, err := prepareLocalSshKey(cloud66.Server{StackUid: "stack-uid", Uid: "server-uid"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(Equal(serverKey{File: info.keyFile()}))
+
+		infos, err := readSshKeyInfos()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+		Expect(infos[0].ServerName).To(Equal("lion"))
+		Expect(infos[0].expired(time.Now())).To(BeFalse())
+		Expect(infos[0].expired(time.Now().Add(2 * time.Hour))).To(BeTrue())
+	})
+
+	It("should remove keys and their metadata", func() {
+		Expect(removeSshKey(info)).To(Succeed())
+		infos, err := readSshKeyInfos()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(BeEmpty())
+		exists, _ := fileExists(info.keyFile())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("should parse max ages", func() {
+		maxAge, err := parseSshKeyMaxAge("12h")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(maxAge).To(Equal(12 * time.Hour))
+		maxAge, err = parseSshKeyMaxAge("0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(maxAge).To(BeZero())
+		_, err = parseSshKeyMaxAge("a day")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should only take keys named after a stack uid as left by earlier versions", func() {
+		sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
+		Expect(os.MkdirAll(sshDir, 0700)).To(Succeed())
+		for _, name := range []string{"cx_0123456789abcdef0123456789abcdef", "cx_0123456789abcdef0123456789abcdef_pkey", "cx_deploy", "cx_rsa.pub", "id_rsa"} {
+			Expect(ioutil.WriteFile(filepath.Join(sshDir, name), []byte("key"), 0600)).To(Succeed())
+		}
+
+		Expect(legacySshKeyFiles()).To(ConsistOf(
+			filepath.Join(sshDir, "cx_0123456789abcdef0123456789abcdef"),
+			filepath.Join(sshDir, "cx_0123456789abcdef0123456789abcdef_pkey"),
+		))
+	})
+})
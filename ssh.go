@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"runtime"
-	"strings"
 
 	"github.com/cloud66-oss/cloud66"
 	"github.com/cloud66/cli"
@@ -13,24 +12,28 @@ import (
 var cmdSsh = &Command{
 	Name:  "ssh",
 	Run:   runSsh,
-	Build: buildBasicCommand,
+	Build: buildSsh,
 	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "gateway-key",
-			Usage: "path to the bastion server key",
-			Value: "",
-		},
+		gatewayKeyFlag(),
 		cli.BoolFlag{
 			Name:  "v",
-			Usage: "run ssh with verbose flag",
+			Usage: "show debug messages of the SSH connection",
 		},
 		cli.BoolFlag{
 			Name:  "vv",
-			Usage: "run ssh with very verbose flag",
+			Usage: "show more debug messages of the SSH connection",
 		},
 		cli.BoolFlag{
 			Name:  "vvv",
-			Usage: "run ssh with very very verbose flag",
+			Usage: "show all debug messages of the SSH connection",
+		},
+		cli.StringFlag{
+			Name:  "stack,s",
+			Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+		},
+		cli.StringFlag{
+			Name:  "environment,e",
+			Usage: "full or partial environment name",
 		},
 	},
 	NeedsStack: true,
@@ -47,7 +50,15 @@ If a role is specified the command will connect to the first server with that ro
 
 Names are case insensitive and will work with the starting characters as well.
 
-You should provide a key to your bastion server if it is deployed with a deploy gateway.
+Servers deployed behind a deploy gateway (bastion server) are connected to through it, using the key given with
+--gateway-key or the key set for the gateway in the profile ('cx config update --gateway-key name=path').
+If the gateway is closed, cx offers to open it while it runs, and closes it again on exit.
+
+The host key of each server is pinned in ~/.cloud66/known_hosts the first time cx connects to it, and connections
+fail if it changes. If the server was rebuilt, use 'cx ssh forget-host' to remove the pin.
+
+Stack SSH keys are kept under ~/.cloud66/ssh-keys, or in the ssh-agent, and fetched again once they expire. Use
+'cx ssh keys list' and 'cx ssh keys purge' to manage them.
 
 This command is only supported on Linux and OS X.
 
@@ -56,7 +67,76 @@ $ cx ssh -s mystack lion
 $ cx ssh -s mystack 52.65.34.98
 $ cx ssh -s mystack web
 $ cx ssh --gateway-key ~/.ssh/bastion_key  -s mystack db
+$ cx ssh forget-host -s mystack lion
+$ cx ssh keys list
+`,
+}
+
+func buildSsh() cli.Command {
+	base := buildBasicCommand()
+	base.Subcommands = []cli.Command{
+		cli.Command{
+			Name:   "forget-host",
+			Action: runSshForgetHost,
+			Usage:  "removes the pinned host keys of servers",
+			Description: `Removes the pinned host keys of servers from ~/.cloud66/known_hosts, so the next connection pins their new keys.
+
+Use this after a server was rebuilt and cx refuses to connect to it because its host key has changed. Only do
+so if you know why the key has changed: a changed key can also mean someone is intercepting the connection.
+
+Servers can be given by name, role or address with --stack, or by address without it (for gateways and servers
+given to register-server).
+
+Examples:
+$ cx ssh forget-host -s mystack lion
+$ cx ssh forget-host 52.65.34.98
+`,
+		},
+		cli.Command{
+			Name:  "keys",
+			Usage: "manages the stack SSH keys fetched by cx",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:   "list",
+					Action: runSshKeysList,
+					Usage:  "lists the stack SSH keys fetched by cx",
+					Description: `Lists the stack SSH keys fetched by cx for all profiles, where they are kept and when they expire.
+
+Keys are fetched again once expired. Set how long they are kept with 'cx config update --ssh-key-max-age'.
+
+Examples:
+$ cx ssh keys list
+PROFILE  STACK                             SERVER  STORAGE  FETCHED       EXPIRES       STATUS
+default  5999b763474b2c2a1b7ad3e0bc2b1d71  lion    file     Oct 18 09:12  Oct 19 09:12  ok
+`,
+				},
+				cli.Command{
+					Name:   "purge",
+					Action: runSshKeysPurge,
+					Usage:  "removes the stack SSH keys fetched by cx",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "expired",
+							Usage: "only removes expired keys",
+						},
+						cli.StringFlag{
+							Name:  "profile-name",
+							Usage: "only removes the keys of the given profile",
+						},
+					},
+					Description: `Removes the stack SSH keys fetched by cx, from disk and from the ssh-agent. Keys written to ~/.ssh by
+earlier versions of cx, named cx_<stack uid>, are listed and removed too, unless --expired or --profile-name is used.
+
+Examples:
+$ cx ssh keys purge
+$ cx ssh keys purge --expired
+$ cx ssh keys purge --profile-name staging
 `,
+				},
+			},
+		},
+	}
+	return base
 }
 
 func runSsh(c *cli.Context) {
@@ -68,7 +148,7 @@ func runSsh(c *cli.Context) {
 	stack := mustStack(c)
 
 	if len(c.Args()) != 1 {
-		cli.ShowCommandHelp(c, "ssh")
+		cli.ShowSubcommandHelp(c)
 		os.Exit(2)
 	}
 
@@ -89,21 +169,7 @@ func runSsh(c *cli.Context) {
 		printFatal("Server '" + serverName + "' not found")
 	}
 
-	flagGatewayKey := ""
-	if server.HasDeployGateway {
-		if c.IsSet("gateway-key") {
-			flagGatewayKey = c.String("gateway-key")
-			if len(server.DeployGatewayAddress) == 0 {
-				printFatal("Can not find the address of gateway server")
-			}
-			if len(server.DeployGatewayUsername) == 0 {
-				printFatal("Can not find the username of gateway server")
-			}
-		} else {
-			cli.ShowCommandHelp(c, "ssh")
-			printFatal("This server deployed behind the gateway. You need to specify the key for the bastion server")
-		}
-	}
+	flagGatewayKey = c.String("gateway-key")
 
 	verbosity := 0
 	if c.Bool("v") {
@@ -116,15 +182,15 @@ func runSsh(c *cli.Context) {
 
 	fmt.Printf("Server: %s\n", server.Name)
 
-	err = sshToServer(*server, flagGatewayKey, verbosity)
+	err = sshToServer(*server, verbosity)
 	if err != nil {
 		printError("If you're having issues connecting to your server, you may find some help at https://help.cloud66.com/maestro/how-to-guides/deployment/ssh-to-server.html")
 		printFatal(err.Error())
 	}
 }
 
-func sshToServer(server cloud66.Server, gatewayKey string, verbosity int) error {
-	sshFile, err := prepareLocalSshKey(server)
+func sshToServer(server cloud66.Server, verbosity int) error {
+	sshKey, err := prepareLocalSshKey(server)
 	must(err)
 
 	// open the firewall
@@ -138,45 +204,51 @@ func sshToServer(server cloud66.Server, gatewayKey string, verbosity int) error
 
 	fmt.Printf("Connecting to %s (%s)...\n", server.Name, server.Address)
 
-	vflag := ""
-	if verbosity == 1 {
-		vflag = "-v"
-	} else if verbosity == 2 {
-		vflag = "-vv"
-	} else if verbosity == 3 {
-		vflag = "-vvv"
+	target, err := serverSSHTarget(server, sshKey)
+	if err != nil {
+		return err
+	}
+	target.Verbosity = verbosity
+	target.ForwardAgent = true
+	if target.Gateway != nil {
+		target.Gateway.Verbosity = verbosity
+	}
+
+	conn, err := dialSSH(target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Run("", true)
+}
+
+func runSshForgetHost(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		printFatal("No hosts given. Use server names with --stack, or addresses")
 	}
 
-	if server.HasDeployGateway {
-		tags := []string{
-			"ssh",
-			"-o", "ProxyCommand='ssh " + server.DeployGatewayUsername + "@" + server.DeployGatewayAddress + " -i  " + gatewayKey + "  nc  " + server.Address + " 22' ",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "CheckHostIP=no",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "LogLevel=QUIET",
-			"-o", "IdentitiesOnly=yes",
-			"-A",
-			"-p", "22",
-			vflag,
-			server.UserName + "@" + server.Address,
-			"-i", sshFile,
+	var uids []string
+	addresses := c.Args()
+	if c.String("stack") != "" {
+		stack := mustStack(c)
+		servers, err := client.Servers(stack.Uid)
+		must(err)
+		for _, name := range c.Args() {
+			server, err := findServer(servers, name)
+			must(err)
+			if server == nil {
+				printFatal("Server '%s' not found", name)
+			}
+			uids = append(uids, server.Uid)
+			addresses = append(addresses, server.Address)
 		}
-		return startProgram("bash", []string{
-			"-c", strings.Join(tags, " "),
-		})
-	} else {
-		return startProgram("ssh", []string{
-			server.UserName + "@" + server.Address,
-			"-i", sshFile,
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "CheckHostIP=no",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "LogLevel=QUIET",
-			"-o", "IdentitiesOnly=yes",
-			"-A",
-			"-p", "22",
-			vflag,
-		})
 	}
+
+	count, err := forgetKnownHosts(uids, addresses)
+	must(err)
+	if count == 0 {
+		fmt.Println("No pinned host keys found")
+		return
+	}
+	fmt.Printf("Removed %d pinned host key(s)\n", count)
 }
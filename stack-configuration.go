@@ -133,7 +133,10 @@ func runStackConfigurationUpload(c *cli.Context) {
 	asyncRes, err := client.ConfigurationUpload(stack.Uid, theType, commitMessage, body, mustApply)
 	must(err)
 
-	genericRes, err := client.WaitStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, true)
+	genericRes, err := waitStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, true)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	must(err)
 
 	var successMessage string
@@ -174,7 +177,10 @@ func runStackConfigurationApply(c *cli.Context) {
 
 	asyncRes, err := client.ConfigurationApply(stack.Uid, theType)
 	must(err)
-	genericRes, err := client.WaitStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, true)
+	genericRes, err := waitStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, true)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	must(err)
 	successMessage := "Configuration applied"
 	printGenericResponseCustom(*genericRes, successMessage, "")
@@ -98,8 +98,9 @@ func startCreateStack(name, environment, serviceYaml, manifestYaml string, targe
 	return &asyncRes.Id, err
 }
 
+// the build can only start once the stack is analysed, so this waits even with --no-wait
 func endCreateStack(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, false)
+	return waitForStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, false)
 }
 
 func initiateStackBuild(stackUid string) error {
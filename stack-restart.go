@@ -15,6 +15,9 @@ func runRestart(c *cli.Context) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endRestart(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -30,5 +33,5 @@ func startRestart(stackUid string) (*int, error) {
 }
 
 func endRestart(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 5*time.Second, 20*time.Minute, true)
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// stackDiffSection is the difference of one part of two stacks, like their environment variables or a configuration file
+type stackDiffSection struct {
+	Name        string   `json:"name"`
+	ChangedKeys []string `json:"changed_keys,omitempty"`
+	Diff        string   `json:"diff"`
+}
+
+type stackDiffResult struct {
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Different bool               `json:"different"`
+	Sections  []stackDiffSection `json:"sections"`
+}
+
+// exit codes of stacks diff, like diff(1): 1 means there are differences, so errors exit with 2
+const (
+	stackDiffDifferentExitCode = 1
+	stackDiffErrorExitCode     = 2
+)
+
+func runStackDiff(c *cli.Context) {
+	fatalExitCode = stackDiffErrorExitCode
+	against := c.String("against")
+	if against == "" {
+		printFatal("Please use --against to specify the stack to compare with")
+	}
+	output := c.String("output")
+	if output != "" && output != "text" && output != "json" {
+		printFatal("Invalid output format %q. Use text or json", output)
+	}
+
+	stack := mustStack(c)
+	other, err := client.StackInfoWithEnvironment(against, c.String("against-environment"))
+	must(err)
+
+	ignore := splitCommaValues(c.StringSlice("ignore"))
+	for _, pattern := range ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			printFatal("Invalid --ignore pattern %q", pattern)
+		}
+	}
+
+	fromName := stackDiffName(*stack)
+	toName := stackDiffName(*other)
+	result := stackDiffResult{From: fromName, To: toName}
+
+	from, err := stackDiffSnapshot(*stack, c.Bool("include-readonly"))
+	must(err)
+	to, err := stackDiffSnapshot(*other, c.Bool("include-readonly"))
+	must(err)
+
+	for _, name := range stackDiffSectionNames(from, to) {
+		if matchesAnyGlob(ignore, name) {
+			continue
+		}
+		section := diffStackSection(name, fromName, toName, from[name], to[name], ignore)
+		if section.Diff != "" {
+			result.Sections = append(result.Sections, section)
+		}
+	}
+	result.Different = len(result.Sections) > 0
+
+	if output == "json" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	} else if !result.Different {
+		fmt.Printf("No differences between %s and %s\n", fromName, toName)
+	} else {
+		for _, section := range result.Sections {
+			fmt.Print(section.Diff)
+		}
+	}
+
+	if result.Different {
+		os.Exit(stackDiffDifferentExitCode)
+	}
+}
+
+// stackDiffContent is one part of a stack to compare. Key/value parts (env vars and settings)
+// are compared by key so they can be ignored individually, while files are compared as text
+type stackDiffContent struct {
+	Values map[string]string
+	Body   string
+	IsText bool
+}
+
+// fetches everything compared by stacks diff. Secret values are masked straight away
+func stackDiffSnapshot(stack cloud66.Stack, includeReadonly bool) (map[string]stackDiffContent, error) {
+	result := make(map[string]stackDiffContent)
+
+	envVars, err := client.StackEnvVars(stack.Uid)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, envVar := range envVars {
+		if envVar.Key == "" || (envVar.Readonly && !includeReadonly) {
+			continue
+		}
+		values[envVar.Key] = maskKeyValue(envVar.Key, envVarValue(envVar))
+	}
+	result["env-vars"] = stackDiffContent{Values: values}
+
+	settings, err := client.StackSettings(stack.Uid)
+	if err != nil {
+		return nil, err
+	}
+	values = make(map[string]string)
+	for _, setting := range settings {
+		if setting.Readonly && !includeReadonly {
+			continue
+		}
+		value := ""
+		if setting.Value != nil {
+			value = fmt.Sprint(setting.Value)
+		}
+		values[setting.Key] = maskKeyValue(setting.Key, value)
+	}
+	result["settings"] = stackDiffContent{Values: values}
+
+	configurations, err := client.ConfigurationList(stack.Uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, configuration := range configurations {
+		result["configuration/"+configuration.Type] = stackDiffContent{Body: maskSecretsInText(configuration.Body), IsText: true}
+	}
+
+	// service.yml only exists on Maestro stacks, and stacks without any version of a file don't have it.
+	// Any other error fails the comparison, so it isn't mistaken for a missing file
+	if stack.Framework == "docker" {
+		versions, err := client.ServiceYamlList(stack.Uid, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 {
+			serviceYaml, err := client.ServiceYamlInfo(stack.Uid, "latest")
+			if err != nil {
+				return nil, err
+			}
+			result["service.yml"] = stackDiffContent{Body: maskSecretsInText(serviceYaml.Body), IsText: true}
+		}
+	}
+	versions, err := client.ManifestYamlList(stack.Uid, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		manifestYaml, err := client.ManifestYamlInfo(stack.Uid, "latest")
+		if err != nil {
+			return nil, err
+		}
+		result["manifest.yml"] = stackDiffContent{Body: maskSecretsInText(manifestYaml.Body), IsText: true}
+	}
+
+	return result, nil
+}
+
+func diffStackSection(name string, fromName string, toName string, from stackDiffContent, to stackDiffContent, ignore []string) stackDiffSection {
+	section := stackDiffSection{Name: name}
+	if from.IsText || to.IsText {
+		section.Diff = unifiedDiff(fromName+"/"+name, toName+"/"+name, from.Body, to.Body)
+		return section
+	}
+
+	keys := make(map[string]bool)
+	for key := range from.Values {
+		keys[key] = true
+	}
+	for key := range to.Values {
+		keys[key] = true
+	}
+
+	var fromLines, toLines []string
+	for _, key := range sortedKeys(keys) {
+		if matchesAnyGlob(ignore, key) || matchesAnyGlob(ignore, name+"/"+key) {
+			continue
+		}
+		fromValue, inFrom := from.Values[key]
+		toValue, inTo := to.Values[key]
+		if inFrom != inTo || fromValue != toValue {
+			section.ChangedKeys = append(section.ChangedKeys, key)
+		}
+		if inFrom {
+			fromLines = append(fromLines, key+"="+fromValue)
+		}
+		if inTo {
+			toLines = append(toLines, key+"="+toValue)
+		}
+	}
+
+	section.Diff = unifiedDiff(fromName+"/"+name, toName+"/"+name, strings.Join(fromLines, "\n"), strings.Join(toLines, "\n"))
+	return section
+}
+
+func stackDiffSectionNames(from map[string]stackDiffContent, to map[string]stackDiffContent) []string {
+	names := make(map[string]bool)
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	return sortedKeys(names)
+}
+
+func stackDiffName(stack cloud66.Stack) string {
+	return stack.Name + "(" + stack.Environment + ")"
+}
+
+func matchesAnyGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var result []string
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
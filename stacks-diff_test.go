@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a single page response of a paginated list endpoint
+func stackDiffListResponse(items string) string {
+	return `{"response":[` + items + `],"pagination":{"previous":null,"next":1,"current":1,"per_page":30,"count":1,"pages":1}}`
+}
+
+var _ = Describe("Stacks diff", func() {
+	var restoreClient func()
+
+	BeforeEach(func() {
+		gock.Off()
+		restoreClient = MockApiClient()
+
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/environments.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(`{"key":"RAILS_ENV","value":"production"}`))
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/settings.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(""))
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/configuration.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(""))
+	})
+
+	AfterEach(func() {
+		gock.Off()
+		restoreClient()
+	})
+
+	It("should leave out the files a stack doesn't have", func() {
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/service_yaml.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(""))
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/manifest_yaml.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(""))
+
+		snapshot, err := stackDiffSnapshot(cloud66.Stack{Uid: "abc", Framework: "docker"}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).NotTo(HaveKey("service.yml"))
+		Expect(snapshot).NotTo(HaveKey("manifest.yml"))
+		Expect(snapshot["env-vars"].Values).To(HaveKeyWithValue("RAILS_ENV", "production"))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("should fail when the files can't be fetched", func() {
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/service_yaml.json").
+			Reply(500).
+			BodyString(`{"error":"internal_error","error_description":"Something went wrong"}`)
+
+		_, err := stackDiffSnapshot(cloud66.Stack{Uid: "abc", Framework: "docker"}, false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not look for a service.yml on other stacks", func() {
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/manifest_yaml.json").
+			Reply(200).
+			BodyString(stackDiffListResponse(`{"uid":"1"}`))
+		gock.New("https://app.cloud66.com/api/3").
+			Get("/stacks/abc/manifest_yaml/latest.json").
+			Reply(200).
+			BodyString(`{"response":{"uid":"1","body":"production:\n  rails: {}\n"}}`)
+
+		snapshot, err := stackDiffSnapshot(cloud66.Stack{Uid: "abc", Framework: "rails"}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).NotTo(HaveKey("service.yml"))
+		Expect(snapshot["manifest.yml"].Body).To(Equal("production:\n  rails: {}\n"))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+})
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// the allowlist file of a desired state directory, with a glob pattern of resources to ignore on each line
+const stackDriftIgnoreFile = ".driftignore"
+
+const (
+	driftChanged    = "changed"
+	driftMissing    = "missing"
+	driftUnexpected = "unexpected"
+)
+
+// stackDrift is a resource of the stack which is different from its desired state
+type stackDrift struct {
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+type stackDriftReport struct {
+	Stack       string       `json:"stack"`
+	Environment string       `json:"environment"`
+	Dir         string       `json:"dir"`
+	CheckedAt   time.Time    `json:"checked_at"`
+	Drifted     bool         `json:"drifted"`
+	Checked     []string     `json:"checked"`
+	Drift       []stackDrift `json:"drift"`
+
+	ignore []string
+	reveal secretReveal
+}
+
+func runStackDrift(c *cli.Context) {
+	// 1 means drift, like with stacks diff
+	fatalExitCode = stackDiffErrorExitCode
+	dir := c.String("dir")
+	if dir == "" {
+		printFatal("No directory provided. Please use --dir to specify the desired state")
+	}
+	dir = expandPath(dir)
+	output := c.String("output")
+	if output != "" && output != "text" && output != "json" && output != "junit" {
+		printFatal("Invalid output format %q. Use text, json or junit", output)
+	}
+
+	ignore := splitCommaValues(c.StringSlice("ignore"))
+	fileIgnore, err := readDriftIgnoreFile(filepath.Join(dir, stackDriftIgnoreFile))
+	must(err)
+	ignore = append(ignore, fileIgnore...)
+	for _, pattern := range ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			printFatal("Invalid ignore pattern %q", pattern)
+		}
+	}
+
+	stack := mustStack(c)
+	report := &stackDriftReport{
+		Stack:       stack.Name,
+		Environment: stack.Environment,
+		Dir:         dir,
+		CheckedAt:   time.Now().UTC(),
+		Checked:     make([]string, 0),
+		Drift:       make([]stackDrift, 0),
+		ignore:      ignore,
+		reveal:      newSecretReveal(c),
+	}
+	checkStackDrift(*stack, dir, report)
+	report.Drifted = len(report.Drift) > 0
+
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	case "junit":
+		data, err := xml.MarshalIndent(report.junit(), "", "  ")
+		must(err)
+		fmt.Println(xml.Header + string(data))
+	default:
+		printStackDrift(report)
+	}
+
+	if report.Drifted {
+		os.Exit(stackDiffDifferentExitCode)
+	}
+}
+
+func checkStackDrift(stack cloud66.Stack, dir string, report *stackDriftReport) {
+	if body, ok := readStackExportFile(dir, stackExportServiceYamlFile); ok && !report.ignored(stackExportServiceYamlFile) {
+		// stacks without any version don't have the file. Any other error fails the check
+		versions, err := client.ServiceYamlList(stack.Uid, false)
+		must(err)
+		if len(versions) == 0 {
+			report.checkFile(stackExportServiceYamlFile, body, nil)
+		} else {
+			current, err := client.ServiceYamlInfo(stack.Uid, "latest")
+			must(err)
+			report.checkFile(stackExportServiceYamlFile, body, &current.Body)
+		}
+	}
+	if body, ok := readStackExportFile(dir, stackExportManifestYamlFile); ok && !report.ignored(stackExportManifestYamlFile) {
+		versions, err := client.ManifestYamlList(stack.Uid, false)
+		must(err)
+		if len(versions) == 0 {
+			report.checkFile(stackExportManifestYamlFile, body, nil)
+		} else {
+			current, err := client.ManifestYamlInfo(stack.Uid, "latest")
+			must(err)
+			report.checkFile(stackExportManifestYamlFile, body, &current.Body)
+		}
+	}
+
+	if entries, err := ioutil.ReadDir(filepath.Join(dir, stackExportConfigurationsDir)); err == nil {
+		configurations, err := client.ConfigurationList(stack.Uid)
+		must(err)
+		live := make(map[string]string)
+		for _, configuration := range configurations {
+			live[configuration.Type] = configuration.Body
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			resource := "configuration/" + entry.Name()
+			body, _ := readStackExportFile(dir, filepath.Join(stackExportConfigurationsDir, entry.Name()))
+			current, ok := live[entry.Name()]
+			if !ok {
+				report.add(stackDrift{Resource: resource, Kind: driftMissing})
+				continue
+			}
+			report.compareText(resource, body, current)
+		}
+	}
+
+	if pairs := readStackExportEnvVars(dir); pairs != nil {
+		envVars, err := client.StackEnvVars(stack.Uid)
+		must(err)
+		report.checkEnvVars(envVars, pairs)
+	}
+
+	var desired settingsFile
+	if err := readStackExportYaml(dir, stackExportSettingsFile, &desired); err == nil {
+		report.checkSettings(stack, &desired)
+	} else if !os.IsNotExist(err) {
+		printFatal("%s: %s", stackExportSettingsFile, err.Error())
+	}
+}
+
+// checks a file of the desired state against the latest version of the stack, which is nil if the stack doesn't have one
+func (r *stackDriftReport) checkFile(resource string, body string, current *string) {
+	if current == nil {
+		if r.ignored(resource) {
+			return
+		}
+		r.Checked = append(r.Checked, resource)
+		r.add(stackDrift{Resource: resource, Kind: driftMissing})
+		return
+	}
+	r.compareText(resource, body, *current)
+}
+
+func (r *stackDriftReport) compareText(resource string, expected string, actual string) {
+	if r.ignored(resource) {
+		return
+	}
+	r.Checked = append(r.Checked, resource)
+	diff := unifiedDiff("desired/"+resource, "live/"+resource, expected, actual)
+	if diff == "" {
+		return
+	}
+	if !r.reveal.all {
+		diff = maskSecretsInText(diff)
+	}
+	r.add(stackDrift{Resource: resource, Kind: driftChanged, Diff: diff})
+}
+
+func (r *stackDriftReport) compareValue(resource string, key string, expected string, actual interface{}, exists bool) {
+	if r.ignored(resource) {
+		return
+	}
+	r.Checked = append(r.Checked, resource)
+	switch {
+	case !exists:
+		r.add(stackDrift{Resource: resource, Kind: driftMissing, Expected: r.value(key, expected)})
+	case !sameSettingValue(actual, expected):
+		r.add(stackDrift{Resource: resource, Kind: driftChanged, Expected: r.value(key, expected), Actual: r.value(key, settingValueString(actual))})
+	}
+}
+
+// live variables which are not in the desired state are drift too, unless they're readonly
+func (r *stackDriftReport) checkEnvVars(envVars []cloud66.StackEnvVar, pairs []envVarPair) {
+	live := make(map[string]cloud66.StackEnvVar)
+	for _, envVar := range envVars {
+		live[envVar.Key] = envVar
+	}
+	desired := make(map[string]bool)
+	for _, pair := range pairs {
+		desired[pair.Key] = true
+		envVar, exists := live[pair.Key]
+		if exists && envVar.Readonly {
+			continue
+		}
+		r.compareValue("env-vars/"+pair.Key, pair.Key, pair.Value, envVarValue(envVar), exists)
+	}
+
+	sort.Sort(envVarsByName(envVars))
+	for _, envVar := range envVars {
+		resource := "env-vars/" + envVar.Key
+		if envVar.Key == "" || envVar.Readonly || desired[envVar.Key] || r.ignored(resource) {
+			continue
+		}
+		r.Checked = append(r.Checked, resource)
+		r.add(stackDrift{Resource: resource, Kind: driftUnexpected, Actual: r.value(envVar.Key, envVarValue(envVar))})
+	}
+}
+
+func (r *stackDriftReport) checkSettings(stack cloud66.Stack, desired *settingsFile) {
+	if len(desired.Stack) > 0 {
+		settings, err := client.StackSettings(stack.Uid)
+		must(err)
+		r.compareSettings("settings/", settings, desired.Stack)
+	}
+
+	if len(desired.Roles) == 0 && len(desired.Servers) == 0 {
+		return
+	}
+	servers, err := client.Servers(stack.Uid)
+	must(err)
+	targets, problems := serverSettingTargets(servers, desired)
+	for _, problem := range problems {
+		r.add(stackDrift{Resource: "servers", Kind: driftMissing, Expected: problem})
+	}
+	for _, server := range servers {
+		wanted, ok := targets[server.Uid]
+		if !ok {
+			continue
+		}
+		settings, err := client.ServerSettings(stack.Uid, server.Uid)
+		must(err)
+		r.compareSettings("servers/"+server.Name+"/", settings, wanted)
+	}
+}
+
+func (r *stackDriftReport) compareSettings(prefix string, settings []cloud66.StackSetting, desired map[string]interface{}) {
+	live := make(map[string]cloud66.StackSetting)
+	for _, setting := range settings {
+		live[setting.Key] = setting
+	}
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		setting, exists := live[key]
+		if exists && setting.Readonly {
+			continue
+		}
+		r.compareValue(prefix+key, key, settingValueString(desired[key]), setting.Value, exists)
+	}
+}
+
+func (r *stackDriftReport) add(drift stackDrift) {
+	r.Drift = append(r.Drift, drift)
+}
+
+// resources are ignored by their full name (ie. env-vars/RAILS_ENV), their section (ie. settings) or their last part (ie. RAILS_ENV)
+func (r *stackDriftReport) ignored(resource string) bool {
+	if matchesAnyGlob(r.ignore, resource) {
+		return true
+	}
+	if idx := strings.Index(resource, "/"); idx != -1 && matchesAnyGlob(r.ignore, resource[:idx]) {
+		return true
+	}
+	return matchesAnyGlob(r.ignore, path.Base(resource))
+}
+
+func (r *stackDriftReport) value(key string, value string) string {
+	if r.reveal.reveals(key) {
+		return value
+	}
+	return maskKeyValue(key, value)
+}
+
+func printStackDrift(report *stackDriftReport) {
+	if !report.Drifted {
+		fmt.Printf("No drift: %d resource(s) of %s match %s\n", len(report.Checked), report.Stack, report.Dir)
+		return
+	}
+
+	for _, drift := range report.Drift {
+		switch {
+		case drift.Diff != "":
+			fmt.Printf("%s: %s\n%s", drift.Resource, drift.Kind, drift.Diff)
+		case drift.Kind == driftChanged:
+			fmt.Printf("%s: %s (expected %s, is %s)\n", drift.Resource, drift.Kind, drift.Expected, drift.Actual)
+		case drift.Kind == driftUnexpected:
+			fmt.Printf("%s: %s (is %s)\n", drift.Resource, drift.Kind, drift.Actual)
+		case drift.Expected != "":
+			fmt.Printf("%s: %s (expected %s)\n", drift.Resource, drift.Kind, drift.Expected)
+		default:
+			fmt.Printf("%s: %s\n", drift.Resource, drift.Kind)
+		}
+	}
+	fmt.Printf("%d of %d resource(s) of %s drifted from %s\n", len(report.Drift), len(report.Checked), report.Stack, report.Dir)
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// every checked resource is a test case, failing if it drifted
+func (r *stackDriftReport) junit() junitTestSuite {
+	suite := junitTestSuite{
+		Name:      fmt.Sprintf("cx stacks drift %s (%s)", r.Stack, r.Environment),
+		Timestamp: r.CheckedAt.Format(time.RFC3339),
+	}
+
+	drifted := make(map[string]stackDrift)
+	for _, drift := range r.Drift {
+		drifted[drift.Resource] = drift
+	}
+
+	resources := append([]string{}, r.Checked...)
+	for _, drift := range r.Drift {
+		if stringsIndex(resources, drift.Resource) == -1 {
+			resources = append(resources, drift.Resource)
+		}
+	}
+
+	for _, resource := range resources {
+		testCase := junitTestCase{Name: resource, ClassName: r.Stack}
+		if drift, ok := drifted[resource]; ok {
+			body := drift.Diff
+			if body == "" {
+				body = fmt.Sprintf("expected: %s\nactual: %s\n", drift.Expected, drift.Actual)
+			}
+			testCase.Failure = &junitFailure{Message: resource + " " + drift.Kind, Type: drift.Kind, Body: body}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Tests = len(suite.TestCases)
+	return suite
+}
+
+// reads an allowlist file. Blank lines and comments (#) are ignored. A missing file is an empty allowlist
+func readDriftIgnoreFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			result = append(result, line)
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stack drift", func() {
+	It("should ignore resources by name, section or last part", func() {
+		report := &stackDriftReport{ignore: []string{"env-vars/*_VERSION", "settings", "RAILS_ENV"}}
+		Expect(report.ignored("env-vars/APP_VERSION")).To(BeTrue())
+		Expect(report.ignored("settings/git.branch")).To(BeTrue())
+		Expect(report.ignored("env-vars/RAILS_ENV")).To(BeTrue())
+		Expect(report.ignored("env-vars/DATABASE_URL")).To(BeFalse())
+		Expect(report.ignored("servers/web1/server.name")).To(BeFalse())
+	})
+
+	It("should report every checked resource as a JUnit test case", func() {
+		report := &stackDriftReport{
+			Stack:   "mystack",
+			Checked: []string{"service.yml", "env-vars/RAILS_ENV"},
+			Drift:   []stackDrift{{Resource: "env-vars/RAILS_ENV", Kind: driftChanged, Expected: "production", Actual: "staging"}},
+		}
+		suite := report.junit()
+		Expect(suite.Tests).To(Equal(2))
+		Expect(suite.Failures).To(Equal(1))
+		Expect(suite.TestCases[0].Failure).To(BeNil())
+		Expect(suite.TestCases[1].Failure.Type).To(Equal(driftChanged))
+	})
+})
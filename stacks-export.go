@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"gopkg.in/go-yaml/yaml.v2"
+)
+
+// names of the files and directories of an exported stack
+const (
+	stackExportInfoFile          = "stack.yml"
+	stackExportServiceYamlFile   = "service.yml"
+	stackExportManifestYamlFile  = "manifest.yml"
+	stackExportConfigurationsDir = "configurations"
+	stackExportEnvVarsFile       = "env-vars.env"
+	stackExportSettingsFile      = "settings.yml"
+	stackExportJobsFile          = "jobs.yml"
+	stackExportSslFile           = "ssl-certificates.yml"
+)
+
+// files written for reference only. They are not applied by stacks import, which only warns about them
+var stackExportOnlyFiles = []string{stackExportJobsFile, stackExportSslFile}
+
+// the environment variable holding the passphrase of encrypted environment variables
+const stackExportPassphraseVar = "CX_EXPORT_PASSPHRASE"
+
+// stackExportInfo describes where an exported stack came from
+type stackExportInfo struct {
+	Name        string    `yaml:"name"`
+	Environment string    `yaml:"environment"`
+	Uid         string    `yaml:"uid"`
+	ExportedAt  time.Time `yaml:"exported_at"`
+	CxVersion   string    `yaml:"cx_version"`
+}
+
+type stackExportJob struct {
+	Name   string                 `yaml:"name"`
+	Type   string                 `yaml:"type"`
+	Cron   string                 `yaml:"cron"`
+	Params map[string]interface{} `yaml:"params,omitempty"`
+}
+
+// only the metadata of SSL certificates is exported. Their keys are never written
+type stackExportSslCertificate struct {
+	Name              string     `yaml:"name"`
+	Type              string     `yaml:"type"`
+	ServerNames       string     `yaml:"server_names"`
+	SSLTermination    bool       `yaml:"ssl_termination"`
+	SHA256Fingerprint string     `yaml:"sha256_fingerprint,omitempty"`
+	CAName            string     `yaml:"ca_name,omitempty"`
+	ExpiresAt         *time.Time `yaml:"expires_at,omitempty"`
+}
+
+func runStackExport(c *cli.Context) {
+	dir := c.String("dir")
+	if dir == "" {
+		printFatal("No directory provided. Please use --dir to specify where to export the stack to")
+	}
+	dir = expandPath(dir)
+
+	passphrase := ""
+	if c.Bool("encrypt") {
+		passphrase = os.Getenv(stackExportPassphraseVar)
+		if passphrase == "" {
+			printFatal("Please set the passphrase to encrypt the environment variables with in $%s", stackExportPassphraseVar)
+		}
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 && !c.Bool("overwrite") {
+		printFatal("%s is not empty. Use --overwrite to replace the exported files in it", dir)
+	}
+
+	stack := mustStack(c)
+	w := &stackExportWriter{dir: dir}
+
+	w.writeYaml(stackExportInfoFile, stackExportInfo{
+		Name:        stack.Name,
+		Environment: stack.Environment,
+		Uid:         stack.Uid,
+		ExportedAt:  time.Now().UTC(),
+		CxVersion:   VERSION,
+	})
+
+	// service.yml only exists on Maestro stacks, and stacks without any version of a file don't have it.
+	// Any other error fails the export, so an existing file isn't mistaken for a missing one and removed
+	hasServiceYaml := false
+	if stack.Framework == "docker" {
+		versions, err := client.ServiceYamlList(stack.Uid, false)
+		must(err)
+		hasServiceYaml = len(versions) > 0
+	}
+	if hasServiceYaml {
+		serviceYaml, err := client.ServiceYamlInfo(stack.Uid, "latest")
+		must(err)
+		w.write(stackExportServiceYamlFile, serviceYaml.Body)
+	} else {
+		w.remove(stackExportServiceYamlFile)
+	}
+	versions, err := client.ManifestYamlList(stack.Uid, false)
+	must(err)
+	if len(versions) > 0 {
+		manifestYaml, err := client.ManifestYamlInfo(stack.Uid, "latest")
+		must(err)
+		w.write(stackExportManifestYamlFile, manifestYaml.Body)
+	} else {
+		w.remove(stackExportManifestYamlFile)
+	}
+
+	configurations, err := client.ConfigurationList(stack.Uid)
+	must(err)
+	w.remove(stackExportConfigurationsDir)
+	for _, configuration := range configurations {
+		w.write(filepath.Join(stackExportConfigurationsDir, configuration.Type), configuration.Body)
+	}
+
+	envVars, err := client.StackEnvVars(stack.Uid)
+	must(err)
+	sort.Sort(envVarsByName(envVars))
+	var dotEnv strings.Builder
+	for _, envVar := range envVars {
+		if envVar.Key != "" && !envVar.Readonly {
+			dotEnv.WriteString(formatDotEnv(envVarPair{Key: envVar.Key, Value: envVarValue(envVar)}) + "\n")
+		}
+	}
+	w.remove(stackExportEnvVarsFile)
+	w.remove(stackExportEnvVarsFile + ".enc")
+	if passphrase != "" {
+		encrypted, err := encryptWithPassphrase([]byte(dotEnv.String()), passphrase)
+		must(err)
+		w.write(stackExportEnvVarsFile+".enc", encrypted)
+	} else {
+		w.write(stackExportEnvVarsFile, dotEnv.String())
+	}
+
+	w.writeYaml(stackExportSettingsFile, exportSettings(*stack))
+	w.writeExportOnlyYaml(stackExportJobsFile, exportJobs(*stack))
+	w.writeExportOnlyYaml(stackExportSslFile, exportSslCertificates(*stack))
+
+	fmt.Printf("Stack %s exported to %s (%d files)\n", stack.Name, dir, w.count)
+	if passphrase == "" {
+		printWarning("%s holds the environment variables in clear. Use --encrypt before committing it", filepath.Join(dir, stackExportEnvVarsFile))
+	}
+}
+
+// writes the settings of the stack and its servers, leaving the readonly ones out
+func exportSettings(stack cloud66.Stack) settingsFile {
+	result := settingsFile{Stack: make(map[string]interface{}), Servers: make(map[string]map[string]interface{})}
+
+	settings, err := client.StackSettings(stack.Uid)
+	must(err)
+	for _, setting := range settings {
+		if setting.Key != "" && !setting.Readonly {
+			result.Stack[setting.Key] = setting.Value
+		}
+	}
+
+	servers, err := client.Servers(stack.Uid)
+	must(err)
+	for _, server := range servers {
+		settings, err := client.ServerSettings(stack.Uid, server.Uid)
+		must(err)
+		values := make(map[string]interface{})
+		for _, setting := range settings {
+			if setting.Key != "" && !setting.Readonly {
+				values[setting.Key] = setting.Value
+			}
+		}
+		if len(values) > 0 {
+			result.Servers[server.Name] = values
+		}
+	}
+
+	return result
+}
+
+func exportJobs(stack cloud66.Stack) []stackExportJob {
+	jobs, err := client.GetJobs(stack.Uid, nil)
+	must(err)
+
+	result := make([]stackExportJob, 0)
+	for _, job := range jobs {
+		basic := job.GetBasicJob()
+		result = append(result, stackExportJob{Name: basic.Name, Type: basic.Type, Cron: basic.Cron, Params: basic.Params})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func exportSslCertificates(stack cloud66.Stack) []stackExportSslCertificate {
+	certificates, err := client.ListSslCertificates(stack.Uid)
+	must(err)
+
+	result := make([]stackExportSslCertificate, 0)
+	for _, certificate := range certificates {
+		exported := stackExportSslCertificate{
+			Name:           certificate.Name,
+			Type:           certificate.Type,
+			ServerNames:    certificate.ServerNames,
+			SSLTermination: certificate.SSLTermination,
+			ExpiresAt:      certificate.ExpiresAt,
+		}
+		if certificate.SHA256Fingerprint != nil {
+			exported.SHA256Fingerprint = *certificate.SHA256Fingerprint
+		}
+		if certificate.CAName != nil {
+			exported.CAName = *certificate.CAName
+		}
+		result = append(result, exported)
+	}
+	return result
+}
+
+// stackExportWriter writes the files of an exported stack, stopping at the first error
+type stackExportWriter struct {
+	dir   string
+	count int
+}
+
+func (w *stackExportWriter) write(name string, content string) {
+	path := filepath.Join(w.dir, name)
+	must(os.MkdirAll(filepath.Dir(path), 0700))
+	must(ioutil.WriteFile(path, []byte(content), 0600))
+	w.count++
+}
+
+func (w *stackExportWriter) writeYaml(name string, value interface{}) {
+	data, err := yaml.Marshal(value)
+	must(err)
+	w.write(name, string(data))
+}
+
+// writes a file for reference, with a header saying stacks import doesn't apply it
+func (w *stackExportWriter) writeExportOnlyYaml(name string, value interface{}) {
+	data, err := yaml.Marshal(value)
+	must(err)
+	w.write(name, "# For reference only: 'cx stacks import' doesn't apply this file\n"+string(data))
+}
+
+// removes a file or directory of an earlier export, so nothing stale is left behind
+func (w *stackExportWriter) remove(name string) {
+	must(os.RemoveAll(filepath.Join(w.dir, name)))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+	"gopkg.in/go-yaml/yaml.v2"
+)
+
+// stackFileChange is a file of an exported stack which is different from the stack
+type stackFileChange struct {
+	Name string
+	Diff string
+	Body string
+}
+
+// stackImportPlan holds everything stacks import changes
+type stackImportPlan struct {
+	ServiceYaml    *stackFileChange
+	ManifestYaml   *stackFileChange
+	Configurations []stackFileChange
+	EnvVars        []envVarUpdate
+	EnvVarChanges  []envVarChange
+	Settings       []settingChange
+}
+
+func (p stackImportPlan) empty() bool {
+	return p.ServiceYaml == nil && p.ManifestYaml == nil && len(p.Configurations) == 0 && len(p.EnvVars) == 0 && len(p.Settings) == 0
+}
+
+func runStackImport(c *cli.Context) {
+	dir := c.String("dir")
+	if dir == "" {
+		printFatal("No directory provided. Please use --dir to specify the exported stack")
+	}
+	dir = expandPath(dir)
+	applyStrategy := mustApplyStrategy(c)
+
+	stack := mustStack(c)
+	var info stackExportInfo
+	if err := readStackExportYaml(dir, stackExportInfoFile, &info); err != nil {
+		printFatal("%s doesn't look like an exported stack: %s", dir, err.Error())
+	}
+	if info.Uid != stack.Uid {
+		printWarning("%s was exported from %s (%s), importing it into %s (%s)", dir, info.Name, info.Environment, stack.Name, stack.Environment)
+	}
+
+	plan := planStackImport(*stack, dir)
+	printStackImportPlan(plan, newSecretReveal(c))
+	for _, name := range stackExportOnlyFiles {
+		if exists, _ := fileExists(filepath.Join(dir, name)); exists {
+			printWarning("%s is export-only and is not imported", name)
+		}
+	}
+	if c.Bool("plan") || plan.empty() {
+		return
+	}
+
+	if !c.Bool("y") {
+		mustConfirm(fmt.Sprintf("Apply these changes to %s? [yes/N]", stack.Name), "yes")
+	}
+
+	message := c.String("message")
+	if message == "" {
+		message = "Imported with cx stacks import"
+	}
+
+	// the import changes the stack as a whole, so it is checked once before anything is written
+	payload := newHookPayload(hookPreDeploy, "stacks import", *stack)
+	mustNotBeLocked(c, *stack)
+	mustPassPolicy(c, *stack, policyAction{Command: "stacks import"})
+	mustFireHooks(payload)
+
+	fail := func(format string, v ...interface{}) {
+		fireHooks(payload.finished(false, fmt.Sprintf(format, v...)))
+		printFatal(format, v...)
+	}
+
+	if plan.ServiceYaml != nil {
+		if _, err := client.CreateServiceYaml(stack.Uid, plan.ServiceYaml.Body, message); err != nil {
+			fail("%s: %s", stackExportServiceYamlFile, err.Error())
+		}
+		fmt.Println("service.yml updated")
+	}
+	if plan.ManifestYaml != nil {
+		if _, err := client.CreateManifestYaml(stack.Uid, plan.ManifestYaml.Body, message); err != nil {
+			fail("%s: %s", stackExportManifestYamlFile, err.Error())
+		}
+		fmt.Println("manifest.yml updated")
+	}
+	for _, configuration := range plan.Configurations {
+		theType := strings.TrimPrefix(configuration.Name, "configuration/")
+		asyncRes, err := client.ConfigurationUpload(stack.Uid, theType, message, configuration.Body, !c.Bool("no-apply"))
+		if err != nil {
+			fail("%s: %s", configuration.Name, err.Error())
+		}
+		// the next changes build on this one, so it is waited for even with --no-wait
+		genericRes, err := waitForStackAsyncAction(asyncRes.Id, stack.Uid, 5*time.Second, 20*time.Minute, false)
+		if err != nil {
+			fail("%s: %s", configuration.Name, err.Error())
+		}
+		if !genericRes.Status {
+			fail("%s: %s", configuration.Name, genericRes.Message)
+		}
+		fmt.Printf("%s updated\n", configuration.Name)
+	}
+	// settings are waited for even with --no-wait, for the environment variables to be applied after them
+	if failed := applySettingChanges(*stack, plan.Settings, true); failed > 0 {
+		fail("%d of %d setting change(s) failed", failed, len(plan.Settings))
+	}
+	// environment variables go last, so an immediate apply picks up everything else
+	if len(plan.EnvVars) > 0 {
+		changeEnvVars(*stack, plan.EnvVars, applyStrategy, &payload)
+		return
+	}
+	fireHooks(payload.finished(true, "Stack imported"))
+}
+
+func planStackImport(stack cloud66.Stack, dir string) stackImportPlan {
+	var plan stackImportPlan
+
+	if body, ok := readStackExportFile(dir, stackExportServiceYamlFile); ok {
+		// stacks without any version don't have the file. Any other error fails the import
+		current := ""
+		versions, err := client.ServiceYamlList(stack.Uid, false)
+		must(err)
+		if len(versions) > 0 {
+			serviceYaml, err := client.ServiceYamlInfo(stack.Uid, "latest")
+			must(err)
+			current = serviceYaml.Body
+		}
+		plan.ServiceYaml = diffStackFile(stackExportServiceYamlFile, current, body)
+	}
+	if body, ok := readStackExportFile(dir, stackExportManifestYamlFile); ok {
+		current := ""
+		versions, err := client.ManifestYamlList(stack.Uid, false)
+		must(err)
+		if len(versions) > 0 {
+			manifestYaml, err := client.ManifestYamlInfo(stack.Uid, "latest")
+			must(err)
+			current = manifestYaml.Body
+		}
+		plan.ManifestYaml = diffStackFile(stackExportManifestYamlFile, current, body)
+	}
+
+	if entries, err := ioutil.ReadDir(filepath.Join(dir, stackExportConfigurationsDir)); err == nil {
+		configurations, err := client.ConfigurationList(stack.Uid)
+		must(err)
+		current := make(map[string]string)
+		for _, configuration := range configurations {
+			current[configuration.Type] = configuration.Body
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			existing, ok := current[entry.Name()]
+			if !ok {
+				printFatal("Stack %s doesn't have a %s configuration", stack.Name, entry.Name())
+			}
+			body, _ := readStackExportFile(dir, filepath.Join(stackExportConfigurationsDir, entry.Name()))
+			if change := diffStackFile("configuration/"+entry.Name(), existing, body); change != nil {
+				plan.Configurations = append(plan.Configurations, *change)
+			}
+		}
+	}
+
+	if pairs := readStackExportEnvVars(dir); pairs != nil {
+		envVars, err := client.StackEnvVars(stack.Uid)
+		must(err)
+		existing := make(map[string]bool)
+		for _, envVar := range envVars {
+			existing[envVar.Key] = true
+		}
+
+		changes, readonly := diffEnvVars(envVars, pairs)
+		for _, key := range readonly {
+			printWarning("Skipping %s as it is readonly", key)
+		}
+		plan.EnvVarChanges = changes
+		for _, pair := range envVarsToSet(changes) {
+			plan.EnvVars = append(plan.EnvVars, envVarUpdate{Key: pair.Key, Value: pair.Value, Existing: existing[pair.Key]})
+		}
+	}
+
+	var settings settingsFile
+	if err := readStackExportYaml(dir, stackExportSettingsFile, &settings); err == nil {
+		plan.Settings = mustDiffSettingsFile(stack, &settings)
+	} else if !os.IsNotExist(err) {
+		printFatal("%s: %s", stackExportSettingsFile, err.Error())
+	}
+
+	return plan
+}
+
+func printStackImportPlan(plan stackImportPlan, reveal secretReveal) {
+	if plan.empty() && len(plan.EnvVarChanges) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	files := plan.Configurations
+	if plan.ManifestYaml != nil {
+		files = append([]stackFileChange{*plan.ManifestYaml}, files...)
+	}
+	if plan.ServiceYaml != nil {
+		files = append([]stackFileChange{*plan.ServiceYaml}, files...)
+	}
+	for _, file := range files {
+		if reveal.all {
+			fmt.Print(file.Diff)
+		} else {
+			fmt.Print(maskSecretsInText(file.Diff))
+		}
+	}
+
+	if len(plan.EnvVarChanges) > 0 {
+		fmt.Println("env-vars:")
+		printEnvVarChanges(os.Stdout, plan.EnvVarChanges, reveal)
+	}
+	if len(plan.Settings) > 0 {
+		fmt.Println("settings:")
+		printSettingChanges(os.Stdout, plan.Settings, reveal)
+	}
+}
+
+// returns the change of a file, or nil if it's the same
+func diffStackFile(name string, current string, body string) *stackFileChange {
+	diff := unifiedDiff("stack/"+name, "dir/"+name, current, body)
+	if diff == "" {
+		return nil
+	}
+	return &stackFileChange{Name: name, Diff: diff, Body: body}
+}
+
+func readStackExportFile(dir string, name string) (string, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	must(err)
+	return string(data), true
+}
+
+func readStackExportYaml(dir string, name string, value interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, value)
+}
+
+// reads the environment variables of an exported stack, decrypting them if needed. Returns nil if there aren't any
+func readStackExportEnvVars(dir string) []envVarPair {
+	body, ok := readStackExportFile(dir, stackExportEnvVarsFile)
+	if !ok {
+		encrypted, ok := readStackExportFile(dir, stackExportEnvVarsFile+".enc")
+		if !ok {
+			return nil
+		}
+		passphrase := os.Getenv(stackExportPassphraseVar)
+		if passphrase == "" {
+			printFatal("The environment variables are encrypted. Please set the passphrase in $%s", stackExportPassphraseVar)
+		}
+		data, err := decryptWithPassphrase(encrypted, passphrase)
+		if err != nil {
+			printFatal("%s.enc: %s", stackExportEnvVarsFile, err.Error())
+		}
+		body = string(data)
+	}
+
+	pairs, err := parseDotEnv(strings.NewReader(body))
+	if err != nil {
+		printFatal("%s: %s", stackExportEnvVarsFile, err.Error())
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs
+}
@@ -35,23 +35,7 @@ func runListen(c *cli.Context) {
 }
 
 func StartListen(stack *cloud66.Stack) {
-	if debugMode {
-		fmt.Printf("Connecting to Faye on %s\n", selectedProfile.FayeEndpoint)
-	}
-
-	//	sigChan := make(chan os.Signal, 1)
-	//  signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	channel := "/realtime/" + stack.Uid + "/*"
-
-	wray.RegisterTransports([]wray.Transport{&wray.HttpTransport{}})
-
-	fc := wray.NewFayeClient(selectedProfile.FayeEndpoint)
-	sub := fc.Subscribe(channel, true, handleMessage)
-	if debugMode {
-		fmt.Printf("Subscribed to %s\n", sub)
-	}
-	go fc.Listen()
+	subscribeToStack(stack, handleMessage)
 
 	// handle interrupts
 	hupChan := make(chan os.Signal, 1)
@@ -69,7 +53,36 @@ func StartListen(stack *cloud66.Stack) {
 	}
 }
 
+// subscribes to the realtime log channel of the stack and starts listening in the background
+func subscribeToStack(stack *cloud66.Stack, handler func(wray.Message)) {
+	if debugMode {
+		fmt.Printf("Connecting to Faye on %s\n", selectedProfile.FayeEndpoint)
+	}
+
+	channel := "/realtime/" + stack.Uid + "/*"
+
+	wray.RegisterTransports([]wray.Transport{&wray.HttpTransport{}})
+
+	fc := wray.NewFayeClient(selectedProfile.FayeEndpoint)
+	sub := fc.Subscribe(channel, true, handler)
+	if debugMode {
+		fmt.Printf("Subscribed to %s\n", sub)
+	}
+	go fc.Listen()
+}
+
 func handleMessage(msg wray.Message) {
+	fmt.Println(formatMessage(msg))
+}
+
+// returns a handler which prints each log line with the given prefix
+func prefixedMessageHandler(prefix string) func(wray.Message) {
+	return func(msg wray.Message) {
+		fmt.Println(prefix + formatMessage(msg))
+	}
+}
+
+func formatMessage(msg wray.Message) string {
 	redColor := ansi.ColorFunc("red+h")
 	capColor := ansi.ColorFunc("yellow")
 	infoColor := ansi.ColorFunc("white")
@@ -111,6 +124,5 @@ func handleMessage(msg wray.Message) {
 		colorFunc = redColor
 	}
 
-	fmt.Println(colorFunc(fmt.Sprintf("%s [%s] - %s", m.Time, level, m.Message)))
-
+	return colorFunc(fmt.Sprintf("%s [%s] - %s", m.Time, level, m.Message))
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+// the ConfigStore key holding the deployment lock of a stack
+const deployLockKey = "cx.deploy-lock"
+
+// deployLock is an advisory lock stored in the ConfigStore namespace of a stack
+type deployLock struct {
+	Owner     string    `json:"owner"`
+	Reason    string    `json:"reason"`
+	TTL       int       `json:"ttl"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// returns when the lock expires, or a zero time if it never does
+func (l deployLock) ExpiresAt() time.Time {
+	if l.TTL <= 0 {
+		return time.Time{}
+	}
+	return l.CreatedAt.Add(time.Duration(l.TTL) * time.Second)
+}
+
+func (l deployLock) expired(now time.Time) bool {
+	expiresAt := l.ExpiresAt()
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+func (l deployLock) String() string {
+	result := fmt.Sprintf("locked by %s since %s", l.Owner, prettyTime{l.CreatedAt})
+	if l.Reason != "" {
+		result = fmt.Sprintf("%s (%s)", result, l.Reason)
+	}
+	if expiresAt := l.ExpiresAt(); !expiresAt.IsZero() {
+		result = fmt.Sprintf("%s, expires %s", result, prettyTime{expiresAt})
+	}
+	return result
+}
+
+func runStackLock(c *cli.Context) {
+	stack := mustStack(c)
+	mustHaveConfigStore(*stack)
+
+	ttl, err := time.ParseDuration(c.String("ttl"))
+	if err != nil {
+		printFatal("Invalid --ttl %q. Use values like 30m, 2h or 0 for a lock that doesn't expire", c.String("ttl"))
+	}
+
+	lock := deployLock{
+		Owner:     currentLockOwner(),
+		Reason:    c.String("reason"),
+		TTL:       int(ttl.Seconds()),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := takeDeployLock(*stack, lock, c.Bool("force")); err != nil {
+		printFatal("%s", err.Error())
+	}
+	fmt.Printf("Stack %s is now %s\n", stack.Name, lock.String())
+}
+
+func runStackUnlock(c *cli.Context) {
+	stack := mustStack(c)
+	mustHaveConfigStore(*stack)
+
+	locked, err := releaseDeployLock(*stack, c.Bool("force"))
+	if err != nil {
+		printFatal("%s", err.Error())
+	}
+	if !locked {
+		fmt.Printf("Stack %s is not locked\n", stack.Name)
+		return
+	}
+	fmt.Printf("Stack %s is unlocked\n", stack.Name)
+}
+
+func runStackLockStatus(c *cli.Context) {
+	stack := mustStack(c)
+	mustHaveConfigStore(*stack)
+
+	existing, err := getDeployLock(*stack)
+	must(err)
+	if existing == nil {
+		fmt.Printf("Stack %s is not locked\n", stack.Name)
+		return
+	}
+	if existing.expired(time.Now()) {
+		fmt.Printf("Stack %s is not locked (the lock held by %s has expired)\n", stack.Name, existing.Owner)
+		return
+	}
+	fmt.Printf("Stack %s is %s\n", stack.Name, existing.String())
+}
+
+// stops deploy-type commands if someone else holds the deployment lock of the stack, unless --force is used
+func mustNotBeLocked(c *cli.Context, stack cloud66.Stack) {
+	warning, err := checkDeployLock(stack, c.Bool("force"))
+	if err != nil {
+		printFatal("%s", err.Error())
+	}
+	if warning != "" {
+		printWarning("%s", warning)
+	}
+}
+
+// fails if someone else holds the deployment lock of the stack. With force, the lock is ignored and
+// the warning to show is returned instead
+func checkDeployLock(stack cloud66.Stack, force bool) (string, error) {
+	if stack.ConfigStoreNamespace == "" {
+		return "", nil
+	}
+
+	// a lock which can't be checked is treated like one held by someone else
+	lock, err := getDeployLock(stack)
+	if err != nil {
+		if !force {
+			return "", fmt.Errorf("Unable to check the deployment lock of %s: %s. Use --force to run anyway", stack.Name, err.Error())
+		}
+		return fmt.Sprintf("Ignoring deployment lock: unable to check the lock of %s: %s", stack.Name, err.Error()), nil
+	}
+	if lock == nil || lock.expired(time.Now()) || lock.Owner == currentLockOwner() {
+		return "", nil
+	}
+
+	if !force {
+		return "", fmt.Errorf("Stack %s is %s. Use --force to run anyway", stack.Name, lock.String())
+	}
+	return fmt.Sprintf("Ignoring deployment lock: stack %s is %s", stack.Name, lock.String()), nil
+}
+
+func mustHaveConfigStore(stack cloud66.Stack) {
+	if stack.ConfigStoreNamespace == "" {
+		printFatal("Stack %s doesn't have a ConfigStore namespace", stack.Name)
+	}
+}
+
+// saves the lock, unless someone else holds an unexpired one. With force, their lock is taken over
+func takeDeployLock(stack cloud66.Stack, lock deployLock, force bool) error {
+	existing, err := getDeployLock(stack)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !existing.expired(time.Now()) && existing.Owner != lock.Owner && !force {
+		return fmt.Errorf("Stack %s is already %s. Use --force to take over the lock", stack.Name, existing.String())
+	}
+	return saveDeployLock(stack, lock, existing != nil)
+}
+
+// removes the deployment lock of the stack, unless someone else holds an unexpired one and force isn't used.
+// Returns false if the stack wasn't locked
+func releaseDeployLock(stack cloud66.Stack, force bool) (bool, error) {
+	existing, err := getDeployLock(stack)
+	if err != nil || existing == nil {
+		return false, err
+	}
+	if existing.Owner != currentLockOwner() && !existing.expired(time.Now()) && !force {
+		return false, fmt.Errorf("Stack %s is %s. Use --force to remove someone else's lock", stack.Name, existing.String())
+	}
+
+	if _, err := client.DeleteConfigStoreRecord(stack.ConfigStoreNamespace, deployLockKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// fetches the deployment lock of the stack. Returns nil if the stack is not locked
+func getDeployLock(stack cloud66.Stack) (*deployLock, error) {
+	record, err := findConfigStoreRecord(stack.ConfigStoreNamespace, deployLockKey)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	var lock deployLock
+	if err := json.Unmarshal([]byte(record.RawValue), &lock); err != nil {
+		return nil, errors.New("the deployment lock record is invalid: " + err.Error())
+	}
+	return &lock, nil
+}
+
+func saveDeployLock(stack cloud66.Stack, lock deployLock, exists bool) error {
+	value, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	record := &cloud66.ConfigStoreRecord{
+		Key:      deployLockKey,
+		RawValue: string(value),
+		Metadata: map[string]string{
+			"owner":      lock.Owner,
+			"reason":     lock.Reason,
+			"created_at": lock.CreatedAt.Format(time.RFC3339),
+			"ttl":        strconv.Itoa(lock.TTL),
+		},
+		Ttl: lock.TTL,
+	}
+
+	if exists {
+		_, err = client.UpdateConfigStoreRecord(stack.ConfigStoreNamespace, deployLockKey, record)
+		return err
+	}
+
+	_, err = client.CreateConfigStoreRecord(stack.ConfigStoreNamespace, record)
+	if err != nil && strings.Contains(err.Error(), "Duplicate entry") {
+		return errors.New("someone else locked the stack at the same time")
+	}
+	return err
+}
+
+// identifies the person holding a lock as user@host
+func currentLockOwner() string {
+	username := "unknown"
+	if usr, err := user.Current(); err == nil {
+		username = usr.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return username
+	}
+	return username + "@" + host
+}
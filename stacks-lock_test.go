@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the records of a ConfigStore namespace holding the given deployment lock
+func deployLockRecordsResponse(lock deployLock) string {
+	value, err := json.Marshal(lock)
+	Expect(err).NotTo(HaveOccurred())
+	record, err := json.Marshal(cloud66.ConfigStoreRecord{Key: deployLockKey, RawValue: string(value)})
+	Expect(err).NotTo(HaveOccurred())
+	return stackDiffListResponse(string(record))
+}
+
+var _ = Describe("Deployment lock", func() {
+	created := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	It("should expire after its TTL", func() {
+		lock := deployLock{Owner: "alice@laptop", TTL: 3600, CreatedAt: created}
+		Expect(lock.expired(created.Add(59 * time.Minute))).To(BeFalse())
+		Expect(lock.expired(created.Add(time.Hour))).To(BeTrue())
+	})
+
+	It("should never expire without a TTL", func() {
+		lock := deployLock{Owner: "alice@laptop", CreatedAt: created}
+		Expect(lock.ExpiresAt().IsZero()).To(BeTrue())
+		Expect(lock.expired(created.Add(365 * 24 * time.Hour))).To(BeFalse())
+	})
+
+	Context("with a ConfigStore namespace", func() {
+		var restoreClient func()
+		stack := cloud66.Stack{Uid: "abc", Name: "mystack", ConfigStoreNamespace: "ns1"}
+		othersLock := deployLock{Owner: "alice@laptop", Reason: "release", CreatedAt: time.Now().UTC()}
+
+		mockLock := func(lock deployLock) {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/configstore/namespaces/ns1/records.json").
+				Reply(200).
+				BodyString(deployLockRecordsResponse(lock))
+		}
+
+		BeforeEach(func() {
+			gock.Off()
+			restoreClient = MockApiClient()
+		})
+
+		AfterEach(func() {
+			gock.Off()
+			restoreClient()
+		})
+
+		It("should refuse a lock held by someone else", func() {
+			mockLock(othersLock)
+
+			_, err := checkDeployLock(stack, false)
+			Expect(err).To(MatchError(ContainSubstring("Stack mystack is locked by alice@laptop")))
+		})
+
+		It("should only warn about a lock held by someone else with --force", func() {
+			mockLock(othersLock)
+
+			warning, err := checkDeployLock(stack, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(ContainSubstring("Ignoring deployment lock: stack mystack is locked by alice@laptop"))
+		})
+
+		It("should allow the owner of the lock and expired locks", func() {
+			mockLock(deployLock{Owner: currentLockOwner(), CreatedAt: time.Now().UTC()})
+			warning, err := checkDeployLock(stack, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(BeEmpty())
+
+			mockLock(deployLock{Owner: "alice@laptop", TTL: 60, CreatedAt: time.Now().Add(-time.Hour).UTC()})
+			warning, err = checkDeployLock(stack, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(BeEmpty())
+		})
+
+		It("should refuse to run when the lock can't be checked", func() {
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/configstore/namespaces/ns1/records.json").
+				Times(2).
+				Reply(500).
+				BodyString(`{"error":"internal_error","error_description":"Something went wrong"}`)
+
+			_, err := checkDeployLock(stack, false)
+			Expect(err).To(MatchError(ContainSubstring("Unable to check the deployment lock of mystack")))
+
+			warning, err := checkDeployLock(stack, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(ContainSubstring("Ignoring deployment lock: unable to check the lock of mystack"))
+		})
+
+		It("should not take over a lock held by someone else without --force", func() {
+			mockLock(othersLock)
+
+			err := takeDeployLock(stack, deployLock{Owner: "bob@desktop", CreatedAt: time.Now().UTC()}, false)
+			Expect(err).To(MatchError(ContainSubstring("Stack mystack is already locked by alice@laptop")))
+		})
+
+		It("should take over a lock held by someone else with --force", func() {
+			mockLock(othersLock)
+			gock.New("https://app.cloud66.com/api/3").
+				Put("/configstore/namespaces/ns1/records/cx.deploy-lock.json").
+				Reply(200).
+				JSON(map[string]interface{}{"response": map[string]string{"key": deployLockKey}})
+
+			err := takeDeployLock(stack, deployLock{Owner: "bob@desktop", CreatedAt: time.Now().UTC()}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+
+		It("should only remove someone else's lock with --force", func() {
+			mockLock(othersLock)
+			_, err := releaseDeployLock(stack, false)
+			Expect(err).To(MatchError(ContainSubstring("Use --force to remove someone else's lock")))
+
+			mockLock(othersLock)
+			gock.New("https://app.cloud66.com/api/3").
+				Delete("/configstore/namespaces/ns1/records/cx.deploy-lock.json").
+				Reply(200).
+				JSON(map[string]interface{}{"response": map[string]string{"key": deployLockKey}})
+			locked, err := releaseDeployLock(stack, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locked).To(BeTrue())
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+
+		It("should remove its own lock", func() {
+			mockLock(deployLock{Owner: currentLockOwner(), CreatedAt: time.Now().UTC()})
+			gock.New("https://app.cloud66.com/api/3").
+				Delete("/configstore/namespaces/ns1/records/cx.deploy-lock.json").
+				Reply(200).
+				JSON(map[string]interface{}{"response": map[string]string{"key": deployLockKey}})
+
+			locked, err := releaseDeployLock(stack, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locked).To(BeTrue())
+			Expect(gock.IsDone()).To(BeTrue())
+		})
+	})
+})
@@ -17,6 +17,9 @@ func runStackReboot(c *cli.Context) {
 		os.Exit(2)
 	}
 
+	mustNotBeLocked(c, *stack)
+	mustPassPolicy(c, *stack, policyAction{Command: "stacks reboot"})
+
 	// confirmation is needed if the stack is production
 	if !c.Bool("y") {
 		mustConfirm("This operation will reboot one or more servers from your stack; during this time your server may not be available. Proceed with reboot? [yes/N]", "yes")
@@ -38,6 +41,9 @@ func executeStackReboot(stack cloud66.Stack, strategy string, group string) {
 		printFatal(err.Error())
 	}
 	genericRes, err := endStackReboot(*asyncId, stack.Uid)
+	if err == errAsyncActionNotWaited {
+		return
+	}
 	if err != nil {
 		printFatal(err.Error())
 	}
@@ -54,5 +60,5 @@ func startStackReboot(stackUid string, strategy string, group string) (*int, err
 }
 
 func endStackReboot(asyncId int, stackUid string) (*cloud66.GenericResponse, error) {
-	return client.WaitStackAsyncAction(asyncId, stackUid, 10*time.Second, 30*time.Minute, true)
+	return waitStackAsyncAction(asyncId, stackUid, 10*time.Second, 30*time.Minute, true)
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cloud66-oss/cloud66"
+	"gopkg.in/go-yaml/yaml.v2"
+)
+
+// the parts of service.yml needed to find the services built from a repository
+type serviceYamlSources struct {
+	Services map[string]struct {
+		GitUrl    string `yaml:"git_url"`
+		GitBranch string `yaml:"git_branch"`
+	} `yaml:"services"`
+}
+
+// points the deployment at the local HEAD commit after checking it is safe to deploy
+func localRedeployOptions(stack cloud66.Stack, options redeployOptions) (redeployOptions, error) {
+	if options.gitRef != "" || len(options.services) > 0 {
+		return options, errors.New("--from-local cannot be used together with --git-ref or --service")
+	}
+
+	// Maestro stacks are not tied to a single repository, so their services are matched against origin
+	gitUrl := stack.Git
+	if gitUrl == "" && stack.Framework == "docker" {
+		gitUrl = remoteGitUrl()
+	}
+	if gitUrl == "" {
+		return options, fmt.Errorf("Stack %s is not deployed from a git repository", stack.Name)
+	}
+
+	clean, err := localGitIsClean()
+	if err != nil {
+		return options, err
+	}
+	if !clean {
+		return options, errors.New("The working tree has uncommitted changes. Commit and push them before deploying with --from-local")
+	}
+
+	commit, err := localGitCommit()
+	if err != nil {
+		return options, err
+	}
+
+	remote, err := localGitRemoteFor(gitUrl)
+	if err != nil {
+		return options, err
+	}
+	if remote == "" {
+		return options, fmt.Errorf("None of the git remotes of this repository point to %s, which stack %s is deployed from", gitUrl, stack.Name)
+	}
+
+	pushed, err := localGitIsPushed(commit, remote)
+	if err != nil {
+		return options, err
+	}
+	if !pushed {
+		return options, fmt.Errorf("Commit %s has not been pushed to %s. Push it (or run 'git fetch %s' if it already is) before deploying", commit[:7], remote, remote)
+	}
+
+	// Maestro stacks don't have a branch of their own
+	if branch := localGitCurrentBranch(); stack.GitBranch != "" && branch != "" && branch != stack.GitBranch {
+		printWarning("The local branch %s is different from %s, which stack %s is configured to deploy", branch, stack.GitBranch, stack.Name)
+	}
+
+	if stack.Framework != "docker" {
+		fmt.Printf("Deploying commit %s of %s\n", commit[:7], remote)
+		options.gitRef = commit
+		return options, nil
+	}
+
+	services, err := servicesBuiltFrom(stack, gitUrl)
+	if err != nil {
+		return options, err
+	}
+	if len(services) == 0 {
+		return options, fmt.Errorf("None of the services of stack %s are built from %s", stack.Name, gitUrl)
+	}
+
+	fmt.Printf("Deploying commit %s of %s to service(s) built from it\n", commit[:7], remote)
+	for _, service := range services {
+		options.services = append(options.services, service+":"+commit)
+	}
+	return options, nil
+}
+
+// returns the names of the services of a Maestro stack which are built from the given repository
+func servicesBuiltFrom(stack cloud66.Stack, gitUrl string) ([]string, error) {
+	serviceYaml, err := client.ServiceYamlInfo(stack.Uid, "latest")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources serviceYamlSources
+	if err := yaml.Unmarshal([]byte(serviceYaml.Body), &sources); err != nil {
+		return nil, fmt.Errorf("unable to read the service.yml of stack %s: %s", stack.Name, err)
+	}
+
+	var result []string
+	for name, service := range sources.Services {
+		if service.GitUrl == "" {
+			continue
+		}
+		same, err := areSameRemotes(service.GitUrl, gitUrl)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
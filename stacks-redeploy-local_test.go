@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const localRedeployGitUrl = "git@github.com:cloud66/app.git"
+
+// runs git in the given directory, with an identity for the commits
+func runTestGit(dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=cx", "-c", "user.email=cx@example.com"}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(output))
+	return string(output)
+}
+
+const serviceYamlSourcesBody = `services:
+  web:
+    git_url: https://github.com/cloud66/app.git
+  worker:
+    git_url: git@github.com:cloud66/app
+  api:
+    git_url: git@github.com:cloud66/api.git
+  redis:
+    image: redis
+`
+
+var _ = Describe("Redeploy from local", func() {
+	Context("finding the services built from a repository", func() {
+		var restoreClient func()
+
+		BeforeEach(func() {
+			gock.Off()
+			restoreClient = MockApiClient()
+			gock.New("https://app.cloud66.com/api/3").
+				Get("/stacks/abc/service_yaml/latest.json").
+				Reply(200).
+				JSON(map[string]interface{}{"response": map[string]string{"uid": "1", "body": serviceYamlSourcesBody}})
+		})
+
+		AfterEach(func() {
+			gock.Off()
+			restoreClient()
+		})
+
+		It("should only return the services with a matching git_url", func() {
+			services, err := servicesBuiltFrom(cloud66.Stack{Uid: "abc"}, localRedeployGitUrl)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(Equal([]string{"web", "worker"}))
+		})
+
+		It("should return nothing when no service is built from the repository", func() {
+			services, err := servicesBuiltFrom(cloud66.Stack{Uid: "abc"}, "https://github.com/cloud66/other.git")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(BeEmpty())
+		})
+	})
+
+	Context("checking the local repository", func() {
+		var cwd string
+		var dir string
+		var work string
+		var stack cloud66.Stack
+
+		BeforeEach(func() {
+			var err error
+			cwd, err = os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+			dir, err = ioutil.TempDir("", "cx-local")
+			Expect(err).NotTo(HaveOccurred())
+
+			origin := filepath.Join(dir, "origin.git")
+			work = filepath.Join(dir, "work")
+			runTestGit(dir, "init", "-q", "--bare", origin)
+			runTestGit(dir, "clone", "-q", origin, work)
+			Expect(ioutil.WriteFile(filepath.Join(work, "README"), []byte("app"), 0644)).To(Succeed())
+			runTestGit(work, "add", "README")
+			runTestGit(work, "commit", "-q", "-m", "first")
+			runTestGit(work, "push", "-q", "origin", "HEAD")
+			// the remote tracking branches stay, so the stack can point to the real repository
+			runTestGit(work, "remote", "set-url", "origin", localRedeployGitUrl)
+
+			Expect(os.Chdir(work)).To(Succeed())
+			stack = cloud66.Stack{Uid: "abc", Name: "mystack", Git: localRedeployGitUrl, Framework: "rails"}
+		})
+
+		AfterEach(func() {
+			Expect(os.Chdir(cwd)).To(Succeed())
+			os.RemoveAll(dir)
+		})
+
+		It("should deploy the pushed HEAD commit", func() {
+			commit := runTestGit(work, "rev-parse", "HEAD")
+
+			StartCaptureStdout()
+			options, err := localRedeployOptions(stack, redeployOptions{})
+			StopCaptureStdout()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(options.gitRef + "\n").To(Equal(commit))
+		})
+
+		It("should not be combined with --git-ref or --service", func() {
+			_, err := localRedeployOptions(stack, redeployOptions{gitRef: "main"})
+			Expect(err).To(MatchError(ContainSubstring("--from-local cannot be used together")))
+			_, err = localRedeployOptions(stack, redeployOptions{services: []string{"web"}})
+			Expect(err).To(MatchError(ContainSubstring("--from-local cannot be used together")))
+		})
+
+		It("should need a stack deployed from git", func() {
+			stack.Git = ""
+			_, err := localRedeployOptions(stack, redeployOptions{})
+			Expect(err).To(MatchError("Stack mystack is not deployed from a git repository"))
+		})
+
+		It("should refuse uncommitted changes", func() {
+			Expect(ioutil.WriteFile(filepath.Join(work, "README"), []byte("changed"), 0644)).To(Succeed())
+			_, err := localRedeployOptions(stack, redeployOptions{})
+			Expect(err).To(MatchError(ContainSubstring("uncommitted changes")))
+		})
+
+		It("should need a remote pointing to the stack repository", func() {
+			stack.Git = "https://github.com/cloud66/other.git"
+			_, err := localRedeployOptions(stack, redeployOptions{})
+			Expect(err).To(MatchError(ContainSubstring("None of the git remotes of this repository point to")))
+		})
+
+		It("should refuse commits which haven't been pushed", func() {
+			runTestGit(work, "commit", "-q", "--allow-empty", "-m", "second")
+			_, err := localRedeployOptions(stack, redeployOptions{})
+			Expect(err).To(MatchError(ContainSubstring("has not been pushed to origin")))
+		})
+	})
+})
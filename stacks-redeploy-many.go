@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66/cli"
+)
+
+const (
+	redeployDeployed = "deployed"
+	redeployFailed   = "failed"
+	redeployQueued   = "queued"
+	redeploySkipped  = "skipped"
+)
+
+// redeployOutcome is the result of deploying one of many stacks
+type redeployOutcome struct {
+	Stack    cloud66.Stack
+	Result   string
+	Message  string
+	Duration time.Duration
+}
+
+func runRedeployMany(c *cli.Context) {
+	if c.String("stacks-matching") != "" && c.String("stacks-file") != "" {
+		printFatal("Only one of --stacks-matching or --stacks-file can be used")
+	}
+	if c.Bool("from-local") {
+		printFatal("--from-local cannot be used together with --stacks-matching or --stacks-file")
+	}
+	if c.String("stack") != "" {
+		printFatal("--stack cannot be used together with --stacks-matching or --stacks-file")
+	}
+
+	maxParallel := c.Int("max-parallel")
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	canaries := c.Int("canary")
+	if canaries < 0 {
+		printFatal("--canary cannot be negative")
+	}
+
+	stacks, err := stacksToRedeploy(c)
+	must(err)
+	if len(stacks) == 0 {
+		printFatal("No stacks found to deploy")
+	}
+
+	options := redeployOptionsFromContext(c)
+	for _, stack := range stacks {
+		if err := options.validate(stack); err != nil {
+			printFatal("%s: %s", stack.Name, err.Error())
+		}
+		mustNotBeLocked(c, stack)
+		mustPassPolicy(c, stack, options.policyAction(stack))
+	}
+
+	fmt.Printf("Deploying %d stack(s):\n", len(stacks))
+	hasProduction := false
+	for _, stack := range stacks {
+		fmt.Printf("  %s (%s)\n", stack.Name, stack.Environment)
+		if stack.Environment == "production" {
+			hasProduction = true
+		}
+	}
+
+	// confirmation is needed if any of the stacks are production
+	if hasProduction && !c.Bool("y") {
+		mustConfirm("One or more of these are production stacks. Proceed with deployment? [yes/N]", "yes")
+	}
+
+	listen := c.Bool("listen")
+	failFast := c.Bool("fail-fast")
+	prefixWidth := 0
+	for _, stack := range stacks {
+		if len(stack.Name) > prefixWidth {
+			prefixWidth = len(stack.Name)
+		}
+	}
+
+	deploy := func(stack cloud66.Stack, prefix string) redeployOutcome {
+		return redeployOne(stack, options, listen, prefix)
+	}
+	outcomes := redeployWithCanaries(stacks, canaries, func(stacks []cloud66.Stack) []redeployOutcome {
+		return redeployStacks(stacks, maxParallel, failFast, prefixWidth, deploy)
+	})
+
+	failures := printRedeploySummary(outcomes)
+	if failures > 0 {
+		printFatal("%d of %d stack deployment(s) did not complete successfully", failures, len(outcomes))
+	}
+}
+
+// deploys the first canaries stacks, and the rest only if all of them deployed
+func redeployWithCanaries(stacks []cloud66.Stack, canaries int, deployStacks func([]cloud66.Stack) []redeployOutcome) []redeployOutcome {
+	if canaries > len(stacks) {
+		canaries = len(stacks)
+	}
+
+	var outcomes []redeployOutcome
+	rest := stacks
+	if canaries > 0 {
+		fmt.Printf("Deploying %d canary stack(s)...\n", canaries)
+		canaryOutcomes := deployStacks(stacks[:canaries])
+		outcomes = append(outcomes, canaryOutcomes...)
+		rest = stacks[canaries:]
+
+		healthy := true
+		for _, outcome := range canaryOutcomes {
+			// queued deployments cannot be verified so they don't count as healthy canaries
+			if outcome.Result != redeployDeployed {
+				healthy = false
+			}
+		}
+		if !healthy {
+			printError("Canary deployment did not finish healthy. Skipping the remaining %d stack(s)", len(rest))
+			outcomes = append(outcomes, skippedOutcomes(rest, "canary failed")...)
+			rest = nil
+		} else if len(rest) > 0 {
+			fmt.Println("Canary stack(s) are healthy. Deploying the remaining stacks...")
+		}
+	}
+
+	if len(rest) > 0 {
+		outcomes = append(outcomes, deployStacks(rest)...)
+	}
+	return outcomes
+}
+
+// finds the stacks to deploy from the --stacks-matching or --stacks-file options
+func stacksToRedeploy(c *cli.Context) ([]cloud66.Stack, error) {
+	flagEnvironment = c.String("environment")
+
+	if pattern := c.String("stacks-matching"); pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+
+		stacks, err := client.StackListWithFilter(filterByEnvironmentFuzzy)
+		if err != nil {
+			return nil, err
+		}
+
+		var result []cloud66.Stack
+		for _, stack := range stacks {
+			if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(stack.Name)); matched {
+				result = append(result, stack)
+			}
+		}
+		return result, nil
+	}
+
+	file, err := os.Open(c.String("stacks-file"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []cloud66.Stack
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// each line is a stack name, optionally followed by the environment
+		fields := strings.Fields(line)
+		environment := flagEnvironment
+		if len(fields) > 1 {
+			environment = fields[1]
+		}
+		stack, err := client.StackInfoWithEnvironment(fields[0], environment)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fields[0], err)
+		}
+		result = append(result, *stack)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// stackDeployer deploys a single stack, prefixing the lines it prints with prefix
+type stackDeployer func(stack cloud66.Stack, prefix string) redeployOutcome
+
+// deploys the given stacks with at most maxParallel deployments running at the same time
+// and returns the outcomes in the same order as the stacks
+func redeployStacks(stacks []cloud66.Stack, maxParallel int, failFast bool, prefixWidth int, deploy stackDeployer) []redeployOutcome {
+	outcomes := make([]redeployOutcome, len(stacks))
+	slots := make(chan struct{}, maxParallel)
+
+	var mutex sync.Mutex
+	failed := false
+
+	var wg sync.WaitGroup
+	for idx, stack := range stacks {
+		slots <- struct{}{}
+
+		mutex.Lock()
+		stop := failFast && failed
+		mutex.Unlock()
+		if stop {
+			<-slots
+			outcomes[idx] = redeployOutcome{Stack: stack, Result: redeploySkipped, Message: "an earlier deployment failed"}
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int, stack cloud66.Stack) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			prefix := fmt.Sprintf("[%-*s] ", prefixWidth, stack.Name)
+			outcome := deploy(stack, prefix)
+			fmt.Printf("%s%s: %s\n", prefix, outcome.Result, outcome.Message)
+
+			mutex.Lock()
+			outcomes[idx] = outcome
+			if outcome.Result == redeployFailed {
+				failed = true
+			}
+			mutex.Unlock()
+		}(idx, stack)
+	}
+	wg.Wait()
+
+	return outcomes
+}
+
+// deploys a single stack and waits for the deployment to finish
+func redeployOne(stack cloud66.Stack, options redeployOptions, listen bool, prefix string) redeployOutcome {
+	started := time.Now()
+	outcome := redeployOutcome{Stack: stack}
+	finish := func(result string, message string) redeployOutcome {
+		outcome.Result = result
+		outcome.Message = message
+		outcome.Duration = time.Since(started)
+		return outcome
+	}
+
+	payload := options.hookPayload(stack)
+	if err := runHooks(payload); err != nil {
+		return finish(redeploySkipped, "pre-deploy hook failed: "+err.Error())
+	}
+
+	result, err := client.RedeployStack(stack.Uid, options.gitRef, options.deployStrategy, options.deploymentProfile, options.services)
+	if err != nil {
+		fireHooks(payload.finished(false, err.Error()))
+		return finish(redeployFailed, err.Error())
+	}
+	if result.Queued {
+		return finish(redeployQueued, result.Message)
+	}
+	if flagNoWait {
+		return finish(redeployQueued, result.Message)
+	}
+
+	if listen {
+		subscribeToStack(&stack, prefixedMessageHandler(prefix))
+	}
+
+	if result.AsyncActionId != nil {
+		genericRes, err := waitStackAsyncAction(*(result.AsyncActionId), stack.Uid, 15*time.Second, 120*time.Minute, false)
+		if err != nil {
+			fireHooks(payload.finished(false, err.Error()))
+			return finish(redeployFailed, err.Error())
+		}
+		fireHooks(payload.finished(genericRes.Status, genericRes.Message))
+		if !genericRes.Status {
+			return finish(redeployFailed, genericRes.Message)
+		}
+		return finish(redeployDeployed, genericRes.Message)
+	}
+
+	deployed, err := WaitStackBuild(stack.Uid, false)
+	if err != nil {
+		fireHooks(payload.finished(false, err.Error()))
+		return finish(redeployFailed, err.Error())
+	}
+	message := fmt.Sprintf("%s (%s)", deployed.Status(), deployed.Health())
+	fireHooks(payload.finished(!stackBuildFailed(*deployed), message))
+	if stackBuildFailed(*deployed) {
+		return finish(redeployFailed, message)
+	}
+
+	return finish(redeployDeployed, message)
+}
+
+func skippedOutcomes(stacks []cloud66.Stack, reason string) []redeployOutcome {
+	var outcomes []redeployOutcome
+	for _, stack := range stacks {
+		outcomes = append(outcomes, redeployOutcome{Stack: stack, Result: redeploySkipped, Message: reason})
+	}
+	return outcomes
+}
+
+// prints the summary table and returns the number of deployments which did not succeed
+func printRedeploySummary(outcomes []redeployOutcome) int {
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+
+	failures := 0
+	listRec(w, "STACK", "ENVIRONMENT", "RESULT", "DURATION", "MESSAGE")
+	for _, outcome := range outcomes {
+		if outcome.Result == redeployFailed || outcome.Result == redeploySkipped {
+			failures++
+		}
+
+		duration := "-"
+		if outcome.Duration > 0 {
+			duration = prettyDuration{outcome.Duration}.String()
+		}
+		listRec(w,
+			outcome.Stack.Name,
+			outcome.Stack.Environment,
+			outcome.Result,
+			duration,
+			strings.TrimSpace(outcome.Message),
+		)
+	}
+
+	return failures
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/h2non/gock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redeploying many stacks", func() {
+	var restoreHome func()
+	var restoreClient func()
+	var stacks []cloud66.Stack
+
+	mockDeployment := func(stackUid string, actionId int, success bool) {
+		gock.New("https://app.cloud66.com/api/3").
+			Post("/stacks/" + stackUid + "/deployments.json").
+			Reply(200).
+			BodyString(fmt.Sprintf(`{"response":{"ok":true,"message":"deploying","queued":false,"async_action_id":%d}}`, actionId))
+		gock.New("https://app.cloud66.com/api/3").
+			Get(fmt.Sprintf("/stacks/%s/actions/%d.json", stackUid, actionId)).
+			Reply(200).
+			BodyString(actionResponse(fmt.Sprintf(finishedActionJSON, actionId, success)))
+	}
+	results := func(outcomes []redeployOutcome) []string {
+		var result []string
+		for _, outcome := range outcomes {
+			result = append(result, outcome.Stack.Name+" "+outcome.Result)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		// other suites can leave pending mocks behind
+		gock.Off()
+		restoreHome = useTempHome()
+		restoreClient = MockApiClient()
+		stacks = []cloud66.Stack{
+			{Uid: "uid-1", Name: "one", Environment: "production"},
+			{Uid: "uid-2", Name: "two", Environment: "production"},
+			{Uid: "uid-3", Name: "three", Environment: "staging"},
+			{Uid: "uid-4", Name: "four", Environment: "staging"},
+			{Uid: "uid-5", Name: "five", Environment: "staging"},
+		}
+		StartCaptureStdout()
+	})
+
+	AfterEach(func() {
+		StopCaptureStdout()
+		gock.Off()
+		restoreClient()
+		restoreHome()
+	})
+
+	It("should run at most max-parallel deployments at the same time", func() {
+		var mutex sync.Mutex
+		running, maxRunning := 0, 0
+		proceed := make(chan struct{})
+		deploy := func(stack cloud66.Stack, prefix string) redeployOutcome {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			<-proceed
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return redeployOutcome{Stack: stack, Result: redeployDeployed}
+		}
+		current := func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return running
+		}
+
+		done := make(chan []redeployOutcome)
+		go func() {
+			done <- redeployStacks(stacks, 2, false, 5, deploy)
+		}()
+		Eventually(current).Should(Equal(2))
+		Consistently(current, 50*time.Millisecond).Should(Equal(2))
+		close(proceed)
+
+		var outcomes []redeployOutcome
+		Eventually(done).Should(Receive(&outcomes))
+		Expect(maxRunning).To(Equal(2))
+		Expect(results(outcomes)).To(Equal([]string{"one deployed", "two deployed", "three deployed", "four deployed", "five deployed"}))
+	})
+
+	It("should skip the remaining stacks after a failure with --fail-fast", func() {
+		var deployed []string
+		deploy := func(stack cloud66.Stack, prefix string) redeployOutcome {
+			deployed = append(deployed, stack.Name)
+			if stack.Name == "two" {
+				return redeployOutcome{Stack: stack, Result: redeployFailed, Message: "boom"}
+			}
+			return redeployOutcome{Stack: stack, Result: redeployDeployed}
+		}
+
+		outcomes := redeployStacks(stacks, 1, true, 5, deploy)
+		Expect(deployed).To(Equal([]string{"one", "two"}))
+		Expect(results(outcomes)).To(Equal([]string{"one deployed", "two failed", "three skipped", "four skipped", "five skipped"}))
+		Expect(outcomes[2].Message).To(Equal("an earlier deployment failed"))
+	})
+
+	It("should deploy the canaries first and the rest once they are healthy", func() {
+		for idx, stack := range stacks {
+			mockDeployment(stack.Uid, idx+1, true)
+		}
+
+		var mutex sync.Mutex
+		var order []string
+		deploy := func(stack cloud66.Stack, prefix string) redeployOutcome {
+			mutex.Lock()
+			order = append(order, stack.Name)
+			mutex.Unlock()
+			return redeployOne(stack, redeployOptions{}, false, prefix)
+		}
+		outcomes := redeployWithCanaries(stacks, 2, func(stacks []cloud66.Stack) []redeployOutcome {
+			return redeployStacks(stacks, 3, false, 5, deploy)
+		})
+
+		Expect(order[:2]).To(ConsistOf("one", "two"))
+		Expect(order[2:]).To(ConsistOf("three", "four", "five"))
+		Expect(results(outcomes)).To(Equal([]string{"one deployed", "two deployed", "three deployed", "four deployed", "five deployed"}))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("should skip the remaining stacks when a canary fails", func() {
+		// only the canary is mocked, so deploying any other stack would fail
+		mockDeployment("uid-1", 1, false)
+
+		outcomes := redeployWithCanaries(stacks, 1, func(stacks []cloud66.Stack) []redeployOutcome {
+			return redeployStacks(stacks, 2, false, 5, func(stack cloud66.Stack, prefix string) redeployOutcome {
+				return redeployOne(stack, redeployOptions{}, false, prefix)
+			})
+		})
+
+		Expect(results(outcomes)).To(Equal([]string{"one failed", "two skipped", "three skipped", "four skipped", "five skipped"}))
+		Expect(outcomes[0].Message).To(Equal("done"))
+		Expect(outcomes[1].Message).To(Equal("canary failed"))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("should not count queued canaries as healthy", func() {
+		gock.New("https://app.cloud66.com/api/3").
+			Post("/stacks/uid-1/deployments.json").
+			Reply(200).
+			BodyString(`{"response":{"ok":true,"message":"queued behind another deployment","queued":true}}`)
+
+		outcomes := redeployWithCanaries(stacks[:2], 1, func(stacks []cloud66.Stack) []redeployOutcome {
+			return redeployStacks(stacks, 1, false, 5, func(stack cloud66.Stack, prefix string) redeployOutcome {
+				return redeployOne(stack, redeployOptions{}, false, prefix)
+			})
+		})
+
+		Expect(results(outcomes)).To(Equal([]string{"one queued", "two skipped"}))
+		Expect(outcomes[0].Message).To(Equal("queued behind another deployment"))
+	})
+
+	It("should summarize the outcomes and count the ones which did not succeed", func() {
+		outcomes := []redeployOutcome{
+			{Stack: stacks[0], Result: redeployDeployed, Message: "Live (Ok)", Duration: 90 * time.Second},
+			{Stack: stacks[1], Result: redeployFailed, Message: "build failed", Duration: time.Minute},
+			{Stack: stacks[2], Result: redeployQueued, Message: "queued"},
+			{Stack: stacks[3], Result: redeploySkipped, Message: "canary failed"},
+		}
+
+		failures := printRedeploySummary(outcomes)
+		output := StopCaptureStdout()
+		StartCaptureStdout()
+
+		Expect(failures).To(Equal(2))
+		Expect(output[1]).To(MatchRegexp(`^STACK\s+ENVIRONMENT\s+RESULT\s+DURATION\s+MESSAGE`))
+		Expect(output[2]).To(MatchRegexp(`^one\s+production\s+deployed\s+\S.*Live \(Ok\)$`))
+		Expect(output[3]).To(MatchRegexp(`^two\s+production\s+failed\s+\S.*build failed$`))
+		Expect(output[4]).To(MatchRegexp(`^three\s+staging\s+queued\s+-\s+queued$`))
+		Expect(strings.Join(output, "\n")).To(ContainSubstring("canary failed"))
+	})
+})
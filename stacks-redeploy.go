@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/cloud66-oss/cloud66"
 	"github.com/cloud66/cli"
 )
 
@@ -12,7 +15,7 @@ var cmdRedeploy = &Command{
 	Name:  "redeploy",
 	Run:   runRedeploy,
 	Build: buildBasicCommand,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "y",
 			Usage: "answer yes to confirmations",
@@ -38,24 +41,70 @@ var cmdRedeploy = &Command{
 			Name:  "deployment-profile",
 			Usage: "use a named deployment profile that you have configured on your stack",
 		},
-	},
+		cli.BoolFlag{
+			Name:  "from-local",
+			Usage: "deploy the commit checked out in the current directory. It must be committed and pushed",
+		},
+		cli.StringFlag{
+			Name:  "stacks-matching",
+			Usage: "deploy every stack with a name matching this glob pattern (ie. 'customer-*')",
+		},
+		cli.StringFlag{
+			Name:  "stacks-file",
+			Usage: "deploy every stack listed in this file (one stack name per line)",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "[multiple stacks] maximum number of stacks to deploy at the same time",
+			Value: 5,
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "[multiple stacks] do not start any more deployments once one has failed",
+		},
+		cli.IntFlag{
+			Name:  "canary",
+			Usage: "[multiple stacks] deploy this many stacks first and only continue if they are healthy",
+		},
+	}, deployGuardFlags()...),
 
 	NeedsStack: true,
 	NeedsOrg:   false,
 	Short:      "An alias for 'stacks redeploy' command",
+	Long: `Enqueues redeployment of the stack. See 'cx help stacks redeploy' for details.
+
+Use --from-local to deploy the commit checked out in the current directory.
+
+Several stacks can be deployed with the same arguments using --stacks-matching or --stacks-file.
+See 'cx help stacks redeploy' for the options available when deploying multiple stacks.
+`,
 }
 
 func runRedeploy(c *cli.Context) {
+	if c.String("stacks-matching") != "" || c.String("stacks-file") != "" {
+		runRedeployMany(c)
+		return
+	}
+
 	stack := mustStack(c)
+	options := redeployOptionsFromContext(c)
+	if c.Bool("from-local") {
+		var err error
+		options, err = localRedeployOptions(*stack, options)
+		must(err)
+	}
+	must(options.validate(*stack))
+	mustNotBeLocked(c, *stack)
+	mustPassPolicy(c, *stack, options.policyAction(*stack))
 
 	// confirmation is needed if the stack is production
 	if stack.Environment == "production" && !c.Bool("y") {
 		mustConfirm("This is a production stack. Proceed with deployment? [yes/N]", "yes")
 	}
 
-	if len(c.StringSlice("service")) > 0 {
+	if len(options.services) > 0 {
 		fmt.Printf("Deploying service(s): ")
-		for i, service := range c.StringSlice("service") {
+		for i, service := range options.services {
 			if i > 0 {
 				fmt.Printf(", ")
 			}
@@ -64,50 +113,40 @@ func runRedeploy(c *cli.Context) {
 		fmt.Printf("\n")
 	}
 
-	gitRef := c.String("git-ref")
-	services := c.StringSlice("service")
-	deployStrategy := c.String("deploy-strategy")
-	deploymentProfile := c.String("deployment-profile")
-
-	if deployStrategy != "" {
-		if deployStrategy != "serial" && deployStrategy != "parallel" &&
-			deployStrategy != "rolling" && deployStrategy != "fast" {
-			printFatal("The \"deploy strategy\" argument can only be \"serial\", \"parallel\", \"rolling\" or \"fast\"")
-		}
-		if deployStrategy == "fast" && stack.Backend != "kubernetes" {
-			printFatal("The \"fast\" deploy strategy only applies to Maestro stacks")
-		}
-		if deployStrategy == "rolling" && stack.Framework != "rails" && stack.Framework != "rack" {
-			printFatal("The \"rolling\" deploy strategy only applies to Rails/Rack stacks")
-		}
-	}
+	payload := options.hookPayload(*stack)
+	mustFireHooks(payload)
 
-	if len(services) > 0 && stack.Framework != "docker" {
-		printFatal("The \"service\" argument only applies to Maestro stacks")
+	result, err := client.RedeployStack(stack.Uid, options.gitRef, options.deployStrategy, options.deploymentProfile, options.services)
+	if err != nil {
+		fireHooks(payload.finished(false, err.Error()))
+		printFatal("%s", err.Error())
 	}
 
-	result, err := client.RedeployStack(stack.Uid, gitRef, deployStrategy, deploymentProfile, services)
-	must(err)
-
-	if !c.Bool("listen") || result.Queued {
+	if !c.Bool("listen") || result.Queued || flagNoWait {
 		// its queued - just message and exit
 		fmt.Println(result.Message)
 	} else {
 		if result.AsyncActionId != nil {
 			// wait for the async action to complete
-			genericRes, err := client.WaitStackAsyncAction(*(result.AsyncActionId), stack.Uid, 15*time.Second, 120*time.Minute, true)
+			genericRes, err := waitStackAsyncAction(*(result.AsyncActionId), stack.Uid, 15*time.Second, 120*time.Minute, true)
 			if err != nil {
+				fireHooks(payload.finished(false, err.Error()))
 				printFatal(err.Error())
 			}
+			fireHooks(payload.finished(genericRes.Status, genericRes.Message))
 			printGenericResponse(*genericRes)
 		} else {
 			// tail the logs
 			go StartListen(stack)
 
 			stack, err = WaitStackBuild(stack.Uid, false)
-			must(err)
+			if err != nil {
+				fireHooks(payload.finished(false, err.Error()))
+				printFatal("%s", err.Error())
+			}
 
-			if stack.HealthCode == 2 || stack.HealthCode == 4 || stack.StatusCode == 2 || stack.StatusCode == 7 {
+			fireHooks(payload.finished(!stackBuildFailed(*stack), fmt.Sprintf("%s (%s)", stack.Status(), stack.Health())))
+			if stackBuildFailed(*stack) {
 				printFatal("Completed with some errors!")
 			} else {
 				fmt.Println("Completed successfully!")
@@ -115,3 +154,82 @@ func runRedeploy(c *cli.Context) {
 		}
 	}
 }
+
+// redeployOptions holds the deployment arguments shared by single and multi-stack redeploys
+type redeployOptions struct {
+	gitRef            string
+	services          []string
+	deployStrategy    string
+	deploymentProfile string
+}
+
+func redeployOptionsFromContext(c *cli.Context) redeployOptions {
+	return redeployOptions{
+		gitRef:            c.String("git-ref"),
+		services:          c.StringSlice("service"),
+		deployStrategy:    c.String("deploy-strategy"),
+		deploymentProfile: c.String("deployment-profile"),
+	}
+}
+
+// checks the options are applicable to the given stack
+func (o redeployOptions) validate(stack cloud66.Stack) error {
+	if o.deployStrategy != "" {
+		if o.deployStrategy != "serial" && o.deployStrategy != "parallel" &&
+			o.deployStrategy != "rolling" && o.deployStrategy != "fast" {
+			return errors.New("The \"deploy strategy\" argument can only be \"serial\", \"parallel\", \"rolling\" or \"fast\"")
+		}
+		if o.deployStrategy == "fast" && stack.Backend != "kubernetes" {
+			return errors.New("The \"fast\" deploy strategy only applies to Maestro stacks")
+		}
+		if o.deployStrategy == "rolling" && stack.Framework != "rails" && stack.Framework != "rack" {
+			return errors.New("The \"rolling\" deploy strategy only applies to Rails/Rack stacks")
+		}
+	}
+
+	if len(o.services) > 0 && stack.Framework != "docker" {
+		return errors.New("The \"service\" argument only applies to Maestro stacks")
+	}
+
+	return nil
+}
+
+// describes the deployment for policy checks
+func (o redeployOptions) policyAction(stack cloud66.Stack) policyAction {
+	action := policyAction{
+		Command:    "redeploy",
+		Deployment: true,
+		Strategy:   o.deployStrategy,
+	}
+
+	if stack.Framework == "docker" {
+		// only services deployed with an explicit reference carry a git ref
+		for _, service := range o.services {
+			if idx := strings.Index(service, ":"); idx != -1 {
+				action.GitRefs = append(action.GitRefs, service[idx+1:])
+			}
+		}
+	} else {
+		ref := o.gitRef
+		if ref == "" {
+			ref = stack.GitBranch
+		}
+		if ref != "" {
+			action.GitRefs = append(action.GitRefs, ref)
+		}
+	}
+
+	return action
+}
+
+// returns the pre-deploy hook payload for deploying the stack with these options
+func (o redeployOptions) hookPayload(stack cloud66.Stack) hookPayload {
+	payload := newHookPayload(hookPreDeploy, "redeploy", stack)
+	payload.GitRef = o.gitRef
+	payload.Services = o.services
+	return payload
+}
+
+func stackBuildFailed(stack cloud66.Stack) bool {
+	return stack.HealthCode == 2 || stack.HealthCode == 4 || stack.StatusCode == 2 || stack.StatusCode == 7
+}
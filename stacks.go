@@ -81,7 +81,7 @@ $ cx stacks create --name my_maestro_stack --service_yaml service.yml --manifest
 		cli.Command{
 			Name:  "redeploy",
 			Usage: "redeploys a stack",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.BoolFlag{
 					Name:  "y",
 					Usage: "answer yes to confirmations",
@@ -107,6 +107,31 @@ $ cx stacks create --name my_maestro_stack --service_yaml service.yml --manifest
 					Name:  "deployment-profile",
 					Usage: "use a named deployment profile that you have configured on your stack",
 				},
+				cli.BoolFlag{
+					Name:  "from-local",
+					Usage: "deploy the commit checked out in the current directory. It must be committed and pushed",
+				},
+				cli.StringFlag{
+					Name:  "stacks-matching",
+					Usage: "deploy every stack with a name matching this glob pattern (ie. 'customer-*')",
+				},
+				cli.StringFlag{
+					Name:  "stacks-file",
+					Usage: "deploy every stack listed in this file (one stack name per line)",
+				},
+				cli.IntFlag{
+					Name:  "max-parallel",
+					Usage: "[multiple stacks] maximum number of stacks to deploy at the same time",
+					Value: 5,
+				},
+				cli.BoolFlag{
+					Name:  "fail-fast",
+					Usage: "[multiple stacks] do not start any more deployments once one has failed",
+				},
+				cli.IntFlag{
+					Name:  "canary",
+					Usage: "[multiple stacks] deploy this many stacks first and only continue if they are healthy",
+				},
 				cli.StringFlag{
 					Name:  "stack,s",
 					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
@@ -115,7 +140,7 @@ $ cx stacks create --name my_maestro_stack --service_yaml service.yml --manifest
 					Name:  "environment,e",
 					Usage: "full or partial environment name",
 				},
-			},
+			}, deployGuardFlags()...),
 			Action: runRedeploy,
 			Description: `Enqueues redeployment of the stack. If the stack is already building, another build will be enqueued and performed immediately after the current one is finished.
 			
@@ -123,7 +148,36 @@ $ cx stacks create --name my_maestro_stack --service_yaml service.yml --manifest
    --git-ref will redeploy the specific branch, tag or hash git reference [classic stacks]
    --service is a repeateable option to deploy only the specified service(s). Including a reference (separated by a colon) will attempt to deploy that particular reference for that service [docker stacks]
    --deploy-strategy is an override for the deploy strategy you want to use. Options are serial, parallel, rolling (rails only) or fast (maestro only)
-   --deployment-profile allows you to specify a specific deployment profile to use			
+   --deployment-profile allows you to specify a specific deployment profile to use
+
+Deploying the local commit:
+   --from-local deploys the exact commit checked out in the current directory. The working tree must be clean and the commit
+   must be pushed to the git remote the stack is deployed from. A warning is shown if the local branch is not the one configured on the stack.
+   For Maestro stacks, the commit is deployed to every service built from this repository (as --service name:commit).
+   Combine with --listen to follow the deployment.
+
+Deploying multiple stacks:
+   --stacks-matching deploys every stack whose name matches the given glob pattern (combine with -e to limit the environment)
+   --stacks-file deploys every stack listed in the file. Each line holds a stack name, optionally followed by its environment. Empty lines and lines starting with # are ignored
+   --max-parallel is the maximum number of deployments running at the same time (default 5)
+   --fail-fast stops starting new deployments as soon as one of them fails
+   --canary deploys the given number of stacks first, and only continues with the rest if all of them finish healthy
+
+   When deploying multiple stacks, cx waits for all deployments to finish and prints a summary.
+   --listen streams the deployment logs of all stacks, each line prefixed with the stack name.
+   The command exits with a non-zero status if any of the deployments fail.
+
+Deployment policies (see 'cx help config update') are checked before deploying:
+   --override-policy "reason" deploys even if the policy forbids it. The reason is recorded in the local audit log
+   --approved-by names a person who approved the deployment. Repeatable for multiple approvers
+
+Stacks locked by someone else with 'cx stacks lock' are not deployed unless --force is used.
+
+Examples:
+$ cx stacks redeploy -s mystack --listen
+$ cx stacks redeploy -s mystack --from-local --listen
+$ cx stacks redeploy --stacks-matching 'customer-*' -e production --max-parallel 10 --canary 2
+$ cx stacks redeploy --stacks-file stacks.txt --fail-fast --listen
 `,
 		},
 		cli.Command{
@@ -139,7 +193,7 @@ For more information on restart command, please refer to help.cloud66.com
 		cli.Command{
 			Name:   "reboot",
 			Action: runStackReboot,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.BoolFlag{
 					Name:  "y",
 					Usage: "answer yes to confirmations",
@@ -160,7 +214,7 @@ For more information on restart command, please refer to help.cloud66.com
 					Name:  "stack,s",
 					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
 				},
-			},
+			}, deployGuardFlags()...),
 			Usage: "reboot servers in your stack",
 			Description: `reboot servers in your stack.
 
@@ -173,6 +227,9 @@ Valid values for this parameter are "serial" or "parallel"; "serial" reboots inv
 Note that for this only applies to web servers; non-web server will still be rebooted in parallel.
 If this value is left unspecified, Cloud 66 will determine the best strategy based on your infrastructure layout.
 
+Freeze windows and approvals of the deployment policy (see 'cx help config update') apply to reboots.
+Use --override-policy "reason" to reboot anyway. Use --force to reboot a stack locked by someone else with 'cx stacks lock'.
+
 Examples:
 $ cx stack reboot -s mystack
 $ cx stack reboot -s mystack --group web
@@ -221,6 +278,290 @@ However, rebasing or forcing a commit doesn't have any association with Cloud 66
 Examples:
 $ cx stacks listen
 $ cx stacks listen -s mystack
+`},
+		cli.Command{
+			Name:   "lock",
+			Action: runStackLock,
+			Usage:  "takes the deployment lock of a stack",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "why the stack is locked. This is shown to anyone trying to deploy it",
+				},
+				cli.StringFlag{
+					Name:  "ttl",
+					Usage: "how long the lock is held for, like 30m or 2h. Use 0 for a lock that doesn't expire",
+					Value: "1h",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "take over the lock if someone else holds it",
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			},
+			Description: `Takes an advisory deployment lock on a stack.
+
+The lock is kept in the ConfigStore of the stack so it is visible to everyone using the stack. It holds the owner (user@host),
+the reason, the TTL and when it was taken. While it is held, redeploy, stacks reboot, formations deploy and env-vars set
+with the immediately strategy refuse to run against the stack for anyone but the owner, unless --force is used.
+They also refuse to run when the lock can't be checked, for example because ConfigStore can't be reached.
+Expired locks are ignored.
+
+Examples:
+$ cx stacks lock -s mystack --reason "database migration" --ttl 2h
+$ cx stacks lock -s mystack --reason "release freeze" --ttl 0
+`},
+		cli.Command{
+			Name:   "unlock",
+			Action: runStackUnlock,
+			Usage:  "releases the deployment lock of a stack",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "remove the lock even if someone else holds it",
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			},
+			Description: `Releases the deployment lock of a stack. Use --force to remove a lock held by someone else.
+
+Examples:
+$ cx stacks unlock -s mystack
+$ cx stacks unlock -s mystack --force
+`},
+		cli.Command{
+			Name:   "lock-status",
+			Action: runStackLockStatus,
+			Usage:  "shows who holds the deployment lock of a stack",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			},
+			Description: `Shows who holds the deployment lock of a stack, why, and when it expires.
+
+Examples:
+$ cx stacks lock-status -s mystack
+`},
+		cli.Command{
+			Name:   "diff",
+			Action: runStackDiff,
+			Usage:  "compares the configuration of two stacks",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "against",
+					Usage: "name of the stack to compare with",
+				},
+				cli.StringFlag{
+					Name:  "against-environment",
+					Usage: "environment of the stack to compare with, if there are several stacks with the same name",
+				},
+				cli.StringSliceFlag{
+					Name:  "ignore",
+					Usage: "glob pattern of keys or sections to leave out. Repeatable, or comma separated",
+					Value: &cli.StringSlice{},
+				},
+				cli.BoolFlag{
+					Name:  "include-readonly",
+					Usage: "also compare readonly environment variables and settings, which are usually specific to each stack",
+				},
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "text (default) or json",
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			},
+			Description: `Compares the configuration of a stack with another one and shows the differences as a unified diff.
+
+The environment variables, settings, configuration files (like nginx) and the latest service.yml and manifest.yml are compared.
+Values of secret looking keys (passwords, tokens, keys...) and credentials in URLs are masked with a fingerprint,
+so you can still see if they are different without the values being shown.
+
+--ignore leaves out the keys or sections matching the given glob patterns. Patterns are matched against
+keys (ie. 'DATABASE_*'), sections (ie. 'env-vars', 'settings', 'configuration/nginx', 'service.yml', 'manifest.yml')
+and section/key (ie. 'settings/git_branch').
+
+Like diff, the command exits with status 0 if the stacks are the same, 1 if there are differences and 2 if
+they could not be compared, so it can be used in CI. Use --output json for a machine readable result.
+
+Examples:
+$ cx stacks diff -s app-staging --against app-production
+$ cx stacks diff -s app-staging --against app-production --ignore 'settings/git_branch,*_HOST' --ignore manifest.yml
+$ cx stacks diff -s app-staging --against app-production --output json
+`},
+		cli.Command{
+			Name:   "export",
+			Action: runStackExport,
+			Usage:  "writes the configuration of a stack to a directory",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory to export the stack to",
+				},
+				cli.BoolFlag{
+					Name:  "encrypt",
+					Usage: "encrypt the environment variables with the passphrase in $CX_EXPORT_PASSPHRASE",
+				},
+				cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "replace the files of an earlier export in the directory",
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			},
+			Description: `Writes the configuration of a stack to a directory, so it can be kept in git and applied with 'stacks import'.
+
+The directory holds:
+  stack.yml              where the export came from (stack name, environment, uid, time and cx version)
+  service.yml            the latest service.yml, for Maestro stacks
+  manifest.yml           the latest manifest.yml, if the stack has one
+  configurations/<type>  every configuration file of the stack, like configurations/nginx
+  env-vars.env           the environment variables, in dotenv format. Readonly variables are left out
+  env-vars.env.enc       the same, encrypted with AES-256-GCM when --encrypt is used
+  settings.yml           the stack settings and the settings of each server by name, in the 'settings plan' format
+  jobs.yml               the jobs of the stack. Export-only: 'stacks import' doesn't apply them
+  ssl-certificates.yml   the metadata of the SSL certificates, without their keys. Export-only as well
+
+Use --encrypt to encrypt the environment variables with the passphrase in $CX_EXPORT_PASSPHRASE.
+The same passphrase is needed to import them.
+
+Examples:
+$ cx stacks export -s mystack --dir ./mystack
+$ CX_EXPORT_PASSPHRASE=... cx stacks export -s mystack --dir ./mystack --encrypt --overwrite
+`},
+		cli.Command{
+			Name:   "import",
+			Action: runStackImport,
+			Usage:  "applies the configuration of a directory written by stacks export to a stack",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory with the exported stack",
+				},
+				cli.BoolFlag{
+					Name:  "plan",
+					Usage: "only show the changes, without applying them",
+				},
+				cli.StringFlag{
+					Name:  "apply-strategy",
+					Usage: "apply environment variable changes immediately, or during next deployment",
+				},
+				cli.BoolFlag{
+					Name:  "no-apply",
+					Usage: "only upload changed configuration files, without applying them",
+				},
+				cli.StringFlag{
+					Name:  "message,m",
+					Usage: "comments for the new service.yml, manifest.yml and configuration versions",
+				},
+				cli.BoolFlag{
+					Name:  "y",
+					Usage: "answer yes to confirmations",
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			}, append(deployGuardFlags(), revealFlags()...)...),
+			Description: `Applies the configuration of a directory written by 'stacks export' to a stack.
+
+The changes are shown first, with secret values masked, and applied once confirmed. Use --plan to only show them.
+Files missing from the directory are left as they are on the stack. Readonly environment variables and settings
+are skipped, and variables which are not in the directory are kept.
+jobs.yml and ssl-certificates.yml are export-only: jobs and SSL certificates are not imported, and a warning
+is shown when the directory has them.
+
+Changes are applied in this order: service.yml, manifest.yml, configuration files, settings and
+environment variables, which are applied as one batch with the apply-strategy ("immediately" by default).
+The deployment lock, deployment policies and pre-deploy hooks are checked before anything is changed.
+Use --force to import into a stack locked by someone else, and --override-policy "reason" to import during a freeze.
+
+Examples:
+$ cx stacks import -s mystack --dir ./mystack --plan
+$ cx stacks import -s mystack --dir ./mystack -m "sync from git" -y
+`},
+		cli.Command{
+			Name:   "drift",
+			Action: runStackDrift,
+			Usage:  "compares a stack with its desired state in a directory and exits with 1 if they differ, or 2 on errors",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory with the desired state, in the layout written by stacks export",
+				},
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "output format: text (default), json or junit",
+				},
+				cli.StringSliceFlag{
+					Name:  "ignore",
+					Usage: "resources to ignore, as glob patterns. Can be repeated or comma separated",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringFlag{
+					Name:  "environment,e",
+					Usage: "full or partial environment name",
+				},
+				cli.StringFlag{
+					Name:  "stack,s",
+					Usage: "full or partial stack name. This can be omitted if the current directory is a stack directory",
+				},
+			}, revealFlags()...),
+			Description: `Compares a stack with the desired state checked into a directory, and reports every resource which drifted.
+
+The directory has the layout written by 'stacks export' and only what is in it is checked: service.yml,
+manifest.yml, configurations/*, env-vars.env (or env-vars.env.enc) and settings.yml. Resources are named
+service.yml, manifest.yml, configuration/<type>, env-vars/<KEY>, settings/<key> and servers/<server>/<key>.
+
+A resource is "changed" when it differs, "missing" when it's not on the stack and "unexpected" for environment
+variables on the stack which are not in the directory. Readonly environment variables and settings are skipped.
+
+Resources which are allowed to vary can be ignored with --ignore or listed in a .driftignore file in the directory,
+one glob pattern per line. Patterns match the full resource name, its section (ie. env-vars) or its last part.
+
+The exit code is 0 without drift, 1 when there is drift and 2 when the stack could not be checked, so it can be
+used in CI. Files of the directory the stack doesn't have any version of are reported as missing, and any other
+error reading the stack fails the check. Secret values are masked unless revealed.
+
+Examples:
+$ cx stacks drift -s mystack --dir ./desired
+$ cx stacks drift -s mystack --dir ./desired --ignore 'env-vars/*_VERSION' --ignore settings/git.branch
+$ cx stacks drift -s mystack --dir ./desired -o junit > drift.xml
 `},
 		cli.Command{
 			Name:  "configure",
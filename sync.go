@@ -0,0 +1,769 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+	"github.com/cloud66-oss/cx/term"
+	"github.com/cloud66/cli"
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
+)
+
+var cmdSync = &Command{
+	Name:  "sync",
+	Build: buildBasicCommand,
+	Run:   runSync,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "server",
+			Usage: "server to sync with",
+		},
+		cli.StringFlag{
+			Name:  "servers",
+			Usage: "comma separated server names, roles or addresses to sync with. All servers with a role are used",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "sync with all servers of the stack",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "pattern of the files and directories to leave out, like *.log or tmp/cache. Repeatable",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show what would be copied without copying anything",
+		},
+		cli.BoolFlag{
+			Name:  "checksum",
+			Usage: "compare files by their SHA-256 checksum instead of their size and modification time, and verify the copies",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "maximum number of servers to sync with at the same time",
+			Value: 5,
+		},
+		gatewayKeyFlag(),
+	},
+	NeedsStack: true,
+	NeedsOrg:   false,
+	Short:      "syncs files between your local computer and servers, copying only what changed",
+	Long: `This command syncs a file or directory between your local computer and servers, in either direction.
+
+The path on the servers starts with a colon (:). Relative paths on the servers are in the home directory of the
+user. Syncing a directory makes the target directory have the same files, and a file is synced into the target
+directory.
+
+Only files which are missing or changed are copied. Files are compared by their size and modification time, or by
+their SHA-256 checksum with --checksum, which also verifies every copy once it is done. Files are copied over
+SFTP to a temporary file first, so an interrupted sync never leaves half written files behind, and running it
+again carries on from where it stopped. Files are never deleted from the target.
+
+Use --exclude to leave out files and directories by name or by path, like *.log or tmp/cache. --dry-run shows
+what would be copied.
+
+To sync with many servers, use --servers with server names, roles or addresses, or --all. All servers with a
+given role are used. When downloading from many servers, the files of each server go in a directory with its name.
+
+Servers behind a deploy gateway are reached through it (see --gateway-key).
+
+This command is only supported on Linux and OS X.
+
+Examples:
+$ cx sync -s mystack --server lion ./public :/var/deploy/mystack/web_head/current/public
+$ cx sync -s mystack --servers web --exclude '*.log' --exclude tmp ./config :config
+$ cx sync -s mystack --server lion --dry-run :/var/deploy/mystack/web_head/current/log ./logs
+$ cx sync -s mystack --servers web --checksum :/etc/nginx ./nginx
+`,
+}
+
+// suffix of the temporary files a sync copies to
+const syncTempSuffix = ".cx-sync"
+
+// syncOptions are the options of a sync
+type syncOptions struct {
+	Excludes []string
+	DryRun   bool
+	Checksum bool
+}
+
+// syncFile is a file to sync, by its slash separated path relative to the synced directory
+type syncFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Mode    os.FileMode
+}
+
+func newSyncFile(rel string, info os.FileInfo) syncFile {
+	return syncFile{Path: rel, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+}
+
+// syncFS is one side of a sync: the local disk or a server over SFTP
+type syncFS interface {
+	// lists the files under root, or root itself if it is a file. Returns the directory the paths are relative to
+	list(root string, excludes []string) (string, map[string]syncFile, error)
+	// returns the SHA-256 checksums of files relative to dir
+	checksums(dir string, paths []string) (map[string]string, error)
+	open(name string) (io.ReadCloser, error)
+	create(name string) (io.WriteCloser, error)
+	mkdirAll(name string) error
+	// sets the mode and modification time of the temporary file and moves it to name
+	finish(temp string, name string, file syncFile) error
+	remove(name string) error
+	join(elem ...string) string
+}
+
+// returns true if the path or its name matches any of the patterns
+func syncExcluded(rel string, excludes []string) bool {
+	if strings.HasSuffix(rel, syncTempSuffix) {
+		return true
+	}
+	for _, pattern := range excludes {
+		pattern = strings.Trim(pattern, "/")
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(rel)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// localSyncFS is the local disk
+type localSyncFS struct{}
+
+func (localSyncFS) list(root string, excludes []string) (string, map[string]syncFile, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", nil, err
+	}
+	files := make(map[string]syncFile)
+	if !info.IsDir() {
+		files[info.Name()] = newSyncFile(info.Name(), info)
+		return filepath.Dir(root), files, nil
+	}
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if syncExcluded(rel, excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files[rel] = newSyncFile(rel, info)
+		}
+		return nil
+	})
+	return root, files, err
+}
+
+func (fs localSyncFS) checksums(dir string, paths []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, rel := range paths {
+		file, err := os.Open(fs.join(dir, rel))
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		result[rel] = hex.EncodeToString(hash.Sum(nil))
+	}
+	return result, nil
+}
+
+func (localSyncFS) open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (localSyncFS) create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+func (localSyncFS) mkdirAll(name string) error {
+	return os.MkdirAll(name, 0755)
+}
+
+func (localSyncFS) finish(temp string, name string, file syncFile) error {
+	if err := os.Chmod(temp, file.Mode); err != nil {
+		return err
+	}
+	if err := os.Chtimes(temp, file.ModTime, file.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(temp, name)
+}
+
+func (localSyncFS) remove(name string) error {
+	return os.Remove(name)
+}
+
+func (localSyncFS) join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// remoteSyncFS is a server, reached over SFTP. Checksums are worked out on the server
+type remoteSyncFS struct {
+	conn   *sshConnection
+	client *sftp.Client
+}
+
+func newRemoteSyncFS(conn *sshConnection) (*remoteSyncFS, error) {
+	client, err := sftp.NewClient(conn.Client)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteSyncFS{conn: conn, client: client}, nil
+}
+
+func (fs *remoteSyncFS) Close() {
+	fs.client.Close()
+}
+
+func (fs *remoteSyncFS) list(root string, excludes []string) (string, map[string]syncFile, error) {
+	info, err := fs.client.Stat(root)
+	if err != nil {
+		return "", nil, err
+	}
+	files := make(map[string]syncFile)
+	if !info.IsDir() {
+		files[info.Name()] = newSyncFile(info.Name(), info)
+		return path.Dir(root), files, nil
+	}
+	walker := fs.client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return "", nil, err
+		}
+		rel := remoteRelPath(root, walker.Path())
+		if rel == "" {
+			continue
+		}
+		info := walker.Stat()
+		if syncExcluded(rel, excludes) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if info.Mode().IsRegular() {
+			files[rel] = newSyncFile(rel, info)
+		}
+	}
+	return root, files, nil
+}
+
+func (fs *remoteSyncFS) checksums(dir string, paths []string) (map[string]string, error) {
+	result := make(map[string]string)
+	// in batches, to keep the command line short
+	for len(paths) > 0 {
+		batch := paths
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		paths = paths[len(batch):]
+
+		var stdout, stderr bytes.Buffer
+		command := fmt.Sprintf("cd %s && sha256sum -- %s", shellquote.Join(dir), shellquote.Join(batch...))
+		if err := fs.conn.RunWith(command, false, nil, &stdout, &stderr); err != nil {
+			return nil, fmt.Errorf("unable to work out checksums on %s: %s %s", fs.conn.target.Host, err.Error(), strings.TrimSpace(stderr.String()))
+		}
+		scanner := bufio.NewScanner(&stdout)
+		for scanner.Scan() {
+			parts := strings.SplitN(scanner.Text(), "  ", 2)
+			if len(parts) == 2 {
+				result[parts[1]] = parts[0]
+			}
+		}
+	}
+	return result, nil
+}
+
+func (fs *remoteSyncFS) open(name string) (io.ReadCloser, error) {
+	return fs.client.Open(name)
+}
+
+func (fs *remoteSyncFS) create(name string) (io.WriteCloser, error) {
+	return fs.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+func (fs *remoteSyncFS) mkdirAll(name string) error {
+	return fs.client.MkdirAll(name)
+}
+
+func (fs *remoteSyncFS) finish(temp string, name string, file syncFile) error {
+	if err := fs.client.Chmod(temp, file.Mode); err != nil {
+		return err
+	}
+	if err := fs.client.Chtimes(temp, file.ModTime, file.ModTime); err != nil {
+		return err
+	}
+	if err := fs.client.PosixRename(temp, name); err == nil {
+		return nil
+	}
+	// servers without the posix-rename extension don't replace existing files
+	fs.client.Remove(name)
+	return fs.client.Rename(temp, name)
+}
+
+func (fs *remoteSyncFS) remove(name string) error {
+	return fs.client.Remove(name)
+}
+
+func (fs *remoteSyncFS) join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// syncPlan is what a sync copies from the source to the target
+type syncPlan struct {
+	source    syncFS
+	target    syncFS
+	sourceDir string
+	targetDir string
+	files     []syncFile
+	unchanged int
+	// checksums of the source files, with --checksum
+	checksums map[string]string
+}
+
+func (p *syncPlan) bytes() int64 {
+	var total int64
+	for _, file := range p.files {
+		total += file.Size
+	}
+	return total
+}
+
+// works out the files of the source which are missing or changed in the target
+func planSync(source syncFS, target syncFS, sourceRoot string, targetRoot string, options syncOptions) (*syncPlan, error) {
+	sourceDir, sourceFiles, err := source.list(sourceRoot, options.Excludes)
+	if err != nil {
+		return nil, err
+	}
+	plan := &syncPlan{source: source, target: target, sourceDir: sourceDir, targetDir: targetRoot}
+
+	targetDir, targetFiles, err := target.list(targetRoot, options.Excludes)
+	if os.IsNotExist(err) {
+		targetFiles = map[string]syncFile{}
+	} else if err != nil {
+		return nil, err
+	} else if targetDir != targetRoot {
+		return nil, fmt.Errorf("%s is a file. Give a directory to sync to", targetRoot)
+	}
+
+	paths := make([]string, 0, len(sourceFiles))
+	for rel := range sourceFiles {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var candidates []string
+	for _, rel := range paths {
+		sourceFile := sourceFiles[rel]
+		targetFile, found := targetFiles[rel]
+		switch {
+		case !found || targetFile.Size != sourceFile.Size:
+			plan.files = append(plan.files, sourceFile)
+		case options.Checksum:
+			candidates = append(candidates, rel)
+		case !sourceFile.ModTime.Truncate(time.Second).Equal(targetFile.ModTime.Truncate(time.Second)):
+			plan.files = append(plan.files, sourceFile)
+		default:
+			plan.unchanged++
+		}
+	}
+
+	if options.Checksum {
+		if plan.checksums, err = source.checksums(sourceDir, paths); err != nil {
+			return nil, err
+		}
+		targetChecksums, err := target.checksums(targetRoot, candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range candidates {
+			if targetChecksums[rel] != plan.checksums[rel] {
+				plan.files = append(plan.files, sourceFiles[rel])
+			} else {
+				plan.unchanged++
+			}
+		}
+		sort.Slice(plan.files, func(i, j int) bool { return plan.files[i].Path < plan.files[j].Path })
+	}
+	return plan, nil
+}
+
+// copies the files of the plan, verifying their checksums afterwards with --checksum
+func (p *syncPlan) run(progress *syncProgress) error {
+	for _, file := range p.files {
+		if err := p.copy(file, progress); err != nil {
+			return err
+		}
+	}
+	if p.checksums == nil || len(p.files) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(p.files))
+	for _, file := range p.files {
+		paths = append(paths, file.Path)
+	}
+	copied, err := p.target.checksums(p.targetDir, paths)
+	if err != nil {
+		return err
+	}
+	for _, rel := range paths {
+		if copied[rel] != p.checksums[rel] {
+			return fmt.Errorf("checksum of %s doesn't match after copying it", p.target.join(p.targetDir, rel))
+		}
+	}
+	return nil
+}
+
+func (p *syncPlan) copy(file syncFile, progress *syncProgress) error {
+	from := p.source.join(p.sourceDir, file.Path)
+	to := p.target.join(p.targetDir, file.Path)
+	if err := p.target.mkdirAll(p.target.join(to, "..")); err != nil {
+		return err
+	}
+
+	source, err := p.source.open(from)
+	if err != nil {
+		return fmt.Errorf("%s: %s", from, err.Error())
+	}
+	defer source.Close()
+
+	temp := to + syncTempSuffix
+	target, err := p.target.create(temp)
+	if err != nil {
+		return fmt.Errorf("%s: %s", to, err.Error())
+	}
+	_, err = io.Copy(target, &progressReader{Reader: source, progress: progress})
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = p.target.finish(temp, to, file)
+	}
+	if err != nil {
+		p.target.remove(temp)
+		return fmt.Errorf("%s: %s", to, err.Error())
+	}
+	return nil
+}
+
+// syncResult is the outcome of syncing with a server
+type syncResult struct {
+	Server    cloud66.Server
+	Copied    int
+	Unchanged int
+	Bytes     int64
+	Err       error
+}
+
+func (r syncResult) status() string {
+	if r.Err != nil {
+		return "error: " + r.Err.Error()
+	}
+	return "ok"
+}
+
+func runSync(c *cli.Context) {
+	if runtime.GOOS == "windows" {
+		printFatal("Not supported on Windows")
+	}
+	flagGatewayKey = c.String("gateway-key")
+
+	if len(c.Args()) != 2 {
+		printFatal("Give the source and the target. Paths on the servers start with :, like cx sync -s mystack --server web ./public :/tmp/public")
+	}
+	source, target := c.Args()[0], c.Args()[1]
+	upload := strings.HasPrefix(target, ":")
+	if upload == strings.HasPrefix(source, ":") {
+		printFatal("Either the source or the target should be a path on the servers, starting with :")
+	}
+	localPath, remotePath := source, strings.TrimPrefix(target, ":")
+	if !upload {
+		localPath, remotePath = target, strings.TrimPrefix(source, ":")
+	}
+	if strings.HasPrefix(localPath, "~/") {
+		localPath = expandPath(localPath)
+	}
+	if remotePath == "" {
+		remotePath = "."
+	}
+
+	options := syncOptions{
+		Excludes: splitCommaValues(c.StringSlice("exclude")),
+		DryRun:   c.Bool("dry-run"),
+		Checksum: c.Bool("checksum"),
+	}
+	for _, pattern := range options.Excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			printFatal("Invalid exclude pattern %q", pattern)
+		}
+	}
+
+	stack := mustStack(c)
+	servers, err := client.Servers(stack.Uid)
+	must(err)
+
+	var targets []cloud66.Server
+	switch {
+	case c.Bool("all"):
+		targets = servers
+	case c.String("servers") != "":
+		targets, err = findServers(servers, splitCommaValues([]string{c.String("servers")}))
+		must(err)
+	case c.String("server") != "":
+		server, err := findServer(servers, c.String("server"))
+		must(err)
+		if server == nil {
+			printFatal("Server '%s' not found", c.String("server"))
+		}
+		targets = []cloud66.Server{*server}
+	default:
+		printFatal("No server given. Use --server, --servers or --all")
+	}
+	if len(targets) == 0 {
+		printFatal("No servers found in %s", stack.Name)
+	}
+
+	var progress *syncProgress
+	if !options.DryRun && term.IsTerminal(os.Stderr) {
+		progress = startSyncProgress(os.Stderr)
+	}
+	results := syncServers(targets, localPath, remotePath, upload, options, c.Int("max-parallel"), dialServerForRun, progress, os.Stdout)
+	progress.Stop()
+
+	printSyncSummary(os.Stdout, results, options.DryRun)
+	for _, result := range results {
+		if result.Err != nil {
+			closeSessionGateways()
+			os.Exit(1)
+		}
+	}
+}
+
+// syncs the local path with the remote path on each server, at most maxParallel at a time. Downloads from many
+// servers go in a directory per server
+func syncServers(servers []cloud66.Server, localPath string, remotePath string, upload bool, options syncOptions, maxParallel int, dial serverDialer, progress *syncProgress, out io.Writer) []syncResult {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	results := make([]syncResult, len(servers))
+	var outputMutex sync.Mutex
+	queue := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < maxParallel && i < len(servers); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for idx := range queue {
+				server := servers[idx]
+				local := localPath
+				if !upload && len(servers) > 1 {
+					local = filepath.Join(localPath, server.Name)
+				}
+				results[idx] = syncServer(server, local, remotePath, upload, options, dial, progress, func(file syncFile) {
+					outputMutex.Lock()
+					defer outputMutex.Unlock()
+					fmt.Fprintf(out, "[%s] %s (%s)\n", server.Name, file.Path, formatBytes(file.Size))
+				})
+			}
+		}()
+	}
+	for idx := range servers {
+		queue <- idx
+	}
+	close(queue)
+	workers.Wait()
+	return results
+}
+
+func syncServer(server cloud66.Server, localPath string, remotePath string, upload bool, options syncOptions, dial serverDialer, progress *syncProgress, dryRunFile func(syncFile)) syncResult {
+	result := syncResult{Server: server}
+	conn, err := dial(server)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+	remote, err := newRemoteSyncFS(conn)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer remote.Close()
+
+	var plan *syncPlan
+	if upload {
+		plan, err = planSync(localSyncFS{}, remote, localPath, remotePath, options)
+	} else {
+		plan, err = planSync(remote, localSyncFS{}, remotePath, localPath, options)
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Copied, result.Unchanged, result.Bytes = len(plan.files), plan.unchanged, plan.bytes()
+
+	if options.DryRun {
+		for _, file := range plan.files {
+			dryRunFile(file)
+		}
+		return result
+	}
+	progress.addTotal(result.Bytes)
+	result.Err = plan.run(progress)
+	return result
+}
+
+func printSyncSummary(out io.Writer, results []syncResult, dryRun bool) {
+	copied := "COPIED"
+	if dryRun {
+		copied = "TO COPY"
+	}
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "SERVER", "ADDRESS", copied, "UNCHANGED", "SIZE", "RESULT")
+	for _, result := range results {
+		listRec(w, result.Server.Name, result.Server.Address, result.Copied, result.Unchanged, formatBytes(result.Bytes), result.status())
+	}
+}
+
+// syncProgress draws a progress bar of the bytes copied by a sync
+type syncProgress struct {
+	out     io.Writer
+	total   int64
+	done    int64
+	started time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func startSyncProgress(out io.Writer) *syncProgress {
+	progress := &syncProgress{out: out, started: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(progress.stopped)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progress.stop:
+				progress.draw()
+				fmt.Fprintln(progress.out)
+				return
+			case <-ticker.C:
+				progress.draw()
+			}
+		}
+	}()
+	return progress
+}
+
+// progress is nil when it isn't shown
+func (p *syncProgress) addTotal(bytes int64) {
+	if p != nil {
+		atomic.AddInt64(&p.total, bytes)
+	}
+}
+
+func (p *syncProgress) add(bytes int64) {
+	if p != nil {
+		atomic.AddInt64(&p.done, bytes)
+	}
+}
+
+func (p *syncProgress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+}
+
+func (p *syncProgress) draw() {
+	total, done := atomic.LoadInt64(&p.total), atomic.LoadInt64(&p.done)
+	fmt.Fprintf(p.out, "\r%s", progressLine(done, total, time.Since(p.started)))
+}
+
+// a line like [=========>          ]  45% 12.3 MB / 27.1 MB  2.1 MB/s
+func progressLine(done int64, total int64, elapsed time.Duration) string {
+	const width = 30
+	ratio := 1.0
+	if total > 0 {
+		ratio = float64(done) / float64(total)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	speed := "-"
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		speed = formatBytes(int64(float64(done)/seconds)) + "/s"
+	}
+	return fmt.Sprintf("[%s] %3d%% %s / %s  %s   ", bar, int(ratio*100), formatBytes(done), formatBytes(total), speed)
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TB", value)
+}
+
+// progressReader counts the bytes read towards the progress
+type progressReader struct {
+	io.Reader
+	progress *syncProgress
+}
+
+func (r *progressReader) Read(data []byte) (int, error) {
+	n, err := r.Reader.Read(data)
+	r.progress.add(int64(n))
+	return n, err
+}
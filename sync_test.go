@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sync", func() {
+	var restoreHome func()
+	var dir string
+	var sshServers map[string]*testSSHServer
+	var servers []cloud66.Server
+	var dial serverDialer
+
+	writeFile := func(name string, content string) {
+		name = filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(name), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(name, []byte(content), 0644)).To(Succeed())
+	}
+	readFile := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	BeforeEach(func() {
+		restoreHome = useTempHome()
+		var err error
+		dir, err = ioutil.TempDir("", "cx-sync")
+		Expect(err).NotTo(HaveOccurred())
+
+		sshServers = map[string]*testSSHServer{}
+		servers = []cloud66.Server{{Uid: "1", Name: "lion"}, {Uid: "2", Name: "tiger"}}
+		for idx := range servers {
+			sshServer := startTestSSHServer()
+			sshServers[servers[idx].Name] = sshServer
+			servers[idx].Address = sshServer.Address()
+		}
+		dial = func(server cloud66.Server) (*sshConnection, error) {
+			sshServer := sshServers[server.Name]
+			return dialSSH(sshTarget{Uid: server.Uid, User: "cloud66-user", Host: sshServer.Address(), KeyFile: sshServer.KeyFile})
+		}
+	})
+
+	AfterEach(func() {
+		for _, sshServer := range sshServers {
+			sshServer.Close()
+		}
+		os.RemoveAll(dir)
+		restoreHome()
+	})
+
+	It("should upload only what changed, leaving out excluded files", func() {
+		writeFile("local/app.rb", "puts 1")
+		writeFile("local/config/app.yml", "a: 1")
+		writeFile("local/log/app.log", "log")
+		options := syncOptions{Excludes: []string{"log"}}
+
+		var out bytes.Buffer
+		results := syncServers(servers[:1], filepath.Join(dir, "local"), filepath.Join(dir, "remote"), true, options, 5, dial, nil, &out)
+		Expect(results[0].Err).NotTo(HaveOccurred())
+		Expect(results[0].Copied).To(Equal(2))
+		Expect(readFile("remote/config/app.yml")).To(Equal("a: 1"))
+		_, err := os.Stat(filepath.Join(dir, "remote/log"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		// same size, but modified later
+		writeFile("local/app.rb", "puts 2")
+		later := time.Now().Add(time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, "local/app.rb"), later, later)).To(Succeed())
+		results = syncServers(servers[:1], filepath.Join(dir, "local"), filepath.Join(dir, "remote"), true, options, 5, dial, nil, &out)
+		Expect(results[0].Copied).To(Equal(1))
+		Expect(results[0].Unchanged).To(Equal(1))
+		Expect(readFile("remote/app.rb")).To(Equal("puts 2"))
+	})
+
+	It("should only list the files to copy on a dry run", func() {
+		writeFile("local/app.rb", "puts 1")
+
+		var out bytes.Buffer
+		results := syncServers(servers[:1], filepath.Join(dir, "local"), filepath.Join(dir, "remote"), true, syncOptions{DryRun: true}, 5, dial, nil, &out)
+		Expect(results[0].Copied).To(Equal(1))
+		Expect(out.String()).To(Equal("[lion] app.rb (6 B)\n"))
+		_, err := os.Stat(filepath.Join(dir, "remote"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should download from each server into its own directory", func() {
+		writeFile("remote/app.log", "log")
+
+		var out bytes.Buffer
+		results := syncServers(servers, filepath.Join(dir, "local"), filepath.Join(dir, "remote"), false, syncOptions{}, 5, dial, nil, &out)
+		for _, result := range results {
+			Expect(result.Err).NotTo(HaveOccurred())
+		}
+		Expect(readFile("local/lion/app.log")).To(Equal("log"))
+		Expect(readFile("local/tiger/app.log")).To(Equal("log"))
+	})
+
+	It("should compare files by checksum", func() {
+		writeFile("a/same.txt", "same")
+		writeFile("a/changed.txt", "abcd")
+		writeFile("b/same.txt", "same")
+		writeFile("b/changed.txt", "efgh")
+		modTime := time.Now().Add(-time.Hour)
+		for _, name := range []string{"a/same.txt", "a/changed.txt", "b/same.txt", "b/changed.txt"} {
+			Expect(os.Chtimes(filepath.Join(dir, name), modTime, modTime)).To(Succeed())
+		}
+
+		plan, err := planSync(localSyncFS{}, localSyncFS{}, filepath.Join(dir, "a"), filepath.Join(dir, "b"), syncOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.files).To(BeEmpty())
+
+		plan, err = planSync(localSyncFS{}, localSyncFS{}, filepath.Join(dir, "a"), filepath.Join(dir, "b"), syncOptions{Checksum: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.files).To(HaveLen(1))
+		Expect(plan.files[0].Path).To(Equal("changed.txt"))
+		Expect(plan.run(nil)).To(Succeed())
+		Expect(readFile("b/changed.txt")).To(Equal("abcd"))
+	})
+
+	It("should list relative remote roots", func() {
+		writeFile("remote/.env", "env")
+		writeFile("remote/public/app.css", "css")
+		cwd, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Chdir(cwd)
+
+		conn, err := dial(servers[0])
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		remote, err := newRemoteSyncFS(conn)
+		Expect(err).NotTo(HaveOccurred())
+		defer remote.Close()
+
+		// relative paths are resolved by the server in its working directory, which is this one
+		Expect(os.Chdir(dir)).To(Succeed())
+		_, files, err := remote.list("./remote", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		Expect(files).To(HaveKey(".env"))
+		Expect(files).To(HaveKey("public/app.css"))
+
+		Expect(os.Chdir(filepath.Join(dir, "remote"))).To(Succeed())
+		_, files, err = remote.list(".", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		Expect(files).To(HaveKey(".env"))
+		Expect(files).To(HaveKey("public/app.css"))
+	})
+})
@@ -2,18 +2,54 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/cloud66-oss/cloud66"
+	"github.com/kballard/go-shellquote"
 
 	"github.com/cloud66/cli"
 )
 
 var cmdTail = &Command{
-	Name:       "tail",
-	Build:      buildBasicCommand,
-	Run:        runTail,
+	Name:  "tail",
+	Build: buildBasicCommand,
+	Run:   runTail,
+	Flags: []cli.Flag{
+		gatewayKeyFlag(),
+		cli.StringFlag{
+			Name:  "servers",
+			Usage: "comma separated server names, roles or addresses to tail the log on. All servers with a role are used",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "tail the log on all servers of the stack",
+		},
+		cli.StringFlag{
+			Name:  "service,svc",
+			Usage: "tail the logs of all containers of the service [docker/kubernetes stacks only]",
+		},
+		cli.StringFlag{
+			Name:  "container,cnt",
+			Usage: "tail the logs of the pod/container [docker/kubernetes stacks only]",
+		},
+		cli.IntFlag{
+			Name:  "lines,n",
+			Usage: "number of lines to show before following the log",
+			Value: 10,
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show container logs since a time (like 2013-01-02T13:23:37) or a duration (like 42m) [containers and services only]",
+		},
+		cli.StringFlag{
+			Name:  "grep",
+			Usage: "only show lines matching the pattern. The lines are filtered on the server",
+		},
+	},
 	NeedsStack: true,
 	NeedsOrg:   false,
 	Short:      "shows and tails the logfile specified on the given server",
@@ -21,80 +57,285 @@ var cmdTail = &Command{
 Logs are read from stack's log folder (current/log) and should be the full logfile name
 including the extension.
 
+Other logs can be given with an absolute path, or with one of these names:
+  app     the log of the stack environment in current/log, like production.log
+  nginx   the Nginx access and error logs
+  syslog  the system log
+
 Server names and roles are case insensitive and will work with the starting characters as well.
 
+To follow the log on many servers, use --servers with server names, roles or addresses, or --all. All servers
+with a given role are used and each line is prefixed with the server name.
+
+On docker and kubernetes stacks, use --container to follow the logs of a container, or --service to follow the
+logs of all containers of a service. They are read with docker logs or kubectl logs, and --since can be used to
+start from a time or duration ago.
+
+--lines sets how many lines are shown before following the log, and --grep filters the lines on the server so
+only the matching ones are sent.
+
+Servers behind a deploy gateway are tailed through it, with --gateway-key or the key of the gateway in the profile.
+
 This command is only supported on Linux and OS X.
 
 Examples:
 $ cx tail -s mystack production.log
 $ cx tail -s mystack 52.65.34.98 nginx_error.log
 $ cx tail -s mystack web staging.log
+$ cx tail -s mystack --gateway-key ~/.ssh/bastion_key db postgresql.log
+$ cx tail -s mystack --servers web nginx
+$ cx tail -s mystack --all --grep ERROR --lines 100 app
+$ cx tail -s mystack lion /var/log/cloud66/starter.log
+$ cx tail -s mystack --service web --since 10m
+$ cx tail -s mystack --container web-123abc
 `,
 }
 
+// tailOptions are the options of following logs
+type tailOptions struct {
+	Lines int
+	Since string
+	Grep  string
+}
+
+// logTarget is a log followed with a command on a server
+type logTarget struct {
+	Server cloud66.Server
+	// what is followed, like a log file or a container
+	Log string
+	// prefix of the lines of the log when following many
+	Label   string
+	Command string
+}
+
 func runTail(c *cli.Context) {
 	if runtime.GOOS == "windows" {
 		printFatal("Not supported on Windows")
 		os.Exit(2)
 	}
+	flagGatewayKey = c.String("gateway-key")
 
 	stack := mustStack(c)
-
-	if len(c.Args()) != 2 {
-		//cmd.printUsage()
-		os.Exit(2)
+	options := tailOptions{Lines: c.Int("lines"), Since: c.String("since"), Grep: c.String("grep")}
+	if options.Lines < 0 {
+		printFatal("--lines can't be negative")
 	}
 
-	// get the server
-	serverName := c.Args()[0]
-	logName := c.Args()[1]
-
 	servers, err := client.Servers(stack.Uid)
 	if err != nil {
-		printFatal(err.Error())
+		printFatal("%s", err.Error())
 	}
 
-	server, err := findServer(servers, serverName)
-	if err != nil {
-		printFatal(err.Error())
+	var targets []logTarget
+	if c.String("service") != "" || c.String("container") != "" {
+		if c.String("service") != "" && c.String("container") != "" {
+			printFatal("Only one of options service OR container may be specified")
+		}
+		if c.String("servers") != "" || c.Bool("all") || len(c.Args()) > 0 {
+			printFatal("Servers and logs can't be given with a service or container")
+		}
+		targets, err = containerLogTargets(*stack, servers, c.String("service"), c.String("container"), options)
+		must(err)
+	} else {
+		if options.Since != "" {
+			printFatal("--since can only be used with --service or --container")
+		}
+		var logName string
+		var targetServers []cloud66.Server
+		if c.String("servers") != "" || c.Bool("all") {
+			if len(c.Args()) != 1 {
+				printFatal("Give the log to tail. Use cx tail -s mystack --servers web <log>")
+			}
+			logName = c.Args()[0]
+			if c.Bool("all") {
+				targetServers = servers
+			} else {
+				targetServers, err = findServers(servers, splitCommaValues([]string{c.String("servers")}))
+				must(err)
+			}
+		} else {
+			if len(c.Args()) != 2 {
+				printFatal("Give the server and the log to tail. Use cx tail -s mystack <server> <log>")
+			}
+			serverName := c.Args()[0]
+			logName = c.Args()[1]
+			server, err := findServer(servers, serverName)
+			if err != nil {
+				printFatal("%s", err.Error())
+			}
+			if server == nil {
+				printFatal("Server '%s' not found", serverName)
+			}
+			targetServers = []cloud66.Server{*server}
+		}
+
+		command := tailFileCommand(*stack, logName, options)
+		for _, server := range targetServers {
+			targets = append(targets, logTarget{Server: server, Log: logName, Label: server.Name, Command: command})
+		}
+	}
+	if len(targets) == 0 {
+		printFatal("No logs found to tail in %s", stack.Name)
 	}
 
-	if server == nil {
-		printFatal("Server '" + serverName + "' not found")
+	for _, target := range targets {
+		fmt.Fprintf(os.Stderr, "Following %s on %s (%s)...\n", target.Log, target.Server.Name, target.Server.Address)
 	}
+	if !followLogs(targets, dialServerForRun, os.Stdout, os.Stderr) {
+		closeSessionGateways()
+		os.Exit(1)
+	}
+}
 
-	fmt.Printf("Server: %s\n", server.Name)
+// the paths of a log given by name, preset or absolute path
+func tailLogPaths(stack cloud66.Stack, logName string) []string {
+	switch {
+	case strings.HasPrefix(logName, "/"):
+		return []string{logName}
+	case logName == "app":
+		return []string{fmt.Sprintf("%s/web_head/current/log/%s.log", stack.DeployDir, stack.Environment)}
+	case logName == "nginx":
+		return []string{"/var/log/nginx/access.log", "/var/log/nginx/error.log"}
+	case logName == "syslog":
+		return []string{"/var/log/syslog"}
+	}
+	return []string{fmt.Sprintf("%s/web_head/current/log/%s", stack.DeployDir, logName)}
+}
 
-	err = tailLog(*stack, *server, logName)
-	if err != nil {
-		printFatal(err.Error())
+// the command following a log file. Logs outside the stack may need root to read them
+func tailFileCommand(stack cloud66.Stack, logName string, options tailOptions) string {
+	paths := tailLogPaths(stack, logName)
+	command := fmt.Sprintf("tail -n %d -F %s", options.Lines, shellquote.Join(paths...))
+	if !strings.HasPrefix(paths[0], stack.DeployDir+"/") {
+		command = "sudo " + command
 	}
+	return grepLogCommand(command, options.Grep)
 }
 
-func tailLog(stack cloud66.Stack, server cloud66.Server, logName string) error {
-	sshFile, err := prepareLocalSshKey(server)
-	must(err)
-
-	// open the firewall
-	var timeToOpen = 2
-	fmt.Printf("Opening access to %s...\n", server.Address)
-	genericRes, err := client.LeaseSync(server.StackUid, nil, &timeToOpen, nil, &server.Uid)
-	must(err)
-	if genericRes.Status != true {
-		printFatal("Unable to open server lease")
-	}
-
-	fmt.Printf("Connecting to %s (%s)...\n", server.Name, server.Address)
-	return startProgram("ssh", []string{
-		server.UserName + "@" + server.Address,
-		"-i", sshFile,
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "CheckHostIP=no",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "LogLevel=QUIET",
-		"-o", "IdentitiesOnly=yes",
-		"-A",
-		"-p", "22",
-		fmt.Sprintf("tail -f '%s/web_head/current/log/%s'", stack.DeployDir, logName),
-	})
+// the logs of a container or of all containers of a service, read on the server running them or on the kubernetes master
+func containerLogTargets(stack cloud66.Stack, servers []cloud66.Server, serviceName string, containerName string, options tailOptions) ([]logTarget, error) {
+	if stack.Backend != "docker" && stack.Backend != "kubernetes" {
+		return nil, fmt.Errorf("The service & container options only apply to docker/kubernetes stacks")
+	}
+
+	var master *cloud66.Server
+	if stack.Backend == "kubernetes" {
+		for idx := range servers {
+			if servers[idx].IsKubernetesMaster {
+				master = &servers[idx]
+				break
+			}
+		}
+		if master == nil {
+			return nil, fmt.Errorf("Master server can not be determined")
+		}
+	}
+
+	var containers []cloud66.Container
+	if serviceName != "" {
+		var err error
+		if containers, err = client.GetContainers(stack.Uid, nil, &serviceName); err != nil {
+			return nil, err
+		}
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("No containers found for service %s", serviceName)
+		}
+	} else if stack.Backend == "kubernetes" {
+		// the container is the name of the pod, like with cx run
+		containers = []cloud66.Container{{Uid: containerName, Name: containerName}}
+	} else {
+		container, err := client.GetContainer(stack.Uid, containerName)
+		if err != nil {
+			return nil, err
+		}
+		if container == nil {
+			return nil, fmt.Errorf("Container '%s' not found", containerName)
+		}
+		containers = []cloud66.Container{*container}
+	}
+
+	var targets []logTarget
+	for _, container := range containers {
+		label := container.Name
+		if label == "" {
+			label = container.Uid
+		}
+		if master != nil {
+			targets = append(targets, logTarget{Server: *master, Log: label, Label: label, Command: kubernetesLogCommand(stack.Namespace(), container.Uid, options)})
+			continue
+		}
+		server, err := findServer(servers, container.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		if server == nil {
+			return nil, fmt.Errorf("Server '%s' of container %s not found", container.ServerName, label)
+		}
+		targets = append(targets, logTarget{Server: *server, Log: label, Label: label, Command: dockerLogCommand(container.Uid, options)})
+	}
+	return targets, nil
+}
+
+func dockerLogCommand(containerUid string, options tailOptions) string {
+	command := fmt.Sprintf("sudo docker logs -f --tail %d", options.Lines)
+	if options.Since != "" {
+		command += " --since " + shellquote.Join(options.Since)
+	}
+	return grepLogCommand(fmt.Sprintf("%s %s 2>&1", command, shellquote.Join(containerUid)), options.Grep)
+}
+
+func kubernetesLogCommand(namespace string, podName string, options tailOptions) string {
+	command := fmt.Sprintf("kubectl --namespace %s logs -f --tail=%d", shellquote.Join(namespace), options.Lines)
+	if options.Since != "" {
+		command += " --since=" + shellquote.Join(options.Since)
+	}
+	return grepLogCommand(fmt.Sprintf("%s %s 2>&1", command, shellquote.Join(podName)), options.Grep)
+}
+
+// filters the log on the server. The output is line buffered so matches show up as they are written
+func grepLogCommand(command string, pattern string) string {
+	if pattern == "" {
+		return command
+	}
+	return fmt.Sprintf("%s | grep --line-buffered -e %s", command, shellquote.Join(pattern))
+}
+
+// follows all logs at the same time until they end. With more than one log, each line is prefixed with its label.
+// Returns false if any of them failed
+func followLogs(targets []logTarget, dial serverDialer, stdout io.Writer, stderr io.Writer) bool {
+	var outputMutex sync.Mutex
+	var wg sync.WaitGroup
+	ok := true
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target logTarget) {
+			defer wg.Done()
+			out, errOut := stdout, stderr
+			if len(targets) > 1 {
+				prefixedOut := &prefixWriter{prefix: "[" + target.Label + "] ", out: stdout, mutex: &outputMutex}
+				prefixedErr := &prefixWriter{prefix: "[" + target.Label + "] ", out: stderr, mutex: &outputMutex}
+				defer prefixedOut.Flush()
+				defer prefixedErr.Flush()
+				out, errOut = prefixedOut, prefixedErr
+			}
+
+			conn, err := dial(target.Server)
+			code := 0
+			if err == nil {
+				code, err = runWithExitCode(conn, target.Command, nil, out, errOut)
+				conn.Close()
+			}
+			if err == nil && code != 0 {
+				err = fmt.Errorf("exited with code %d", code)
+			}
+			if err != nil {
+				outputMutex.Lock()
+				ok = false
+				printError("Unable to follow %s on %s: %s", target.Log, target.Server.Name, err.Error())
+				outputMutex.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+	return ok
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/cloud66-oss/cloud66"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tail", func() {
+	stack := cloud66.Stack{DeployDir: "/var/deploy/mystack", Environment: "production"}
+
+	It("should follow logs by name, preset or path", func() {
+		Expect(tailFileCommand(stack, "production.log", tailOptions{Lines: 10})).To(Equal("tail -n 10 -F /var/deploy/mystack/web_head/current/log/production.log"))
+		Expect(tailFileCommand(stack, "app", tailOptions{Lines: 10})).To(Equal("tail -n 10 -F /var/deploy/mystack/web_head/current/log/production.log"))
+		Expect(tailFileCommand(stack, "nginx", tailOptions{Lines: 50})).To(Equal("sudo tail -n 50 -F /var/log/nginx/access.log /var/log/nginx/error.log"))
+		Expect(tailFileCommand(stack, "/var/log/my app.log", tailOptions{Lines: 0, Grep: "it's"})).To(Equal(`sudo tail -n 0 -F '/var/log/my app.log' | grep --line-buffered -e it\'s`))
+	})
+
+	It("should follow container logs with docker and kubectl", func() {
+		options := tailOptions{Lines: 20, Since: "10m", Grep: "ERROR"}
+		Expect(dockerLogCommand("abc123", options)).To(Equal("sudo docker logs -f --tail 20 --since 10m abc123 2>&1 | grep --line-buffered -e ERROR"))
+		Expect(kubernetesLogCommand("mystack", "web-5d8f", options)).To(Equal("kubectl --namespace mystack logs -f --tail=20 --since=10m web-5d8f 2>&1 | grep --line-buffered -e ERROR"))
+	})
+
+	It("should prefix the logs of many servers", func() {
+		restoreHome := useTempHome()
+		defer restoreHome()
+		sshServers := map[string]*testSSHServer{"lion": startTestSSHServer(), "tiger": startTestSSHServer()}
+		defer func() {
+			for _, sshServer := range sshServers {
+				sshServer.Close()
+			}
+		}()
+		dial := func(server cloud66.Server) (*sshConnection, error) {
+			sshServer := sshServers[server.Name]
+			return dialSSH(sshTarget{Uid: server.Uid, User: "cloud66-user", Host: sshServer.Address(), KeyFile: sshServer.KeyFile})
+		}
+
+		targets := []logTarget{
+			{Server: cloud66.Server{Uid: "1", Name: "lion"}, Log: "app", Label: "lion", Command: "tail app"},
+			{Server: cloud66.Server{Uid: "2", Name: "tiger"}, Log: "app", Label: "tiger", Command: "tail app"},
+		}
+		var stdout bytes.Buffer
+		Expect(followLogs(targets, dial, &stdout, &stdout)).To(BeTrue())
+		Expect(stdout.String()).To(ContainSubstring("[lion] ran tail app\n"))
+		Expect(stdout.String()).To(ContainSubstring("[tiger] ran tail app\n"))
+	})
+})
@@ -2,8 +2,10 @@
 package main
 
 import (
+	"github.com/cloud66-oss/cloud66"
 	"github.com/h2non/gock"
 	"io/ioutil"
+	"net/http"
 )
 
 func MockApiGetCall(request string, http_status int, fixture string) {
@@ -27,3 +29,16 @@ func MockApiPutCall(request string, http_status int, fixture string) {
 		Reply(http_status).
 		BodyString(listStacksFixture)
 }
+
+// points the global client to the mocked API. Returns a function restoring the previous client
+func MockApiClient() func() {
+	previous := client
+	client = cloud66.Client{
+		HTTP:   http.DefaultClient,
+		URL:    "https://app.cloud66.com/api/3",
+		Config: &cloud66.ClientConfig{},
+	}
+	return func() {
+		client = previous
+	}
+}
@@ -2,14 +2,40 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloud66-oss/cloud66"
 
 	"github.com/cloud66/cli"
+	"golang.org/x/crypto/ssh"
 )
 
+// firewall leases of tunnels are opened for this many minutes, and renewed every tunnelLeaseRenewal while the tunnel is open
+const tunnelLeaseMinutes = 2
+const tunnelLeaseRenewal = time.Minute
+
+// tunnelPreset picks the server and port of a database by its role
+type tunnelPreset struct {
+	Role string
+	Port int
+}
+
+var tunnelPresets = map[string]tunnelPreset{
+	"postgresql":    {Role: "postgresql", Port: 5432},
+	"mysql":         {Role: "mysql", Port: 3306},
+	"redis":         {Role: "redis", Port: 6379},
+	"mongodb":       {Role: "mongodb", Port: 27017},
+	"elasticsearch": {Role: "elasticsearch", Port: 9200},
+}
+
 var cmdTunnel = &Command{
 	Name:  "tunnel",
 	Build: buildBasicCommand,
@@ -26,6 +52,16 @@ var cmdTunnel = &Command{
 			Name:  "remote,r",
 			Usage: "remote port for the tunnel",
 		},
+		cli.StringSliceFlag{
+			Name:  "forward,f",
+			Usage: "local:[host:]port to forward. Can be used more than once",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringFlag{
+			Name:  "db",
+			Usage: "tunnel to a database: " + strings.Join(tunnelPresetNames(), ", "),
+		},
+		gatewayKeyFlag(),
 	},
 	Run:        runTunnel,
 	NeedsStack: true,
@@ -39,7 +75,8 @@ For this purpose, tunnel performs the following:
 1. Open a lease in port 22 for your local IP address
 2. Fetch your SSH key from your Cloud 66 acccount
 3. Start an SSH tunnel beween your machine and the server on the given ports
-4. Close the tunnel when you leave cx
+4. Renew the lease while the tunnel is open, and reconnect if the connection drops
+5. Close the tunnel when you leave cx
 
 To exit, use Ctrl-C
 
@@ -47,7 +84,9 @@ You need to have the correct access permissions to use this command.
 You can use either the server name (ie lion) or the server IP (ie. 123.123.123.123) or the server role (ie. web)
 with thie command.
 
-This command doesn't work with stacks using gateways (Bastion servers).
+For stacks using gateways (Bastion servers), the tunnel goes through the gateway. Give its key with --gateway-key,
+or set it in the profile with 'cx config update --gateway-key name=path'. A closed gateway can be opened while the
+tunnel is up, and is closed again on Ctrl-C.
 
 If a role is specified the command will connect to the first server with that role.
 Names are case insensitive and will work with the starting characters as well.
@@ -62,73 +101,347 @@ Once the tunnel is established, you can use your favourite MySQL client to conne
 If a local port is not specified, cx will use remote + 1 as a convention for the local port.
 For example, if you only specify --remote 5432 without explicitly specifying local, cx will use 5433 as the local port.
 
+To open several ports at once, use --forward local:port for each of them. The remote side is the server itself,
+unless a host is given with --forward local:host:port, like a database on a private IP only the server can reach.
+
+With --db, cx picks the first server with the role of the database and its default port:
+` + strings.Join(tunnelPresetNames(), ", ") + `. --server and --local can still be used to change them.
+
 Examples:
 $ cx tunnel -s mystack --server lion --local 3307 --remote 3306
-$ cx run -s mystack --server 52.65.34.98 --local 3307 --remote 3306
-$ cx run -s mystack --server web -l 3307 -r 3306
+$ cx tunnel -s mystack --server 52.65.34.98 --local 3307 --remote 3306
+$ cx tunnel -s mystack --server web -l 3307 -r 3306
+$ cx tunnel -s mystack --server web --forward 5433:5432 --forward 6380:10.0.0.12:6379
+$ cx tunnel -s mystack --db postgresql
 `,
 }
 
+// tunnelForward forwards a local port to a port on the server, or on a host seen from the server
+type tunnelForward struct {
+	Local      int
+	RemoteHost string
+	RemotePort int
+}
+
+// the remote address of the forward. Without a host it is the server itself
+func (f tunnelForward) remoteAddress(server cloud66.Server) string {
+	host := f.RemoteHost
+	if host == "" {
+		host = server.Address
+	}
+	return net.JoinHostPort(host, strconv.Itoa(f.RemotePort))
+}
+
+// parses local:port or local:host:port
+func parseTunnelForward(value string) (tunnelForward, error) {
+	invalid := fmt.Errorf("invalid --forward %q. Use local:port or local:host:port, like 5433:5432 or 6380:10.0.0.12:6379", value)
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return tunnelForward{}, invalid
+	}
+	var forward tunnelForward
+	var err error
+	if forward.Local, err = parseTunnelPortNumber(parts[0]); err != nil {
+		return tunnelForward{}, invalid
+	}
+	remote := parts[1]
+	if idx := strings.LastIndex(remote, ":"); idx != -1 {
+		forward.RemoteHost = strings.Trim(remote[:idx], "[]")
+		if forward.RemoteHost == "" {
+			return tunnelForward{}, invalid
+		}
+		remote = remote[idx+1:]
+	}
+	if forward.RemotePort, err = parseTunnelPortNumber(remote); err != nil {
+		return tunnelForward{}, invalid
+	}
+	return forward, nil
+}
+
+func parseTunnelPortNumber(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+func tunnelPresetNames() []string {
+	var names []string
+	for name := range tunnelPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func runTunnel(c *cli.Context) {
 	if runtime.GOOS == "windows" {
 		printFatal("Not supported on Windows")
 		os.Exit(2)
 	}
+	flagGatewayKey = c.String("gateway-key")
 
 	stack := mustStack(c)
 	serverName := c.String("server")
+
+	var forwards []tunnelForward
+	for _, value := range splitCommaValues(c.StringSlice("forward")) {
+		forward, err := parseTunnelForward(value)
+		if err != nil {
+			printFatal("%s", err.Error())
+		}
+		forwards = append(forwards, forward)
+	}
+	if c.IsSet("remote") {
+		forwards = append(forwards, tunnelForward{Local: c.Int("remote") + 1, RemotePort: c.Int("remote")})
+	}
+	if db := c.String("db"); db != "" {
+		preset, ok := tunnelPresets[strings.ToLower(db)]
+		if !ok {
+			printFatal("Unknown database %s. Use one of %s", db, strings.Join(tunnelPresetNames(), ", "))
+		}
+		if c.IsSet("remote") {
+			printFatal("--db and --remote can't be used together")
+		}
+		if serverName == "" {
+			serverName = preset.Role
+		}
+		forwards = append(forwards, tunnelForward{Local: preset.Port + 1, RemotePort: preset.Port})
+	}
+	if len(forwards) == 0 {
+		printFatal("No remote port specified. Use --remote, --forward or --db")
+	}
+	if c.IsSet("local") {
+		if c.IsSet("forward") {
+			printFatal("--local can't be used with --forward. Give the local port in each --forward")
+		}
+		forwards[len(forwards)-1].Local = c.Int("local")
+	}
+
 	servers, err := client.Servers(stack.Uid)
 	if err != nil {
-		printFatal(err.Error())
+		printFatal("%s", err.Error())
 	}
 
 	server, err := findServer(servers, serverName)
 	if err != nil {
-		printFatal(err.Error())
+		printFatal("%s", err.Error())
 	}
 	if server == nil {
-		printFatal("Server '" + serverName + "' not found")
+		printFatal("Server '%s' not found", serverName)
 	}
-	if !c.IsSet("remote") {
-		printFatal("No remote port specified. Use --remote")
+
+	err = TunnelToServer(*server, forwards)
+	if err != nil {
+		printFatal("%s", err.Error())
 	}
-	remotePort := c.Int("remote")
-	localPort := remotePort + 1
-	if c.IsSet("local") {
-		localPort = c.Int("local")
+}
+
+// opens the tunnel and keeps it open until cx is stopped
+func TunnelToServer(server cloud66.Server, forwards []tunnelForward) error {
+	tunnel, err := openServerTunnel(server, forwards, os.Stdout)
+	if err != nil {
+		return err
 	}
+	defer tunnel.Close()
 
-	err = TunnelToServer(*server, localPort, remotePort)
+	fmt.Println("Press Ctrl-C to exit")
+	tunnel.Wait()
+	return nil
+}
+
+// serverTunnel forwards local ports through a server. It renews the firewall lease of the server
+// while it is open, and reconnects when the connection drops. The local ports stay open while reconnecting
+type serverTunnel struct {
+	server    cloud66.Server
+	log       io.Writer
+	listeners []net.Listener
+	dial      serverDialer
+	// opens the firewall lease of the server, which is renewed every leaseRenewal
+	lease        func(cloud66.Server) error
+	leaseRenewal time.Duration
+
+	mutex  sync.Mutex
+	conn   *sshConnection
+	closed chan struct{}
+}
+
+// opens the firewall lease, connects to the server and starts listening on the local ports
+func openServerTunnel(server cloud66.Server, forwards []tunnelForward, log io.Writer) (*serverTunnel, error) {
+	key, err := prepareLocalSshKey(server)
 	if err != nil {
-		printFatal(err.Error())
+		return nil, err
 	}
+	dial := func(server cloud66.Server) (*sshConnection, error) {
+		return dialServer(server, key)
+	}
+	return startServerTunnel(server, forwards, log, dial, openTunnelLease, tunnelLeaseRenewal)
 }
 
-func TunnelToServer(server cloud66.Server, localPort int, remotePort int) error {
-	sshFile, err := prepareLocalSshKey(server)
-	must(err)
+// like openServerTunnel, dialing the server and opening its lease with the given functions
+func startServerTunnel(server cloud66.Server, forwards []tunnelForward, log io.Writer, dial serverDialer, lease func(cloud66.Server) error, leaseRenewal time.Duration) (*serverTunnel, error) {
+	if err := lease(server); err != nil {
+		return nil, err
+	}
+	conn, err := dial(server)
+	if err != nil {
+		return nil, err
+	}
 
-	// open the firewall
-	var timeToOpen = 2
+	tunnel := &serverTunnel{server: server, log: log, dial: dial, lease: lease, leaseRenewal: leaseRenewal, conn: conn, closed: make(chan struct{})}
+	for _, forward := range forwards {
+		remoteAddress := forward.remoteAddress(server)
+		fmt.Fprintf(log, "Opening Tunnel from local:%d to %s:%d (127.0.0.1:%d to %s)...\n", forward.Local, server.Name, forward.RemotePort, forward.Local, remoteAddress)
+		listener, err := listenLocal(forward.Local)
+		if err != nil {
+			tunnel.Close()
+			return nil, err
+		}
+		tunnel.listeners = append(tunnel.listeners, listener)
+		go tunnel.forward(listener, remoteAddress)
+	}
+
+	go tunnel.renewLease()
+	go tunnel.watch()
+	return tunnel, nil
+}
+
+// opens the firewall of the server for a tunnel
+func openTunnelLease(server cloud66.Server) error {
+	var timeToOpen = tunnelLeaseMinutes
 	genericRes, err := client.LeaseSync(server.StackUid, nil, &timeToOpen, nil, &server.Uid)
-	must(err)
+	if err != nil {
+		return err
+	}
 	if genericRes.Status != true {
-		printFatal("Unable to open server lease")
+		return fmt.Errorf("Unable to open server lease")
 	}
+	return nil
+}
 
-	fmt.Printf("Opening Tunnel from local:%d to %s:%d (127.0.0.1:%d to %s:%d)...\n", localPort, server.Name, remotePort, localPort, server.Address, remotePort)
-	fmt.Println("Press Ctrl-C to exit")
+func (t *serverTunnel) connection() *sshConnection {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conn
+}
 
-	return startProgram("ssh", []string{
-		server.UserName + "@" + server.Address,
-		"-i", sshFile,
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "CheckHostIP=no",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "LogLevel=QUIET",
-		"-o", "IdentitiesOnly=yes",
-		"-N",
-		"-L", fmt.Sprintf("%d:%s:%d", localPort, server.Address, remotePort),
-		"-p", "22",
-	})
+func (t *serverTunnel) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// forwards every connection to the listener to the remote address. Returns when the listener is closed
+func (t *serverTunnel) forward(listener net.Listener, remoteAddress string) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			conn := t.connection()
+			remote, err := conn.Dial("tcp", remoteAddress)
+			if _, refused := err.(*ssh.OpenChannelError); err != nil && !refused && !t.isClosed() {
+				// the connection could have dropped without being noticed yet. A refused channel means
+				// the server is reachable but the remote address isn't, so there is nothing to reconnect
+				if t.reconnect(conn) == nil {
+					remote, err = t.connection().Dial("tcp", remoteAddress)
+				}
+			}
+			if err != nil {
+				printError("Unable to forward to %s: %s", remoteAddress, err.Error())
+				local.Close()
+				return
+			}
+			pipeConnections(local, remote)
+		}()
+	}
+}
+
+// keeps the firewall lease open while the tunnel is
+func (t *serverTunnel) renewLease() {
+	ticker := time.NewTicker(t.leaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-ticker.C:
+			if err := t.lease(t.server); err != nil {
+				printWarning("Unable to renew the lease of %s: %s", t.server.Name, err.Error())
+			}
+		}
+	}
+}
+
+// reconnects whenever the connection to the server drops
+func (t *serverTunnel) watch() {
+	for {
+		conn := t.connection()
+		conn.Wait()
+		if t.isClosed() {
+			return
+		}
+		for t.reconnect(conn) != nil {
+			select {
+			case <-t.closed:
+				return
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}
+}
+
+// replaces a broken connection with a new one, unless another caller already did
+func (t *serverTunnel) reconnect(broken *sshConnection) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn != broken {
+		return nil
+	}
+	if t.isClosed() {
+		return fmt.Errorf("the tunnel is closed")
+	}
+	broken.Close()
+
+	fmt.Fprintf(t.log, "Connection to %s dropped. Reconnecting...\n", t.server.Name)
+	var err error
+	delay := time.Second
+	for attempt := 0; attempt < 5; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = t.lease(t.server); err != nil {
+			continue
+		}
+		var conn *sshConnection
+		if conn, err = t.dial(t.server); err == nil {
+			t.conn = conn
+			fmt.Fprintf(t.log, "Reconnected to %s\n", t.server.Name)
+			return nil
+		}
+	}
+	printError("Unable to reconnect to %s: %s", t.server.Name, err.Error())
+	return err
+}
+
+// blocks until the tunnel is closed
+func (t *serverTunnel) Wait() {
+	<-t.closed
+}
+
+func (t *serverTunnel) Close() {
+	if t == nil || t.isClosed() {
+		return
+	}
+	close(t.closed)
+	for _, listener := range t.listeners {
+		listener.Close()
+	}
+	t.connection().Close()
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/cloud66"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tunnel forwards", func() {
+	server := cloud66.Server{Address: "52.65.34.98"}
+
+	It("should forward to the server without a host", func() {
+		forward, err := parseTunnelForward("5433:5432")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(forward).To(Equal(tunnelForward{Local: 5433, RemotePort: 5432}))
+		Expect(forward.remoteAddress(server)).To(Equal("52.65.34.98:5432"))
+	})
+
+	It("should forward to a host seen from the server", func() {
+		forward, err := parseTunnelForward("6380:10.0.0.12:6379")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(forward.remoteAddress(server)).To(Equal("10.0.0.12:6379"))
+
+		forward, err = parseTunnelForward("6380:[fd00::12]:6379")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(forward.remoteAddress(server)).To(Equal("[fd00::12]:6379"))
+	})
+
+	It("should reject invalid forwards", func() {
+		for _, value := range []string{"5432", "0:5432", "5433:", "5433::5432", "5433:db:port"} {
+			_, err := parseTunnelForward(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
+
+var _ = Describe("Server tunnels", func() {
+	var restoreHome func()
+	var sshServer *testSSHServer
+	var echo net.Listener
+	var mutex sync.Mutex
+	var leases, dials int
+	var log bytes.Buffer
+	var logOut *lockedWriter
+	var tunnel *serverTunnel
+	var localPort int
+
+	lease := func(server cloud66.Server) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		leases++
+		return nil
+	}
+	dial := func(server cloud66.Server) (*sshConnection, error) {
+		mutex.Lock()
+		dials++
+		mutex.Unlock()
+		return dialSSH(sshTarget{Uid: server.Uid, User: "cloud66-user", Host: sshServer.Address(), KeyFile: sshServer.KeyFile})
+	}
+	counts := func() (int, int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return leases, dials
+	}
+	logged := func() string {
+		logOut.mutex.Lock()
+		defer logOut.mutex.Unlock()
+		return log.String()
+	}
+	roundTrip := func(message string) (string, error) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		fmt.Fprintln(conn, message)
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+
+	BeforeEach(func() {
+		restoreHome = useTempHome()
+		sshServer = startTestSSHServer()
+		leases, dials = 0, 0
+		log.Reset()
+		logOut = &lockedWriter{out: &log}
+
+		var err error
+		echo, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			for {
+				conn, err := echo.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(conn, conn)
+					conn.Close()
+				}()
+			}
+		}()
+		localPort, err = freeLocalPort()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		tunnel.Close()
+		echo.Close()
+		sshServer.Close()
+		restoreHome()
+	})
+
+	start := func(remotePort int) {
+		var err error
+		forward := tunnelForward{Local: localPort, RemoteHost: "127.0.0.1", RemotePort: remotePort}
+		tunnel, err = startServerTunnel(cloud66.Server{Uid: "1", Name: "lion"}, []tunnelForward{forward}, logOut, dial, lease, 20*time.Millisecond)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should renew the lease while it is open", func() {
+		start(echo.Addr().(*net.TCPAddr).Port)
+		Eventually(func() int {
+			leases, _ := counts()
+			return leases
+		}).Should(BeNumerically(">=", 3))
+
+		tunnel.Close()
+		leases, _ := counts()
+		Consistently(func() int {
+			leases, _ := counts()
+			return leases
+		}, 100*time.Millisecond).Should(BeNumerically("<=", leases+1))
+	})
+
+	It("should reconnect when the connection drops", func() {
+		start(echo.Addr().(*net.TCPAddr).Port)
+		Expect(roundTrip("hello")).To(Equal("hello\n"))
+
+		tunnel.connection().Close()
+		Eventually(func() int {
+			_, dials := counts()
+			return dials
+		}).Should(Equal(2))
+		Eventually(logged).Should(ContainSubstring("Reconnected to lion"))
+		Expect(roundTrip("again")).To(Equal("again\n"))
+	})
+
+	It("should not reconnect when the remote address refuses the connection", func() {
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		closedPort := closed.Addr().(*net.TCPAddr).Port
+		closed.Close()
+		start(closedPort)
+
+		_, err = roundTrip("hello")
+		Expect(err).To(HaveOccurred())
+		_, dials := counts()
+		Expect(dials).To(Equal(1))
+	})
+})
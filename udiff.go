@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the number of unchanged lines shown around each change of a unified diff
+const diffContextLines = 3
+
+type diffLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// returns the unified diff of two texts, or an empty string if they are the same
+func unifiedDiff(fromName string, toName string, from string, to string) string {
+	if from == to {
+		return ""
+	}
+
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// group the changes into hunks with their surrounding context
+	for start := 0; start < len(lines); {
+		first := start
+		for first < len(lines) && lines[first].kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		begin := first - diffContextLines
+		if begin < start {
+			begin = start
+		}
+		end := first
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			// stop once there are more unchanged lines than two contexts can cover
+			same := end
+			for same < len(lines) && lines[same].kind == ' ' {
+				same++
+			}
+			if same == len(lines) || same-end > 2*diffContextLines {
+				end += diffContextLines
+				if end > same {
+					end = same
+				}
+				break
+			}
+			end = same
+		}
+
+		fromStart, toStart := 1, 1
+		for _, line := range lines[:begin] {
+			if line.kind != '+' {
+				fromStart++
+			}
+			if line.kind != '-' {
+				toStart++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, line := range lines[begin:end] {
+			if line.kind != '+' {
+				fromCount++
+			}
+			if line.kind != '-' {
+				toCount++
+			}
+		}
+		if fromCount == 0 {
+			fromStart--
+		}
+		if toCount == 0 {
+			toStart--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, line := range lines[begin:end] {
+			fmt.Fprintf(&out, "%c%s\n", line.kind, line.text)
+		}
+		start = end
+	}
+
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// compares two lists of lines using their longest common subsequence
+func diffLines(from []string, to []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var result []diffLine
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			result = append(result, diffLine{' ', from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, diffLine{'-', from[i]})
+			i++
+		default:
+			result = append(result, diffLine{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		result = append(result, diffLine{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		result = append(result, diffLine{'+', to[j]})
+	}
+
+	return result
+}
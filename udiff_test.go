@@ -0,0 +1,33 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unified diff", func() {
+	It("should return nothing for equal texts", func() {
+		Expect(unifiedDiff("a", "b", "same\n", "same\n")).To(BeEmpty())
+	})
+
+	It("should show changes with their context", func() {
+		from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+		to := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+		Expect(unifiedDiff("a", "b", from, to)).To(Equal(`--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`))
+	})
+})
@@ -4,6 +4,7 @@ package main
 
 import (
 	"os"
+	"os/signal"
 	"syscall"
 )
 
@@ -14,3 +15,18 @@ func sysExec(path string, args []string, env []string) error {
 func homePath() string {
 	return os.Getenv("HOME")
 }
+
+// calls resize whenever the terminal window changes size, until the returned function is called
+func watchWindowSize(resize func()) func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			resize()
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
@@ -19,6 +19,7 @@ var cmdUpload = &Command{
 			Name:  "server",
 			Usage: "server to upload to",
 		},
+		gatewayKeyFlag(),
 	},
 	NeedsStack: true,
 	NeedsOrg:   false,
@@ -37,9 +38,12 @@ You can use either the server name (ie lion) or the server IP (ie. 123.123.123.1
 with thie command.
 
 If a role is specified the command will connect to the first server with that role.
+Files are uploaded through the deploy gateway of servers behind one (see --gateway-key).
 
 Names are case insensitive and will work with the starting characters as well.
 
+To copy only what changed, with a progress bar, or with many servers at once, use cx sync.
+
 This command is only supported on Linux and OS X.
 
 Examples:
@@ -55,6 +59,7 @@ func runUpload(c *cli.Context) {
 		printFatal("Not supported on Windows")
 		os.Exit(2)
 	}
+	flagGatewayKey = c.String("gateway-key")
 
 	stack := mustStack(c)
 
@@ -112,7 +117,7 @@ func sshToServerToUpload(server cloud66.Server, filePath string, targetDirectory
 		targetDir = targetDirectory[0]
 	}
 
-	sshFile, err := prepareLocalSshKey(server)
+	sshKey, err := prepareLocalSshKey(server)
 	must(err)
 
 	// open the firewall
@@ -126,16 +131,10 @@ func sshToServerToUpload(server cloud66.Server, filePath string, targetDirectory
 
 	fmt.Printf("Connecting to %s (%s)...\n", server.Name, server.Address)
 
-	return startProgram("scp", []string{
-		"-i", sshFile,
-		"-r",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "CheckHostIP=no",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "LogLevel=QUIET",
-		"-o", "IdentitiesOnly=yes",
-		"-P", "22",
-		filePath,
-		server.UserName + "@" + server.Address + ":" + targetDir,
-	})
+	conn, err := dialServer(server, sshKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Upload(filePath, targetDir)
 }
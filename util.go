@@ -27,9 +27,8 @@ import (
 )
 
 var (
-	lastCommandExecuted *exec.Cmd
-	magicComment        *regexp.Regexp
-	crc32t              *crc32.Table
+	magicComment *regexp.Regexp
+	crc32t       *crc32.Table
 )
 
 func init() {
@@ -39,11 +38,41 @@ func init() {
 
 // dotYaml represents the .cx.yml file
 type dotYamlData struct {
-	Args map[string]string `yaml:"args,omitempty"`
+	Args           map[string]string `yaml:"args,omitempty"`
+	Policy         *deployPolicy     `yaml:"policy,omitempty"`
+	Hooks          deployHooks       `yaml:"hooks,omitempty"`
+	SecretPatterns []string          `yaml:"secret_patterns,omitempty"`
 }
 
+// top level values of .cx.yml are used as arguments, while nested ones are named sections
 func (b *dotYamlData) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	return unmarshal(&b.Args)
+	var raw map[string]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	b.Args = make(map[string]string)
+	for key, value := range raw {
+		switch value.(type) {
+		case nil, map[interface{}]interface{}, []interface{}:
+			continue
+		}
+		b.Args[key] = fmt.Sprint(value)
+	}
+
+	var sections struct {
+		Policy         *deployPolicy `yaml:"policy"`
+		Hooks          deployHooks   `yaml:"hooks"`
+		SecretPatterns []string      `yaml:"secret_patterns"`
+	}
+	if err := unmarshal(&sections); err != nil {
+		return err
+	}
+	b.Policy = sections.Policy
+	b.Hooks = sections.Hooks
+	b.SecretPatterns = sections.SecretPatterns
+
+	return nil
 }
 
 func readDotYamlFile(path string) (*dotYamlData, error) {
@@ -150,7 +179,8 @@ func appendFiles(files []string, filename string) error {
 func must(err error) {
 	if err != nil {
 		if debugMode {
-			log.Fatal(err)
+			log.Print(err)
+			os.Exit(fatalExitCode)
 		}
 		printFatal(err.Error())
 	}
@@ -160,8 +190,14 @@ func printError(message string, args ...interface{}) {
 	log.Println(colorizeMessage("red", "error:", message, args...))
 }
 
-func printFatal(message string, args ...interface{}) {
-	log.Fatal(colorizeMessage("red", "error:", message, args...))
+// the exit code of printFatal. Commands using 1 for a result of their own, like 'stacks diff', change it
+var fatalExitCode = 1
+
+// logs the error and exits like log.Fatalf, closing the gateways opened by this session first
+func printFatal(format string, v ...interface{}) {
+	closeSessionGateways()
+	log.Print(colorizeMessage("red", "error:", "%s", fmt.Sprintf(format, v...)))
+	os.Exit(fatalExitCode)
 }
 
 func printWarning(message string, args ...interface{}) {
@@ -381,104 +417,29 @@ func runCommand(command string, args, env []string) error {
 	return sysExec(command, args, env)
 }
 
-func startProgram(command string, args []string) error {
-
-	if runtime.GOOS != "windows" {
-		p, err := exec.LookPath(command)
-		if err != nil {
-			log.Printf("Error finding path to %q: %s\n", command, err)
-			os.Exit(2)
-		}
-		command = p
-	}
-
-	if debugMode {
-		fmt.Printf("Running Command %s with (%s)\n", command, args)
-	}
-
-	cmd := exec.Command(command, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-
-	//TODO: ugly to mock startProgram when testing, need better solution
-	if underTest {
-		lastCommandExecuted = cmd
-		return nil
-	}
-
-	if err := cmd.Run(); err != nil {
+// writes the file in one go through a temporary file in the same directory, so readers never see a partial file
+func writeFileAtomically(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	return nil
-}
-
-func clearSshKeyCache() error {
-	dir := filepath.Join(homePath(), ".ssh")
-	files, err := ioutil.ReadDir(dir)
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp")
 	if err != nil {
 		return err
 	}
-
-	res := []string{}
-	for _, f := range files {
-		if !f.IsDir() && strings.HasPrefix(f.Name(), "cx_") && strings.HasSuffix(f.Name(), "_pkey") {
-			res = append(res, filepath.Join(dir, f.Name()))
-		}
-	}
-
-	for _, f := range res {
-		err := os.Remove(f)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func prepareLocalSshKey(server cloud66.Server) (string, error) {
-	var sshFile string
-	if server.PersonalKey {
-		oldFile := filepath.Join(homePath(), ".ssh", "cx_"+server.StackUid)
-		if b, _ := fileExists(sshFile); !b {
-			os.Remove(oldFile)
-		}
-		sshFile = filepath.Join(homePath(), ".ssh", "cx_"+server.StackUid+"_pkey")
-		// remove the old sshkey
-	} else {
-		sshFile = filepath.Join(homePath(), ".ssh", "cx_"+server.StackUid)
-	}
-
-	// do we have the key?
-	if b, _ := fileExists(sshFile); !b {
-		// get the content and write the file
-		fmt.Println("Fetching SSH key...")
-		sshKey, err := client.ServerKeyInformation(server.StackUid, server.Uid)
-		if err != nil {
-			return "", err
-		}
-		if err = writeSshFile(sshFile, sshKey); err != nil {
-			return "", err
-		}
-	} else {
-		if debugMode {
-			fmt.Println("Found an existing SSH key for this server")
-		}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
 	}
-	return sshFile, nil
-}
-
-func writeSshFile(filename string, content string) error {
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
-	defer file.Close()
-	if err != nil {
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
 		return err
 	}
-	if _, err := file.WriteString(content); err != nil {
+	if err := temp.Close(); err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(temp.Name(), filename)
 }
 
 func writeFile(filename, content string) error {
@@ -535,6 +496,19 @@ func fuzzyFind(s []string, item string, matchFirstIfMany bool) (int, error) {
 	return results[0], nil
 }
 
+// flattens the values of a repeatable flag which can also hold comma separated values
+func splitCommaValues(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
 func stringsIndex(s []string, item string) int {
 	for i := range s {
 		if s[i] == item {
@@ -28,3 +28,8 @@ func homePath() string {
 	}
 	return home
 }
+
+// there is no signal for window size changes on Windows, so the size of the remote terminal stays the same
+func watchWindowSize(resize func()) func() {
+	return func() {}
+}